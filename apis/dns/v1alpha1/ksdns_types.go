@@ -19,8 +19,10 @@ package v1alpha1
 import (
 	"fmt"
 	"net"
+	"strings"
 
 	rfc1035v1alpha1 "github.com/cldmnky/ksdns/pkg/zupd/api/v1alpha1"
+	"github.com/coredns/coredns/plugin/pkg/dnsutil"
 	"github.com/miekg/dns"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -29,9 +31,14 @@ import (
 const (
 	// TypeAvailableKsdns represents the status of the Deployment reconciliation
 	TypeAvailableKsdns = "Available"
-	// TypeAvailableKsdns represents the status used when the custom resource is deleted and the finalizer operations are must to occur.
+	// TypeProgressingKsdns represents the status used while the CoreDNS and zupd deployments roll out.
+	TypeProgressingKsdns = "Progressing"
+	// TypeDegradedKsdns represents the status used when a step of the reconciliation fails.
 	TypeDegradedKsdns = "Degraded"
-	nsName            = "ns.dns"
+	// TypeGlueKsdns represents the status of the glue of the zones, false while it holds the
+	// cluster IPs of a CoreDNS load balancer without an address.
+	TypeGlueKsdns = "Glue"
+	nsName        = "ns.dns"
 )
 
 // KsdnsSpec defines the desired state of Ksdns
@@ -43,6 +50,9 @@ type KsdnsSpec struct {
 	// CoreDNS configuration
 	// +kubebuilder:validation:Optional
 	CoreDNS CoreDNS `json:"coredns,omitempty"`
+	// Zupd configuration
+	// +kubebuilder:validation:Optional
+	Zupd Zupd `json:"zupd,omitempty"`
 	// Secret is the TSIG secret used for the the deployments.
 	// +kubebuilder:validation:Optional
 	Secret *corev1.LocalObjectReference `json:"secret,omitempty"`
@@ -51,6 +61,130 @@ type KsdnsSpec struct {
 	// +kubebuilder:validation:Optional
 	// +kube-builder:enum=CoreDNS;Zupd
 	Expose Expose `json:"expose,omitempty"`
+	// DNSSEC is the configuration for signing the zones.
+	// +kubebuilder:validation:Optional
+	DNSSEC *DNSSEC `json:"dnssec,omitempty"`
+	// Security is the configuration of the authentication of the requests.
+	// +kubebuilder:validation:Optional
+	Security Security `json:"security,omitempty"`
+	// ZonePruning is the policy for the Zones removed from Zones. Defaults to delete.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default:="delete"
+	// +kubebuilder:validation:Enum=delete;orphan;retain-dynamic-records
+	ZonePruning ZonePruningPolicy `json:"zonePruning,omitempty"`
+}
+
+// ZonePruningPolicy defines what happens to the Zone of a zone removed from a Ksdns.
+type ZonePruningPolicy string
+
+const (
+	// ZonePruningDelete deletes the Zone.
+	ZonePruningDelete ZonePruningPolicy = "delete"
+	// ZonePruningOrphan keeps the Zone, no longer owned by the Ksdns. It is still served, as all
+	// the Zones of the namespace, until it is deleted.
+	ZonePruningOrphan ZonePruningPolicy = "orphan"
+	// ZonePruningRetainDynamicRecords orphans the Zone if it holds records added by dynamic
+	// updates, e.g. by external-dns, and deletes it otherwise.
+	ZonePruningRetainDynamicRecords ZonePruningPolicy = "retain-dynamic-records"
+)
+
+// Security is the configuration of the authentication of the requests with the TSIG key of the
+// Secret. Queries are never authenticated.
+type Security struct {
+	// RequireTSIG refuses the dynamic updates and zone transfers that are not signed with the
+	// TSIG key. Defaults to true.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default:=true
+	RequireTSIG *bool `json:"requireTSIG,omitempty"`
+	// TSIGAlgorithm is the algorithm of the TSIG key. Only updates signed with this algorithm are
+	// accepted when TSIG is required.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default:="hmac-sha256"
+	// +kubebuilder:validation:Enum=hmac-sha256;hmac-sha512
+	TSIGAlgorithm string `json:"tsigAlgorithm,omitempty"`
+	// Keys are named TSIG keys accepted for the dynamic updates in addition to the key of the
+	// Secret, e.g. one for each client.
+	// +kubebuilder:validation:Optional
+	Keys []TSIGKey `json:"keys,omitempty"`
+	// RotationPeriod is the period after which the generated key of the Ksdns, also signing the
+	// zone transfers, is rotated. It is not rotated if unset, nor if the Secret is set.
+	// +kubebuilder:validation:Optional
+	RotationPeriod *metav1.Duration `json:"rotationPeriod,omitempty"`
+	// OverlapPeriod is the period during which the previous version of a rotated key is still
+	// accepted. Defaults to 24h.
+	// +kubebuilder:validation:Optional
+	OverlapPeriod metav1.Duration `json:"overlapPeriod,omitempty"`
+}
+
+// TSIGKey is a named TSIG key. A rotated key gets a new name, <name>-<version>.<ksdns name>, as a
+// TSIG key is identified by its name. The key is published in the Secret <ksdns name>-tsig-<name>,
+// in the tsigKey and tsigSecret fields and in the format of the consumer.
+type TSIGKey struct {
+	// Name is the name of the key, a DNS label.
+	Name string `json:"name"`
+	// Secret is a Secret holding the key in the tsigKey and tsigSecret fields, like the Secret of
+	// the Ksdns. The key is generated if unset.
+	// +kubebuilder:validation:Optional
+	Secret *corev1.LocalObjectReference `json:"secret,omitempty"`
+	// RotationPeriod is the period after which the generated key is rotated. It is not rotated if
+	// unset.
+	// +kubebuilder:validation:Optional
+	RotationPeriod *metav1.Duration `json:"rotationPeriod,omitempty"`
+	// Consumer adds the fields expected by the consumer of the key to its Secret: the environment
+	// of the RFC 2136 provider of external-dns, or the settings of the rfc2136 solver of
+	// cert-manager.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum=external-dns;cert-manager
+	Consumer string `json:"consumer,omitempty"`
+}
+
+// TSIGRequired returns true if TSIG is required, the default.
+func (s Security) TSIGRequired() bool {
+	return s.RequireTSIG == nil || *s.RequireTSIG
+}
+
+// DNSSEC is the configuration for signing the zones. The operator generates a KSK and a ZSK for
+// every zone, stores them in a Secret and rolls them over: the ZSK with a pre-publish rollover
+// and the KSK with a double-signature rollover.
+type DNSSEC struct {
+	// Algorithm is the DNSSEC algorithm of the keys.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default:="ECDSAP256SHA256"
+	// +kubebuilder:validation:Enum=RSASHA256;RSASHA512;ECDSAP256SHA256;ECDSAP384SHA384;ED25519
+	Algorithm string `json:"algorithm,omitempty"`
+	// KSKSize is the size of the KSK in bits, only used for RSA keys.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default:=2048
+	// +kubebuilder:validation:Minimum=1024
+	// +kubebuilder:validation:Maximum=4096
+	KSKSize int `json:"kskSize,omitempty"`
+	// ZSKSize is the size of the ZSK in bits, only used for RSA keys.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default:=1024
+	// +kubebuilder:validation:Minimum=1024
+	// +kubebuilder:validation:Maximum=4096
+	ZSKSize int `json:"zskSize,omitempty"`
+	// KSKRolloverPeriod is the time a KSK is used before it is rolled over.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default:="8760h"
+	KSKRolloverPeriod metav1.Duration `json:"kskRolloverPeriod,omitempty"`
+	// ZSKRolloverPeriod is the time a ZSK is used before it is rolled over.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default:="720h"
+	ZSKRolloverPeriod metav1.Duration `json:"zskRolloverPeriod,omitempty"`
+	// PrePublishPeriod is the time a new ZSK is published before it signs the zone, and the time
+	// an old ZSK is published after it stopped signing the zone.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default:="1h"
+	PrePublishPeriod metav1.Duration `json:"prePublishPeriod,omitempty"`
+	// DoubleSignaturePeriod is the time the old and the new KSK both sign the DNSKEY RRset. The DS
+	// record at the parent must be replaced with the DS record of the new KSK in this period.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default:="72h"
+	DoubleSignaturePeriod metav1.Duration `json:"doubleSignaturePeriod,omitempty"`
+	// NSEC3 enables NSEC3 instead of NSEC for authenticated denial of existence.
+	// +kubebuilder:validation:Optional
+	NSEC3 *rfc1035v1alpha1.NSEC3 `json:"nsec3,omitempty"`
 }
 
 // Expose is the configuration for exposing the services.
@@ -72,6 +206,11 @@ type ExposeService struct {
 	// +kubebuilder:default:="ClusterIP"
 	// +kubebuilder:validation:Enum=ClusterIP;NodePort;LoadBalancer
 	ServiceType corev1.ServiceType `json:"type,omitempty"`
+	// Port is the port of the service, 53 for CoreDNS and 1053 for Zupd by default.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	Port int32 `json:"port,omitempty"`
 	// ExternalIPs is a list of external IP addresses for the service.
 	// +kubebuilder:validation:Optional
 	ExternalIPs []string `json:"externalIPs,omitempty"`
@@ -83,6 +222,9 @@ type ExposeService struct {
 	// +kubebuilder:validation:Enum=metallb;aws
 	// +kubebuilder:validation:Optional
 	Provider string `json:"provider,omitempty"`
+	// AddressPool is the MetalLB address pool to allocate the IP of the LoadBalancer service from.
+	// +kubebuilder:validation:Optional
+	AddressPool string `json:"addressPool,omitempty"`
 	// Annotations are the annotations for the service.
 	// +kubebuilder:validation:Optional
 	Annotations map[string]string `json:"annotations,omitempty"`
@@ -95,41 +237,124 @@ type CoreDNS struct {
 	// +kubebuilder:validation:Optional
 	// +kubebuilder:default:="quay.io/ksdns/zupd:latest"
 	Image string `json:"image,omitempty"`
-	// ImagePullPolicy is the image pull policy for the CoreDNS deployment.
+	// Replicas is the number of replicas for the CoreDNS deployment.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default:=2
+	Replicas int32 `json:"replicas,omitempty"`
+	// PodSettings are the settings of the pods of the CoreDNS deployment. The pods are spread
+	// over the nodes and zones unless topologySpreadConstraints are set.
+	PodSettings `json:",inline"`
+}
+
+// Zupd is the configuration of the zupd deployment, which serves the dynamic updates and the
+// zone transfers to CoreDNS.
+type Zupd struct {
+	// Image is the image used for the zupd deployment. Defaults to the image of CoreDNS.
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	// +kubebuilder:validation:Optional
+	Image string `json:"image,omitempty"`
+	// Replicas is the number of replicas for the zupd deployment, one of which is the leader.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default:=2
+	Replicas int32 `json:"replicas,omitempty"`
+	// PodSettings are the settings of the pods of the zupd deployment.
+	PodSettings `json:",inline"`
+}
+
+// PodSettings are the settings of the pods of a deployment.
+type PodSettings struct {
+	// ImagePullPolicy is the image pull policy of the container.
 	// +kubebuilder:validation:Optional
 	ImagePullPolicy corev1.PullPolicy `json:"imagePullPolicy,omitempty"`
-	// Resources are the resource requirements for the CoreDNS deployment.
+	// Resources are the resource requirements of the container.
 	// +kubebuilder:validation:Optional
 	Resources corev1.ResourceRequirements `json:"resources,omitempty"`
-	// NodeSelector is the node selector for the CoreDNS deployment.
+	// NodeSelector is the node selector of the pods.
 	// +kubebuilder:validation:Optional
 	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
-	// Tolerations are the tolerations for the CoreDNS deployment.
+	// Tolerations are the tolerations of the pods.
 	// +kubebuilder:validation:Optional
 	Tolerations []corev1.Toleration `json:"tolerations,omitempty"`
-	// Affinity is the affinity for the CoreDNS deployment.
+	// Affinity is the affinity of the pods.
 	// +kubebuilder:validation:Optional
 	Affinity *corev1.Affinity `json:"affinity,omitempty"`
-	// Replicas is the number of replicas for the CoreDNS deployment.
+	// TopologySpreadConstraints are the topology spread constraints of the pods. The label
+	// selector of a constraint defaults to the pods of the deployment.
 	// +kubebuilder:validation:Optional
-	// +kubebuilder:default:=2
-	Replicas int32 `json:"replicas,omitempty"`
+	TopologySpreadConstraints []corev1.TopologySpreadConstraint `json:"topologySpreadConstraints,omitempty"`
 }
 
 type Zone struct {
+	// Origin is the name of the zone, without a trailing dot. It names the Zone object
+	// created for the zone, so it must be a lower case DNS-1123 subdomain.
 	Origin  string   `json:"origin,omitempty"`
 	Records []Record `json:"records,omitempty"`
+	// Nameservers are the names of the nameservers of the zone, used for the NS records. They
+	// should match the NS records of the delegation in the parent zone. Nameservers in the zone get
+	// glue records pointing at the CoreDNS service, nameservers outside of the zone are added
+	// without glue. Defaults to ns.dns.<origin>.
+	// +kubebuilder:validation:Optional
+	Nameservers []string `json:"nameservers,omitempty"`
+	// SOA holds the fields of the SOA record of the zone.
+	// +kubebuilder:validation:Optional
+	SOA *SOA `json:"soa,omitempty"`
+}
+
+// SOA holds the fields of the SOA record of a zone. The serial is set by the operator.
+type SOA struct {
+	// PrimaryNameserver is the name of the primary nameserver. Defaults to the first nameserver.
+	// +kubebuilder:validation:Optional
+	PrimaryNameserver string `json:"primaryNameserver,omitempty"`
+	// Mailbox is the mailbox of the person responsible for the zone, either as a domain name or
+	// as an email address. Defaults to hostmaster.<origin>.
+	// +kubebuilder:validation:Optional
+	Mailbox string `json:"mailbox,omitempty"`
+	// Refresh is the time in seconds after which the secondaries refresh the zone.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default=7200
+	// +kubebuilder:validation:Minimum=0
+	Refresh int32 `json:"refresh,omitempty"`
+	// Retry is the time in seconds after which the secondaries retry a failed refresh.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default=1800
+	// +kubebuilder:validation:Minimum=0
+	Retry int32 `json:"retry,omitempty"`
+	// Expire is the time in seconds after which the secondaries stop serving the zone, if it
+	// can not be refreshed.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default=86400
+	// +kubebuilder:validation:Minimum=0
+	Expire int32 `json:"expire,omitempty"`
+	// Minimum is the TTL in seconds of negative answers.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default=30
+	// +kubebuilder:validation:Minimum=0
+	Minimum int32 `json:"minimum,omitempty"`
+	// TTL is the TTL in seconds of the SOA record, the NS records and the glue records.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default=30
+	// +kubebuilder:validation:Minimum=0
+	TTL int32 `json:"ttl,omitempty"`
 }
 
-func (z *Zone) ToRfc1035Zone(nsIP net.IP) (*rfc1035v1alpha1.ZoneSpec, error) {
+// ToRfc1035Zone returns the spec of the rfc1035 Zone for z, with serial as the serial of the SOA
+// record. The nameservers in the zone get glue records for nsIPs. The zone only depends on its
+// arguments, so that it does not change if z does not.
+func (z *Zone) ToRfc1035Zone(serial uint32, nsIPs ...net.IP) (*rfc1035v1alpha1.ZoneSpec, error) {
 	if z.Origin == "" {
 		return nil, fmt.Errorf("origin cannot be empty")
 	}
-	soa, err := newSOARecord(z.Origin)
+	nameservers := z.Nameservers
+	if len(nameservers) == 0 {
+		nameservers = []string{dnsutil.Join(nsName, z.Origin)}
+	}
+	soa, err := newSOARecord(z.Origin, nameservers[0], z.SOA)
 	if err != nil {
 		return nil, err
 	}
-	ns, extra, err := newNSRecord(z.Origin, nsIP)
+	soa[0].(*dns.SOA).Serial = serial
+	ttl := soa[0].Header().Ttl
+	ns, extra, err := newNSRecord(z.Origin, ttl, nameservers, nsIPs...)
 	if err != nil {
 		return nil, err
 	}
@@ -155,8 +380,10 @@ func (z *Zone) ToRfc1035Zone(nsIP net.IP) (*rfc1035v1alpha1.ZoneSpec, error) {
 type Record struct {
 	// +kubebuilder:validation:Required
 	Name string `json:"name,omitempty"`
+	// Type is the type of the record. A, AAAA, CNAME, MX, NS, PTR, SRV, TXT, CAA, HTTPS, SVCB,
+	// TLSA, SSHFP and DS records are set with the typed fields, other types with rdata.
 	// +kubebuilder:validation:Required
-	// +kubebuilder:validation:Enum=A;CNAME;SRV;TXT
+	// +kubebuilder:validation:Pattern=`^[A-Z][A-Z0-9]*$`
 	Type string `json:"type,omitempty"`
 	// optional
 	// +kubebuilder:validation:Optional
@@ -165,7 +392,7 @@ type Record struct {
 	// +kubebuilder:validation:Maximum=2147483647
 	// +kubebuilder:default=30
 	TTL int `json:"ttl,omitempty"`
-	// required if SRV
+	// required if SRV, the preference of MX records and the priority of HTTPS and SVCB records
 	// +kubebuilder:validation:Optional
 	Priority uint16 `json:"priority,omitempty"`
 	// required if SRV
@@ -174,41 +401,276 @@ type Record struct {
 	// required if SRV
 	// +kubebuilder:validation:Optional
 	Port uint16 `json:"port,omitempty"`
+	// Target is the address of A and AAAA records, and the target of CNAME, MX, NS, PTR, SRV,
+	// HTTPS and SVCB records.
 	// +kubebuilder:validation:Optional
 	Target string `json:"data,omitempty"`
 	// Required if TXT
 	// +kubebuilder:validation:Optional
 	Text string `json:"text,omitempty"`
+	// Glue are the addresses of the target of NS records, for a nameserver in the zone.
+	// +kubebuilder:validation:Optional
+	Glue []string `json:"glue,omitempty"`
+	// Params are the SvcParams of HTTPS and SVCB records, e.g. alpn=h2,h3 port=8443.
+	// +kubebuilder:validation:Optional
+	Params string `json:"params,omitempty"`
+	// Required if CAA
+	// +kubebuilder:validation:Optional
+	CAA *CAA `json:"caa,omitempty"`
+	// Required if TLSA
+	// +kubebuilder:validation:Optional
+	TLSA *TLSA `json:"tlsa,omitempty"`
+	// Required if SSHFP
+	// +kubebuilder:validation:Optional
+	SSHFP *SSHFP `json:"sshfp,omitempty"`
+	// Required if DS
+	// +kubebuilder:validation:Optional
+	DS *DS `json:"ds,omitempty"`
+	// RData is the data of the record in the zone file format, for the types without typed
+	// fields. Names in rdata must be fully qualified.
+	// +kubebuilder:validation:Optional
+	RData string `json:"rdata,omitempty"`
 }
 
-func (r *Record) String() (string, error) {
+// CAA holds the fields of a CAA record.
+type CAA struct {
+	// +kubebuilder:validation:Optional
+	Flag uint8 `json:"flag,omitempty"`
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:Enum=issue;issuewild;iodef;issuemail
+	Tag string `json:"tag"`
+	// +kubebuilder:validation:Required
+	Value string `json:"value"`
+}
+
+// TLSA holds the fields of a TLSA record.
+type TLSA struct {
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:Maximum=3
+	Usage uint8 `json:"usage"`
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:Maximum=1
+	Selector uint8 `json:"selector"`
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:Maximum=2
+	MatchingType uint8 `json:"matchingType"`
+	// Certificate is the certificate association data, in hex.
+	// +kubebuilder:validation:Required
+	Certificate string `json:"certificate"`
+}
+
+// SSHFP holds the fields of a SSHFP record.
+type SSHFP struct {
+	// +kubebuilder:validation:Required
+	Algorithm uint8 `json:"algorithm"`
+	// +kubebuilder:validation:Required
+	Type uint8 `json:"type"`
+	// Fingerprint is the fingerprint of the key, in hex.
+	// +kubebuilder:validation:Required
+	Fingerprint string `json:"fingerprint"`
+}
+
+// DS holds the fields of a DS record.
+type DS struct {
+	// +kubebuilder:validation:Required
+	KeyTag uint16 `json:"keyTag"`
+	// +kubebuilder:validation:Required
+	Algorithm uint8 `json:"algorithm"`
+	// +kubebuilder:validation:Required
+	DigestType uint8 `json:"digestType"`
+	// Digest is the digest of the DNSKEY, in hex.
+	// +kubebuilder:validation:Required
+	Digest string `json:"digest"`
+}
+
+// Validate returns an error if the fields of the record type are missing or invalid.
+func (r *Record) Validate() error {
+	_, err := r.RRs()
+	return err
+}
+
+// RRs returns the RRs of the record, the record itself and the glue of NS records.
+func (r *Record) RRs() ([]dns.RR, error) {
+	if r.Name == "" {
+		return nil, fmt.Errorf("name cannot be empty")
+	}
+	if r.RData != "" {
+		rr, err := newFromRData(r.Name, uint32(r.TTL), r.Type, r.RData)
+		if err != nil {
+			return nil, err
+		}
+		return []dns.RR{rr}, nil
+	}
+	ttl := uint32(r.TTL)
 	var rr dns.RR
 	switch r.Type {
-	case "A":
-		rr = newA(r.Name, uint32(r.TTL), net.ParseIP(r.Target))
-	case "CNAME":
-		rr = newCNAME(r.Name, uint32(r.TTL), r.Target)
-	case "SRV":
-		rr = newSRV(r.Name, uint32(r.TTL), r.Target, r.Weight, r.Priority, r.Port)
+	case "A", "AAAA":
+		ip := net.ParseIP(r.Target)
+		if ip == nil || (r.Type == "A") != (ip.To4() != nil) {
+			return nil, fmt.Errorf("invalid address for %s record %s: %q", r.Type, r.Name, r.Target)
+		}
+		if r.Type == "A" {
+			rr = newA(r.Name, ttl, ip)
+		} else {
+			rr = newAddress(r.Name, ttl, ip, dns.TypeAAAA)
+		}
+	case "CNAME", "MX", "NS", "PTR", "SRV", "HTTPS", "SVCB":
+		if _, ok := dns.IsDomainName(r.Target); !ok || r.Target == "" {
+			return nil, fmt.Errorf("invalid target for %s record %s: %q", r.Type, r.Name, r.Target)
+		}
+		switch r.Type {
+		case "CNAME":
+			rr = newCNAME(r.Name, ttl, r.Target)
+		case "MX":
+			rr = newMX(r.Name, ttl, r.Target, r.Priority)
+		case "NS":
+			return newDelegation(r.Name, ttl, r.Target, r.Glue)
+		case "PTR":
+			rr = newPTR(r.Name, ttl, r.Target)
+		case "SRV":
+			if r.Port == 0 {
+				return nil, fmt.Errorf("missing port for SRV record %s", r.Name)
+			}
+			rr = newSRV(r.Name, ttl, r.Target, r.Weight, r.Priority, r.Port)
+		default:
+			var err error
+			if rr, err = newFromRData(r.Name, ttl, r.Type, fmt.Sprintf("%d %s %s", r.Priority, dns.Fqdn(r.Target), r.Params)); err != nil {
+				return nil, err
+			}
+		}
 	case "TXT":
-		rr = newTXT(r.Name, uint32(r.TTL), r.Text)
+		rr = newTXT(r.Name, ttl, r.Text)
+	case "CAA":
+		if r.CAA == nil {
+			return nil, fmt.Errorf("missing caa for CAA record %s", r.Name)
+		}
+		rr = &dns.CAA{Hdr: newHeader(r.Name, dns.TypeCAA, ttl), Flag: r.CAA.Flag, Tag: r.CAA.Tag, Value: r.CAA.Value}
+	case "TLSA":
+		if r.TLSA == nil || !isHex(r.TLSA.Certificate) {
+			return nil, fmt.Errorf("missing or invalid tlsa for TLSA record %s", r.Name)
+		}
+		rr = &dns.TLSA{Hdr: newHeader(r.Name, dns.TypeTLSA, ttl),
+			Usage: r.TLSA.Usage, Selector: r.TLSA.Selector, MatchingType: r.TLSA.MatchingType, Certificate: r.TLSA.Certificate}
+	case "SSHFP":
+		if r.SSHFP == nil || !isHex(r.SSHFP.Fingerprint) {
+			return nil, fmt.Errorf("missing or invalid sshfp for SSHFP record %s", r.Name)
+		}
+		rr = &dns.SSHFP{Hdr: newHeader(r.Name, dns.TypeSSHFP, ttl),
+			Algorithm: r.SSHFP.Algorithm, Type: r.SSHFP.Type, FingerPrint: r.SSHFP.Fingerprint}
+	case "DS":
+		if r.DS == nil || !isHex(r.DS.Digest) {
+			return nil, fmt.Errorf("missing or invalid ds for DS record %s", r.Name)
+		}
+		rr = &dns.DS{Hdr: newHeader(r.Name, dns.TypeDS, ttl),
+			KeyTag: r.DS.KeyTag, Algorithm: r.DS.Algorithm, DigestType: r.DS.DigestType, Digest: r.DS.Digest}
 	default:
-		return "", fmt.Errorf("unsupported record type: %s", r.Type)
+		return nil, fmt.Errorf("unsupported record type: %s, use rdata", r.Type)
 	}
+	return []dns.RR{rr}, nil
+}
 
-	//rr, err := dns.NewRR(fmt.Sprintf("%s %d %s %s %s", r.Name, r.TTL, r.Class, r.Type, r.Target))
-
-	return rr.String(), nil
+// String returns the record in the zone file format, one RR per line.
+func (r *Record) String() (string, error) {
+	rrs, err := r.RRs()
+	if err != nil {
+		return "", err
+	}
+	lines := make([]string, 0, len(rrs))
+	for _, rr := range rrs {
+		lines = append(lines, rr.String())
+	}
+	return strings.Join(lines, "\n"), nil
 }
 
 // KsdnsStatus defines the observed state of Ksdns
 type KsdnsStatus struct {
 	// +operator-sdk:csv:customresourcedefinitions:type=status
 	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type" protobuf:"bytes,1,rep,name=conditions"`
+	// ObservedGeneration is the generation of the spec the status was computed for.
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// CoreDNSReadyReplicas is the number of ready pods of the CoreDNS deployment.
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	CoreDNSReadyReplicas int32 `json:"coreDNSReadyReplicas,omitempty"`
+	// ZupdReadyReplicas is the number of ready pods of the zupd deployment.
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	ZupdReadyReplicas int32 `json:"zupdReadyReplicas,omitempty"`
+	// CoreDNSConfigHash is the hash of the Corefile and the TSIG secret of the CoreDNS pods. The
+	// pods roll when it changes.
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	CoreDNSConfigHash string `json:"coreDNSConfigHash,omitempty"`
+	// ZupdConfigHash is the hash of the Corefile and the TSIG secret of the zupd pods. The pods
+	// roll when it changes.
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	ZupdConfigHash string `json:"zupdConfigHash,omitempty"`
+	// CoreDNSEndpoint is the address of the CoreDNS service, as host:port. It is empty while the
+	// address of a LoadBalancer service is pending, and for a NodePort service without external
+	// IPs, which is reachable on its node port on every node.
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	CoreDNSEndpoint string `json:"coreDNSEndpoint,omitempty"`
+	// ZupdEndpoint is the address of the RFC 2136 update endpoint, as host:port. It is empty
+	// while the address of a LoadBalancer service is pending, and for a NodePort service without
+	// external IPs, which is reachable on its node port on every node.
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	ZupdEndpoint string `json:"zupdEndpoint,omitempty"`
+	// DNSSEC is the state of the keys of the signed zones.
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	DNSSEC []ZoneDNSSECStatus `json:"dnssec,omitempty"`
+	// TSIGKeys are the current versions of the TSIG keys.
+	// +kubebuilder:validation:Optional
+	TSIGKeys []TSIGKeyStatus `json:"tsigKeys,omitempty"`
+	// Zones are the origins of the zones served.
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	Zones []string `json:"zones,omitempty"`
+}
+
+// ZoneDNSSECStatus is the state of the keys of a signed zone.
+type ZoneDNSSECStatus struct {
+	// Zone is the origin of the zone.
+	Zone string `json:"zone"`
+	// DS is the DS record of the current KSK, to be published in the parent zone.
+	DS string `json:"ds,omitempty"`
+	// Keys are the keys of the zone.
+	Keys []DNSSECKey `json:"keys,omitempty"`
+	// NextRollover is the time of the next step of a key rollover.
+	NextRollover *metav1.Time `json:"nextRollover,omitempty"`
+}
+
+// TSIGKeyStatus is the current version of a TSIG key.
+type TSIGKeyStatus struct {
+	// Name is the name of the key in the spec, empty for the key of the Secret.
+	Name string `json:"name,omitempty"`
+	// KeyName is the TSIG name of the current version of the key.
+	KeyName string `json:"keyName"`
+	// Secret is the Secret the key is published in.
+	Secret string `json:"secret"`
+	// Created is the time the current version was created, unset for keys provided in a Secret.
+	Created *metav1.Time `json:"created,omitempty"`
+	// NextRotation is the time of the next rotation of the key.
+	NextRotation *metav1.Time `json:"nextRotation,omitempty"`
+}
+
+// DNSSECKey is a key of a signed zone.
+type DNSSECKey struct {
+	// KeyTag is the key tag of the key.
+	KeyTag uint16 `json:"keyTag"`
+	// Type is either KSK or ZSK.
+	Type string `json:"type"`
+	// State is either active, when the key signs the zone, or published.
+	State string `json:"state"`
+	// Created is the time the key was created.
+	Created metav1.Time `json:"created"`
 }
 
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Available",type=string,JSONPath=`.status.conditions[?(@.type=="Available")].status`
+//+kubebuilder:printcolumn:name="Degraded",type=string,JSONPath=`.status.conditions[?(@.type=="Degraded")].reason`,priority=1
+//+kubebuilder:printcolumn:name="CoreDNS",type=integer,JSONPath=`.status.coreDNSReadyReplicas`
+//+kubebuilder:printcolumn:name="Zupd",type=integer,JSONPath=`.status.zupdReadyReplicas`
+//+kubebuilder:printcolumn:name="Endpoint",type=string,JSONPath=`.status.coreDNSEndpoint`
+//+kubebuilder:printcolumn:name="Zones",type=string,JSONPath=`.status.zones`,priority=1
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 
 // Ksdns is the Schema for the ksdns API
 type Ksdns struct {
@@ -14,17 +14,33 @@ var _ = Describe("ksdns types", func() {
 	Describe("newSoaRecord", func() {
 		Context("when the zone is empty", func() {
 			It("should error", func() {
-				_, err := newSOARecord("")
+				_, err := newSOARecord("", "ns.dns", nil)
 				Expect(err).To(HaveOccurred())
 			})
 		})
 		Context("when the zone is not empty", func() {
 			It("should return a SOA", func() {
-				soa, err := newSOARecord("example.org")
+				soa, err := newSOARecord("example.org", "ns.dns.example.org", nil)
 				Expect(err).To(Not(HaveOccurred()))
 				Expect(soa).To(Not(BeNil()))
 				Expect(soa[0].Header().Name).To(Equal("example.org."))
 				Expect(soa[0].Header().Rrtype).To(Equal(dns.TypeSOA))
+				Expect(soa[0].(*dns.SOA).Mbox).To(Equal("hostmaster.example.org."))
+				Expect(soa[0].(*dns.SOA).Refresh).To(Equal(uint32(7200)))
+			})
+			It("should use the SOA fields", func() {
+				soa, err := newSOARecord("example.org", "ns.dns.example.org", &SOA{
+					PrimaryNameserver: "ns1.example.net",
+					Mailbox:           "dns-admin@example.org",
+					Refresh:           3600,
+					TTL:               300,
+				})
+				Expect(err).To(Not(HaveOccurred()))
+				Expect(soa[0].(*dns.SOA).Ns).To(Equal("ns1.example.net."))
+				Expect(soa[0].(*dns.SOA).Mbox).To(Equal("dns-admin.example.org."))
+				Expect(soa[0].(*dns.SOA).Refresh).To(Equal(uint32(3600)))
+				Expect(soa[0].(*dns.SOA).Retry).To(Equal(uint32(1800)))
+				Expect(soa[0].Header().Ttl).To(Equal(uint32(300)))
 			})
 		})
 	})
@@ -32,13 +48,13 @@ var _ = Describe("ksdns types", func() {
 	Describe("newNSRecord", func() {
 		Context("when the zone is empty", func() {
 			It("should error", func() {
-				_, _, err := newNSRecord("", net.ParseIP("192.168.1.1"))
+				_, _, err := newNSRecord("", 30, []string{"ns.dns"}, net.ParseIP("192.168.1.1"))
 				Expect(err).To(HaveOccurred())
 			})
 		})
 		Context("when the zone is not empty", func() {
 			It("should return a NS", func() {
-				ns, extra, err := newNSRecord("example.org", net.ParseIP("192.168.1.1"))
+				ns, extra, err := newNSRecord("example.org", 30, []string{"ns.dns.example.org"}, net.ParseIP("192.168.1.1"))
 				Expect(err).To(Not(HaveOccurred()))
 				Expect(ns).To(Not(BeNil()))
 				Expect(ns[0].Header().Name).To(Equal("example.org."))
@@ -46,6 +62,22 @@ var _ = Describe("ksdns types", func() {
 				Expect(extra).To(Not(BeNil()))
 				Expect(extra[0].Header().Name).To(Equal("ns.dns.example.org."))
 			})
+			It("should return glue for every address", func() {
+				_, extra, err := newNSRecord("example.org", 30, []string{"ns.dns.example.org"}, net.ParseIP("192.168.1.1"), net.ParseIP("2001:db8::1"))
+				Expect(err).To(Not(HaveOccurred()))
+				Expect(extra).To(HaveLen(2))
+				Expect(extra[0].Header().Rrtype).To(Equal(dns.TypeA))
+				Expect(extra[1].Header().Rrtype).To(Equal(dns.TypeAAAA))
+				Expect(extra[1].(*dns.AAAA).AAAA.String()).To(Equal("2001:db8::1"))
+			})
+			It("should not return glue for nameservers outside of the zone", func() {
+				ns, extra, err := newNSRecord("example.org", 30, []string{"ns1.example.org", "ns1.example.net."}, net.ParseIP("192.168.1.1"))
+				Expect(err).To(Not(HaveOccurred()))
+				Expect(ns).To(HaveLen(2))
+				Expect(ns[1].(*dns.NS).Ns).To(Equal("ns1.example.net."))
+				Expect(extra).To(HaveLen(1))
+				Expect(extra[0].Header().Name).To(Equal("ns1.example.org."))
+			})
 		})
 	})
 	Describe("ToRfc1035Zone", func() {
@@ -54,7 +86,7 @@ var _ = Describe("ksdns types", func() {
 				z := &Zone{
 					Origin: "",
 				}
-				_, err := z.ToRfc1035Zone(net.ParseIP("192.168.1.1"))
+				_, err := z.ToRfc1035Zone(1, net.ParseIP("192.168.1.1"))
 				Expect(err).To(HaveOccurred())
 			})
 		})
@@ -63,7 +95,7 @@ var _ = Describe("ksdns types", func() {
 				z := &Zone{
 					Origin: "example.org",
 				}
-				rfc1035, err := z.ToRfc1035Zone(net.ParseIP("192.168.1.1"))
+				rfc1035, err := z.ToRfc1035Zone(1, net.ParseIP("192.168.1.1"))
 				Expect(err).To(Not(HaveOccurred()))
 				Expect(rfc1035.Zone).To(Not(BeNil()))
 				// Parse the zone
@@ -72,6 +104,22 @@ var _ = Describe("ksdns types", func() {
 				Expect(parsedZone).To(Not(BeNil()))
 			})
 		})
+		Context("when the zone is rendered again", func() {
+			It("should return the same zone with the serial", func() {
+				z := &Zone{
+					Origin:  "example.org",
+					Records: []Record{{Name: "www", Type: "A", TTL: 300, Target: "10.10.10.10"}},
+				}
+				first, err := z.ToRfc1035Zone(42, net.ParseIP("192.168.1.1"))
+				Expect(err).To(Not(HaveOccurred()))
+				second, err := z.ToRfc1035Zone(42, net.ParseIP("192.168.1.1"))
+				Expect(err).To(Not(HaveOccurred()))
+				Expect(second.Zone).To(Equal(first.Zone))
+				parsedZone, err := file.Parse(strings.NewReader(first.Zone), "example.org.", "example.org", 0)
+				Expect(err).To(Not(HaveOccurred()))
+				Expect(parsedZone.Apex.SOA.Serial).To(Equal(uint32(42)))
+			})
+		})
 		Context("when the zone has records", func() {
 			It("should return a Zone with records", func() {
 				z := &Zone{
@@ -109,7 +157,7 @@ var _ = Describe("ksdns types", func() {
 						},
 					},
 				}
-				rfc1035, err := z.ToRfc1035Zone(net.ParseIP("192.168.1.1"))
+				rfc1035, err := z.ToRfc1035Zone(1, net.ParseIP("192.168.1.1"))
 				Expect(err).To(Not(HaveOccurred()))
 				Expect(rfc1035.Zone).To(Not(BeNil()))
 				// Parse the zone
@@ -124,15 +172,67 @@ var _ = Describe("ksdns types", func() {
 					Records: []Record{
 						{
 							Name:   "www",
-							Type:   "MX",
+							Type:   "NAPTR",
 							TTL:    300,
 							Target: "192.168.1.1.",
 						},
 					},
 				}
-				_, err := z.ToRfc1035Zone(net.ParseIP("192.168.1.1"))
+				_, err := z.ToRfc1035Zone(1, net.ParseIP("192.168.1.1"))
 				Expect(err).To(HaveOccurred())
 			})
+			It("should return a Zone with the typed and rdata records", func() {
+				z := &Zone{
+					Origin: "example.org",
+					Records: []Record{
+						{Name: "www", Type: "AAAA", TTL: 300, Target: "2001:db8::1"},
+						{Name: "@", Type: "MX", TTL: 300, Target: "mail.example.org", Priority: 10},
+						{Name: "sub", Type: "NS", TTL: 300, Target: "ns1.sub.example.org", Glue: []string{"10.0.0.53", "2001:db8::53"}},
+						{Name: "1", Type: "PTR", TTL: 300, Target: "www.example.org"},
+						{Name: "@", Type: "CAA", TTL: 300, CAA: &CAA{Tag: "issue", Value: "letsencrypt.org"}},
+						{Name: "www", Type: "HTTPS", TTL: 300, Target: ".", Priority: 1, Params: "alpn=h2,h3"},
+						{Name: "_svc", Type: "SVCB", TTL: 300, Target: "svc.example.org", Priority: 1, Params: "port=8443"},
+						{Name: "_443._tcp.www", Type: "TLSA", TTL: 300, TLSA: &TLSA{Usage: 3, Selector: 1, MatchingType: 1, Certificate: "0123456789abcdef"}},
+						{Name: "www", Type: "SSHFP", TTL: 300, SSHFP: &SSHFP{Algorithm: 4, Type: 2, Fingerprint: "0123456789abcdef"}},
+						{Name: "sub", Type: "DS", TTL: 300, DS: &DS{KeyTag: 12345, Algorithm: 13, DigestType: 2, Digest: "0123456789abcdef"}},
+						{Name: "sip", Type: "NAPTR", TTL: 300, RData: `100 10 "S" "SIP+D2U" "" _sip._udp.example.org.`},
+					},
+				}
+				rfc1035, err := z.ToRfc1035Zone(1, net.ParseIP("192.168.1.1"))
+				Expect(err).To(Not(HaveOccurred()))
+				parsedZone, err := file.Parse(strings.NewReader(rfc1035.Zone), "example.org.", "example.org", 0)
+				Expect(err).To(Not(HaveOccurred()))
+				for _, name := range []string{"www.example.org.", "ns1.sub.example.org.", "_443._tcp.www.example.org.", "sip.example.org."} {
+					_, ok := parsedZone.Search(name)
+					Expect(ok).To(BeTrue(), name)
+				}
+				e, _ := parsedZone.Search("sub.example.org.")
+				Expect(e.Type(dns.TypeNS)).To(HaveLen(1))
+				Expect(e.Type(dns.TypeDS)).To(HaveLen(1))
+				e, _ = parsedZone.Search("ns1.sub.example.org.")
+				Expect(e.Type(dns.TypeA)).To(HaveLen(1))
+				Expect(e.Type(dns.TypeAAAA)).To(HaveLen(1))
+			})
 		})
 	})
+
+	Describe("Record.Validate", func() {
+		DescribeTable("invalid records",
+			func(r Record) {
+				Expect(r.Validate()).To(HaveOccurred())
+			},
+			Entry("A with an IPv6 address", Record{Name: "www", Type: "A", Target: "2001:db8::1"}),
+			Entry("AAAA with an IPv4 address", Record{Name: "www", Type: "AAAA", Target: "10.0.0.1"}),
+			Entry("MX without target", Record{Name: "www", Type: "MX"}),
+			Entry("NS with invalid glue", Record{Name: "sub", Type: "NS", Target: "ns1.sub.example.org", Glue: []string{"ns1"}}),
+			Entry("CAA without fields", Record{Name: "www", Type: "CAA"}),
+			Entry("TLSA with invalid data", Record{Name: "www", Type: "TLSA", TLSA: &TLSA{Certificate: "xyz"}}),
+			Entry("SSHFP without fields", Record{Name: "www", Type: "SSHFP"}),
+			Entry("DS without fields", Record{Name: "www", Type: "DS"}),
+			Entry("HTTPS with invalid params", Record{Name: "www", Type: "HTTPS", Target: ".", Params: "foo"}),
+			Entry("unknown type", Record{Name: "www", Type: "FOO", RData: "1"}),
+			Entry("invalid rdata", Record{Name: "www", Type: "NAPTR", RData: "foo"}),
+			Entry("empty name", Record{Type: "A", Target: "10.0.0.1"}),
+		)
+	})
 })
@@ -0,0 +1,335 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"net"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+// Defaults, as in the kubebuilder markers
+const (
+	DefaultImage    = "quay.io/ksdns/zupd:latest"
+	DefaultReplicas = int32(2)
+	// DefaultTSIGAlgorithm is the algorithm of the TSIG key
+	DefaultTSIGAlgorithm = "hmac-sha256"
+)
+
+// log is for logging in this package.
+var ksdnslog = logf.Log.WithName("ksdns-resource")
+
+func (r *Ksdns) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		Complete()
+}
+
+//+kubebuilder:webhook:path=/mutate-dns-ksdns-io-v1alpha1-ksdns,mutating=true,failurePolicy=fail,sideEffects=None,groups=dns.ksdns.io,resources=ksdns,verbs=create;update,versions=v1alpha1,name=mksdns.kb.io,admissionReviewVersions=v1
+
+var _ webhook.Defaulter = &Ksdns{}
+
+// Default implements webhook.Defaulter so a webhook will be registered for the type
+func (r *Ksdns) Default() {
+	ksdnslog.Info("default", "name", r.Name)
+
+	if r.Spec.CoreDNS.Image == "" {
+		r.Spec.CoreDNS.Image = DefaultImage
+	}
+	if r.Spec.CoreDNS.Replicas == 0 {
+		r.Spec.CoreDNS.Replicas = DefaultReplicas
+	}
+	if r.Spec.Zupd.Replicas == 0 {
+		r.Spec.Zupd.Replicas = DefaultReplicas
+	}
+	for _, expose := range []**ExposeService{&r.Spec.Expose.CoreDNS, &r.Spec.Expose.Zupd} {
+		if *expose == nil {
+			*expose = &ExposeService{}
+		}
+		if (*expose).ServiceType == "" {
+			(*expose).ServiceType = corev1.ServiceTypeClusterIP
+		}
+	}
+	for i := range r.Spec.Zones {
+		z := &r.Spec.Zones[i]
+		if z.SOA != nil {
+			z.SOA.Default()
+		}
+		for j := range z.Records {
+			if z.Records[j].TTL == 0 {
+				z.Records[j].TTL = defaultTTL
+			}
+		}
+	}
+	if r.Spec.DNSSEC != nil {
+		r.Spec.DNSSEC.Default()
+	}
+	r.Spec.Security.Default()
+	if r.Spec.ZonePruning == "" {
+		r.Spec.ZonePruning = ZonePruningDelete
+	}
+}
+
+// Default sets the unset fields of the security configuration to their defaults.
+func (s *Security) Default() {
+	if s.RequireTSIG == nil {
+		requireTSIG := true
+		s.RequireTSIG = &requireTSIG
+	}
+	if s.TSIGAlgorithm == "" {
+		s.TSIGAlgorithm = DefaultTSIGAlgorithm
+	}
+	if s.OverlapPeriod.Duration == 0 {
+		s.OverlapPeriod.Duration = 24 * time.Hour
+	}
+}
+
+// Default sets the unset fields of the SOA record to their defaults.
+func (s *SOA) Default() {
+	for _, f := range []struct {
+		v   *int32
+		def int32
+	}{
+		{&s.Refresh, defaultRefresh},
+		{&s.Retry, defaultRetry},
+		{&s.Expire, defaultExpire},
+		{&s.Minimum, defaultMinimum},
+		{&s.TTL, defaultTTL},
+	} {
+		if *f.v == 0 {
+			*f.v = f.def
+		}
+	}
+}
+
+// Default sets the unset fields of the DNSSEC configuration to their defaults.
+func (d *DNSSEC) Default() {
+	if d.Algorithm == "" {
+		d.Algorithm = "ECDSAP256SHA256"
+	}
+	if d.KSKSize == 0 {
+		d.KSKSize = 2048
+	}
+	if d.ZSKSize == 0 {
+		d.ZSKSize = 1024
+	}
+	for _, f := range []struct {
+		v   *metav1.Duration
+		def time.Duration
+	}{
+		{&d.KSKRolloverPeriod, 365 * 24 * time.Hour},
+		{&d.ZSKRolloverPeriod, 30 * 24 * time.Hour},
+		{&d.PrePublishPeriod, time.Hour},
+		{&d.DoubleSignaturePeriod, 72 * time.Hour},
+	} {
+		if f.v.Duration == 0 {
+			f.v.Duration = f.def
+		}
+	}
+}
+
+//+kubebuilder:webhook:path=/validate-dns-ksdns-io-v1alpha1-ksdns,mutating=false,failurePolicy=fail,sideEffects=None,groups=dns.ksdns.io,resources=ksdns,verbs=create;update,versions=v1alpha1,name=vksdns.kb.io,admissionReviewVersions=v1
+
+var _ webhook.Validator = &Ksdns{}
+
+// ValidateCreate implements webhook.Validator so a webhook will be registered for the type
+func (r *Ksdns) ValidateCreate() error {
+	ksdnslog.Info("validate create", "name", r.Name)
+	return r.validate()
+}
+
+// ValidateUpdate implements webhook.Validator so a webhook will be registered for the type
+func (r *Ksdns) ValidateUpdate(old runtime.Object) error {
+	ksdnslog.Info("validate update", "name", r.Name)
+	return r.validate()
+}
+
+// ValidateDelete implements webhook.Validator so a webhook will be registered for the type
+func (r *Ksdns) ValidateDelete() error {
+	return nil
+}
+
+func (r *Ksdns) validate() error {
+	errs := field.ErrorList{}
+	spec := field.NewPath("spec")
+
+	origins := map[string]bool{}
+	for i, z := range r.Spec.Zones {
+		path := spec.Child("zones").Index(i)
+		if z.Origin == "" {
+			errs = append(errs, field.Required(path.Child("origin"), "origin cannot be empty"))
+			continue
+		}
+		// The origin is used as the name of the Zone object, so it must be
+		// a DNS-1123 subdomain: lower case and without a trailing dot.
+		if msgs := validation.IsDNS1123Subdomain(z.Origin); len(msgs) > 0 {
+			errs = append(errs, field.Invalid(path.Child("origin"), z.Origin, strings.Join(msgs, ", ")))
+			continue
+		}
+		origin := strings.ToLower(dns.Fqdn(z.Origin))
+		if _, ok := dns.IsDomainName(origin); !ok {
+			errs = append(errs, field.Invalid(path.Child("origin"), z.Origin, "not a domain name"))
+			continue
+		}
+		if origins[origin] {
+			errs = append(errs, field.Duplicate(path.Child("origin"), z.Origin))
+		}
+		origins[origin] = true
+		valid := len(errs)
+		for j, ns := range z.Nameservers {
+			if _, ok := dns.IsDomainName(ns); !ok || ns == "" {
+				errs = append(errs, field.Invalid(path.Child("nameservers").Index(j), ns, "not a domain name"))
+			}
+		}
+		for j, record := range z.Records {
+			if err := record.Validate(); err != nil {
+				errs = append(errs, field.Invalid(path.Child("records").Index(j), record.Name, err.Error()))
+			}
+		}
+		if len(errs) > valid {
+			continue
+		}
+		if _, err := z.ToRfc1035Zone(0); err != nil {
+			errs = append(errs, field.Invalid(path, z.Origin, err.Error()))
+		}
+	}
+
+	errs = append(errs, validateExpose(spec.Child("expose", "coredns"), r.Spec.Expose.CoreDNS)...)
+	errs = append(errs, validateExpose(spec.Child("expose", "zupd"), r.Spec.Expose.Zupd)...)
+	if r.Spec.DNSSEC != nil {
+		errs = append(errs, validateDNSSEC(spec.Child("dnssec"), *r.Spec.DNSSEC)...)
+	}
+	errs = append(errs, validateSecurity(spec.Child("security"), r.Spec.Security, r.Spec.Secret != nil)...)
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(GroupVersion.WithKind("Ksdns").GroupKind(), r.Name, errs)
+}
+
+func validateExpose(path *field.Path, expose *ExposeService) field.ErrorList {
+	errs := field.ErrorList{}
+	if expose == nil {
+		return errs
+	}
+	switch expose.ServiceType {
+	case "", corev1.ServiceTypeClusterIP, corev1.ServiceTypeNodePort, corev1.ServiceTypeLoadBalancer:
+	default:
+		errs = append(errs, field.NotSupported(path.Child("type"), expose.ServiceType,
+			[]string{string(corev1.ServiceTypeClusterIP), string(corev1.ServiceTypeNodePort), string(corev1.ServiceTypeLoadBalancer)}))
+	}
+	for i, ip := range expose.ExternalIPs {
+		if net.ParseIP(ip) == nil {
+			errs = append(errs, field.Invalid(path.Child("externalIPs").Index(i), ip, "not an IP address"))
+		}
+	}
+	if expose.LoadBalancerIP != "" {
+		lbIP := path.Child("loadBalancerIP")
+		switch {
+		case net.ParseIP(expose.LoadBalancerIP) == nil:
+			errs = append(errs, field.Invalid(lbIP, expose.LoadBalancerIP, "not an IP address"))
+		case expose.ServiceType != corev1.ServiceTypeLoadBalancer:
+			errs = append(errs, field.Invalid(lbIP, expose.LoadBalancerIP, "requires a service of type LoadBalancer"))
+		case expose.Provider == "aws":
+			errs = append(errs, field.Invalid(lbIP, expose.LoadBalancerIP, "not supported by the aws provider"))
+		}
+	}
+	if expose.AddressPool != "" && expose.Provider != "metallb" {
+		errs = append(errs, field.Invalid(path.Child("addressPool"), expose.AddressPool, "requires the metallb provider"))
+	}
+	return errs
+}
+
+func validateDNSSEC(path *field.Path, d DNSSEC) field.ErrorList {
+	errs := field.ErrorList{}
+	d.Default()
+	if _, ok := dns.StringToAlgorithm[d.Algorithm]; !ok {
+		errs = append(errs, field.Invalid(path.Child("algorithm"), d.Algorithm, "unsupported algorithm"))
+	}
+	if d.PrePublishPeriod.Duration >= d.ZSKRolloverPeriod.Duration {
+		errs = append(errs, field.Invalid(path.Child("prePublishPeriod"), d.PrePublishPeriod.Duration.String(), "must be shorter than zskRolloverPeriod"))
+	}
+	if d.DoubleSignaturePeriod.Duration >= d.KSKRolloverPeriod.Duration {
+		errs = append(errs, field.Invalid(path.Child("doubleSignaturePeriod"), d.DoubleSignaturePeriod.Duration.String(), "must be shorter than kskRolloverPeriod"))
+	}
+	for _, p := range []struct {
+		name string
+		d    metav1.Duration
+	}{
+		{"kskRolloverPeriod", d.KSKRolloverPeriod},
+		{"zskRolloverPeriod", d.ZSKRolloverPeriod},
+		{"prePublishPeriod", d.PrePublishPeriod},
+		{"doubleSignaturePeriod", d.DoubleSignaturePeriod},
+	} {
+		if p.d.Duration < 0 {
+			errs = append(errs, field.Invalid(path.Child(p.name), p.d.Duration.String(), "must be positive"))
+		}
+	}
+	return errs
+}
+
+func validateSecurity(path *field.Path, s Security, secret bool) field.ErrorList {
+	errs := field.ErrorList{}
+	s.Default()
+	switch s.TSIGAlgorithm {
+	case "hmac-sha256", "hmac-sha512":
+	default:
+		errs = append(errs, field.NotSupported(path.Child("tsigAlgorithm"), s.TSIGAlgorithm, []string{"hmac-sha256", "hmac-sha512"}))
+	}
+	if s.OverlapPeriod.Duration < 0 {
+		errs = append(errs, field.Invalid(path.Child("overlapPeriod"), s.OverlapPeriod.Duration.String(), "must be positive"))
+	}
+	validateRotation := func(path *field.Path, rotation *metav1.Duration, secret bool) {
+		switch {
+		case rotation == nil:
+		case secret:
+			errs = append(errs, field.Invalid(path, rotation.Duration.String(), "keys provided in a Secret are not rotated"))
+		case rotation.Duration <= s.OverlapPeriod.Duration:
+			errs = append(errs, field.Invalid(path, rotation.Duration.String(), "must be longer than overlapPeriod"))
+		}
+	}
+	validateRotation(path.Child("rotationPeriod"), s.RotationPeriod, secret)
+	names := map[string]bool{}
+	for i, k := range s.Keys {
+		keyPath := path.Child("keys").Index(i)
+		if msgs := validation.IsDNS1123Label(k.Name); len(msgs) > 0 {
+			errs = append(errs, field.Invalid(keyPath.Child("name"), k.Name, strings.Join(msgs, ", ")))
+		}
+		if names[k.Name] {
+			errs = append(errs, field.Duplicate(keyPath.Child("name"), k.Name))
+		}
+		names[k.Name] = true
+		validateRotation(keyPath.Child("rotationPeriod"), k.RotationPeriod, k.Secret != nil)
+		switch k.Consumer {
+		case "", "external-dns", "cert-manager":
+		default:
+			errs = append(errs, field.NotSupported(keyPath.Child("consumer"), k.Consumer, []string{"external-dns", "cert-manager"}))
+		}
+	}
+	return errs
+}
@@ -0,0 +1,136 @@
+package v1alpha1
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("ksdns webhook", func() {
+	validKsdns := func() *Ksdns {
+		return &Ksdns{
+			ObjectMeta: metav1.ObjectMeta{Name: "ksdns"},
+			Spec: KsdnsSpec{
+				Zones: []Zone{
+					{
+						Origin: "example.org",
+						Records: []Record{
+							{Name: "www", Type: "A", Target: "10.0.0.1"},
+							{Name: "_sip._tcp", Type: "SRV", Target: "sip.example.org", Port: 5060},
+						},
+					},
+				},
+			},
+		}
+	}
+
+	Describe("Default", func() {
+		It("should set the defaults", func() {
+			k := validKsdns()
+			k.Spec.Zones[0].SOA = &SOA{Refresh: 3600}
+			k.Spec.DNSSEC = &DNSSEC{}
+			k.Default()
+			Expect(k.Spec.CoreDNS.Image).To(Equal(DefaultImage))
+			Expect(k.Spec.CoreDNS.Replicas).To(Equal(DefaultReplicas))
+			Expect(k.Spec.Zupd.Replicas).To(Equal(DefaultReplicas))
+			Expect(k.Spec.Expose.CoreDNS.ServiceType).To(Equal(corev1.ServiceTypeClusterIP))
+			Expect(k.Spec.Expose.Zupd.ServiceType).To(Equal(corev1.ServiceTypeClusterIP))
+			Expect(k.Spec.Zones[0].SOA.Refresh).To(Equal(int32(3600)))
+			Expect(k.Spec.Zones[0].SOA.Retry).To(Equal(int32(defaultRetry)))
+			Expect(k.Spec.Zones[0].Records[0].TTL).To(Equal(defaultTTL))
+			Expect(k.Spec.DNSSEC.Algorithm).To(Equal("ECDSAP256SHA256"))
+			Expect(k.Spec.DNSSEC.ZSKRolloverPeriod.Duration).To(Equal(30 * 24 * time.Hour))
+			Expect(k.Spec.Security.TSIGRequired()).To(BeTrue())
+			Expect(*k.Spec.Security.RequireTSIG).To(BeTrue())
+			Expect(k.Spec.Security.TSIGAlgorithm).To(Equal(DefaultTSIGAlgorithm))
+			Expect(k.Spec.Security.OverlapPeriod.Duration).To(Equal(24 * time.Hour))
+			Expect(k.Spec.ZonePruning).To(Equal(ZonePruningDelete))
+		})
+		It("should keep the values that are set", func() {
+			k := validKsdns()
+			k.Spec.CoreDNS.Replicas = 3
+			k.Spec.Expose.CoreDNS = &ExposeService{ServiceType: corev1.ServiceTypeLoadBalancer}
+			k.Default()
+			Expect(k.Spec.CoreDNS.Replicas).To(Equal(int32(3)))
+			Expect(k.Spec.Expose.CoreDNS.ServiceType).To(Equal(corev1.ServiceTypeLoadBalancer))
+		})
+		It("should keep TSIG optional when disabled", func() {
+			k := validKsdns()
+			requireTSIG := false
+			k.Spec.Security.RequireTSIG = &requireTSIG
+			k.Default()
+			Expect(k.Spec.Security.TSIGRequired()).To(BeFalse())
+		})
+	})
+
+	Describe("Validate", func() {
+		It("should accept a valid Ksdns", func() {
+			k := validKsdns()
+			k.Default()
+			Expect(k.ValidateCreate()).To(Succeed())
+			Expect(k.ValidateUpdate(validKsdns())).To(Succeed())
+			Expect(k.ValidateDelete()).To(Succeed())
+		})
+		DescribeTable("invalid Ksdns",
+			func(mutate func(*Ksdns), path string) {
+				k := validKsdns()
+				mutate(k)
+				err := k.ValidateCreate()
+				Expect(apierrors.IsInvalid(err)).To(BeTrue())
+				Expect(err.Error()).To(ContainSubstring(path))
+			},
+			Entry("empty origin", func(k *Ksdns) { k.Spec.Zones[0].Origin = "" }, "spec.zones[0].origin"),
+			Entry("duplicate origin", func(k *Ksdns) {
+				k.Spec.Zones = append(k.Spec.Zones, Zone{Origin: "example.org"})
+			}, "spec.zones[1].origin"),
+			Entry("origin with a trailing dot", func(k *Ksdns) { k.Spec.Zones[0].Origin = "example.org." }, "spec.zones[0].origin"),
+			Entry("upper case origin", func(k *Ksdns) { k.Spec.Zones[0].Origin = "Example.org" }, "spec.zones[0].origin"),
+			Entry("invalid A record", func(k *Ksdns) { k.Spec.Zones[0].Records[0].Target = "www" }, "spec.zones[0].records[0]"),
+			Entry("SRV without port", func(k *Ksdns) { k.Spec.Zones[0].Records[1].Port = 0 }, "spec.zones[0].records[1]"),
+			Entry("invalid nameserver", func(k *Ksdns) { k.Spec.Zones[0].Nameservers = []string{"ns..example.org"} }, "spec.zones[0].nameservers[0]"),
+			Entry("unsupported service type", func(k *Ksdns) {
+				k.Spec.Expose.CoreDNS = &ExposeService{ServiceType: corev1.ServiceTypeExternalName}
+			}, "spec.expose.coredns.type"),
+			Entry("loadBalancerIP on a NodePort", func(k *Ksdns) {
+				k.Spec.Expose.Zupd = &ExposeService{ServiceType: corev1.ServiceTypeNodePort, LoadBalancerIP: "10.0.0.1"}
+			}, "spec.expose.zupd.loadBalancerIP"),
+			Entry("addressPool without metallb", func(k *Ksdns) {
+				k.Spec.Expose.CoreDNS = &ExposeService{ServiceType: corev1.ServiceTypeLoadBalancer, AddressPool: "public"}
+			}, "spec.expose.coredns.addressPool"),
+			Entry("pre-publish longer than the ZSK rollover", func(k *Ksdns) {
+				k.Spec.DNSSEC = &DNSSEC{
+					ZSKRolloverPeriod: metav1.Duration{Duration: time.Hour},
+					PrePublishPeriod:  metav1.Duration{Duration: 2 * time.Hour},
+				}
+			}, "spec.dnssec.prePublishPeriod"),
+			Entry("unsupported TSIG algorithm", func(k *Ksdns) { k.Spec.Security.TSIGAlgorithm = "hmac-md5" }, "spec.security.tsigAlgorithm"),
+			Entry("rotation shorter than the overlap", func(k *Ksdns) {
+				k.Spec.Security.RotationPeriod = &metav1.Duration{Duration: time.Hour}
+			}, "spec.security.rotationPeriod"),
+			Entry("rotation of the key of a Secret", func(k *Ksdns) {
+				k.Spec.Secret = &corev1.LocalObjectReference{Name: "tsig"}
+				k.Spec.Security.RotationPeriod = &metav1.Duration{Duration: 30 * 24 * time.Hour}
+			}, "spec.security.rotationPeriod"),
+			Entry("invalid key name", func(k *Ksdns) {
+				k.Spec.Security.Keys = []TSIGKey{{Name: "External_DNS"}}
+			}, "spec.security.keys[0].name"),
+			Entry("duplicate key name", func(k *Ksdns) {
+				k.Spec.Security.Keys = []TSIGKey{{Name: "external-dns"}, {Name: "external-dns"}}
+			}, "spec.security.keys[1].name"),
+			Entry("rotation of a named key of a Secret", func(k *Ksdns) {
+				k.Spec.Security.Keys = []TSIGKey{{
+					Name:           "cert-manager",
+					Secret:         &corev1.LocalObjectReference{Name: "tsig"},
+					RotationPeriod: &metav1.Duration{Duration: 30 * 24 * time.Hour},
+				}}
+			}, "spec.security.keys[0].rotationPeriod"),
+			Entry("unsupported consumer", func(k *Ksdns) {
+				k.Spec.Security.Keys = []TSIGKey{{Name: "octodns", Consumer: "octodns"}}
+			}, "spec.security.keys[0].consumer"),
+		)
+	})
+})
@@ -1,49 +1,100 @@
 package v1alpha1
 
 import (
+	"encoding/hex"
 	"fmt"
 	"net"
-	"time"
+	"strings"
 
 	"github.com/coredns/coredns/plugin/pkg/dnsutil"
 	"github.com/miekg/dns"
 )
 
-func newSOARecord(zone string) ([]dns.RR, error) {
+// Defaults of the SOA record
+const (
+	defaultTTL     = 30
+	defaultRefresh = 7200
+	defaultRetry   = 1800
+	defaultExpire  = 86400
+	defaultMinimum = 30
+)
+
+func newSOARecord(zone, primary string, fields *SOA) ([]dns.RR, error) {
 	if zone == "" {
 		return nil, fmt.Errorf("zone cannot be empty")
 	}
+	if primary == "" {
+		return nil, fmt.Errorf("primary nameserver cannot be empty")
+	}
+	if fields == nil {
+		fields = &SOA{}
+	}
 	zone = dns.Fqdn(zone)
-	ttl := uint32(30)
-	Mbox := dnsutil.Join("hostmaster", zone)
-	Ns := dnsutil.Join(nsName, zone)
+	mbox := dnsutil.Join("hostmaster", zone)
+	if fields.Mailbox != "" {
+		mbox = dns.Fqdn(strings.Replace(fields.Mailbox, "@", ".", 1))
+	}
+	if fields.PrimaryNameserver != "" {
+		primary = fields.PrimaryNameserver
+	}
+	if _, ok := dns.IsDomainName(mbox); !ok {
+		return nil, fmt.Errorf("invalid mailbox: %s", fields.Mailbox)
+	}
+	if _, ok := dns.IsDomainName(primary); !ok {
+		return nil, fmt.Errorf("invalid primary nameserver: %s", primary)
+	}
+	ttl := orDefault(fields.TTL, defaultTTL)
 	header := dns.RR_Header{Name: zone, Rrtype: dns.TypeSOA, Ttl: ttl, Class: dns.ClassINET}
 	soa := &dns.SOA{Hdr: header,
-		Mbox:    Mbox,
-		Ns:      Ns,
-		Serial:  uint32(time.Now().Unix()),
-		Refresh: 7200,
-		Retry:   1800,
-		Expire:  86400,
-		Minttl:  ttl,
+		Mbox:    mbox,
+		Ns:      dns.Fqdn(primary),
+		Refresh: orDefault(fields.Refresh, defaultRefresh),
+		Retry:   orDefault(fields.Retry, defaultRetry),
+		Expire:  orDefault(fields.Expire, defaultExpire),
+		Minttl:  orDefault(fields.Minimum, defaultMinimum),
 	}
 	return []dns.RR{soa}, nil
 }
 
-func newNSRecord(zone string, ip net.IP) (records, extra []dns.RR, err error) {
+// newNSRecord returns the NS records of zone for nameservers, and glue records with ips for the
+// nameservers in zone.
+func newNSRecord(zone string, ttl uint32, nameservers []string, ips ...net.IP) (records, extra []dns.RR, err error) {
 	if zone == "" {
 		return nil, nil, fmt.Errorf("zone cannot be empty")
 	}
-	host := dns.Fqdn(dnsutil.Join(nsName, zone))
+	if len(nameservers) == 0 {
+		return nil, nil, fmt.Errorf("nameservers cannot be empty")
+	}
 	zone = dns.Fqdn(zone)
-	ttl := uint32(30)
-	records = []dns.RR{
-		&dns.NS{Hdr: dns.RR_Header{Name: zone, Rrtype: dns.TypeNS, Class: dns.ClassINET, Ttl: ttl}, Ns: host},
+	for _, ns := range nameservers {
+		host := dns.Fqdn(ns)
+		if _, ok := dns.IsDomainName(host); !ok {
+			return nil, nil, fmt.Errorf("invalid nameserver: %s", ns)
+		}
+		records = append(records, &dns.NS{Hdr: dns.RR_Header{Name: zone, Rrtype: dns.TypeNS, Class: dns.ClassINET, Ttl: ttl}, Ns: host})
+		if !dns.IsSubDomain(zone, host) {
+			// Out of zone, no glue
+			continue
+		}
+		for _, ip := range ips {
+			if ip.To4() != nil {
+				extra = append(extra, newAddress(host, ttl, ip.To4(), dns.TypeA))
+			} else {
+				extra = append(extra, newAddress(host, ttl, ip, dns.TypeAAAA))
+			}
+		}
 	}
-	extra = append(extra, newAddress(host, ttl, ip, dns.TypeA))
 	return records, extra, nil
 }
 
+// orDefault returns v, or def if v is not set.
+func orDefault(v int32, def uint32) uint32 {
+	if v <= 0 {
+		return def
+	}
+	return uint32(v)
+}
+
 func newAddress(name string, ttl uint32, ip net.IP, what uint16) dns.RR {
 	hdr := dns.RR_Header{Name: name, Rrtype: what, Class: dns.ClassINET, Ttl: ttl}
 
@@ -54,6 +105,10 @@ func newAddress(name string, ttl uint32, ip net.IP, what uint16) dns.RR {
 	return &dns.AAAA{Hdr: hdr, AAAA: ip}
 }
 
+func newHeader(name string, rrtype uint16, ttl uint32) dns.RR_Header {
+	return dns.RR_Header{Name: name, Rrtype: rrtype, Class: dns.ClassINET, Ttl: ttl}
+}
+
 func newA(name string, ttl uint32, ip net.IP) *dns.A {
 	return &dns.A{Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: ttl}, A: ip}
 }
@@ -73,6 +128,54 @@ func newSRV(name string, ttl uint32, target string, weight uint16, priority, por
 		Priority: priority, Weight: weight, Port: port, Target: host}
 }
 
+func newMX(name string, ttl uint32, target string, preference uint16) *dns.MX {
+	return &dns.MX{Hdr: newHeader(name, dns.TypeMX, ttl), Preference: preference, Mx: dns.Fqdn(target)}
+}
+
+func newPTR(name string, ttl uint32, target string) *dns.PTR {
+	return &dns.PTR{Hdr: newHeader(name, dns.TypePTR, ttl), Ptr: dns.Fqdn(target)}
+}
+
+// newDelegation returns the NS record delegating name to target, and the glue of target.
+func newDelegation(name string, ttl uint32, target string, glue []string) ([]dns.RR, error) {
+	host := dns.Fqdn(target)
+	rrs := []dns.RR{&dns.NS{Hdr: newHeader(name, dns.TypeNS, ttl), Ns: host}}
+	for _, g := range glue {
+		ip := net.ParseIP(g)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid glue for %s: %q", host, g)
+		}
+		if ip.To4() != nil {
+			rrs = append(rrs, newAddress(host, ttl, ip.To4(), dns.TypeA))
+		} else {
+			rrs = append(rrs, newAddress(host, ttl, ip, dns.TypeAAAA))
+		}
+	}
+	return rrs, nil
+}
+
+// newFromRData returns the record of type with rdata in the zone file format.
+func newFromRData(name string, ttl uint32, rrtype, rdata string) (dns.RR, error) {
+	t, ok := dns.StringToType[rrtype]
+	if !ok {
+		return nil, fmt.Errorf("unknown record type: %s", rrtype)
+	}
+	rr, err := dns.NewRR(fmt.Sprintf(". %d IN %s %s", ttl, rrtype, rdata))
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s record %s: %w", rrtype, name, err)
+	}
+	if rr == nil || rr.Header().Rrtype != t {
+		return nil, fmt.Errorf("invalid %s record %s: empty rdata", rrtype, name)
+	}
+	rr.Header().Name = name
+	return rr, nil
+}
+
+func isHex(s string) bool {
+	_, err := hex.DecodeString(s)
+	return s != "" && err == nil
+}
+
 // Split255 splits a string into 255 byte chunks.
 func split255(s string) []string {
 	if len(s) < 255 {
@@ -22,34 +22,31 @@ limitations under the License.
 package v1alpha1
 
 import (
+	apiv1alpha1 "github.com/cldmnky/ksdns/pkg/zupd/api/v1alpha1"
 	"k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	runtime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime"
 )
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *CoreDNS) DeepCopyInto(out *CoreDNS) {
+func (in *CAA) DeepCopyInto(out *CAA) {
 	*out = *in
-	in.Resources.DeepCopyInto(&out.Resources)
-	if in.NodeSelector != nil {
-		in, out := &in.NodeSelector, &out.NodeSelector
-		*out = make(map[string]string, len(*in))
-		for key, val := range *in {
-			(*out)[key] = val
-		}
-	}
-	if in.Tolerations != nil {
-		in, out := &in.Tolerations, &out.Tolerations
-		*out = make([]v1.Toleration, len(*in))
-		for i := range *in {
-			(*in)[i].DeepCopyInto(&(*out)[i])
-		}
-	}
-	if in.Affinity != nil {
-		in, out := &in.Affinity, &out.Affinity
-		*out = new(v1.Affinity)
-		(*in).DeepCopyInto(*out)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CAA.
+func (in *CAA) DeepCopy() *CAA {
+	if in == nil {
+		return nil
 	}
+	out := new(CAA)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CoreDNS) DeepCopyInto(out *CoreDNS) {
+	*out = *in
+	in.PodSettings.DeepCopyInto(&out.PodSettings)
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CoreDNS.
@@ -62,6 +59,61 @@ func (in *CoreDNS) DeepCopy() *CoreDNS {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DNSSEC) DeepCopyInto(out *DNSSEC) {
+	*out = *in
+	out.KSKRolloverPeriod = in.KSKRolloverPeriod
+	out.ZSKRolloverPeriod = in.ZSKRolloverPeriod
+	out.PrePublishPeriod = in.PrePublishPeriod
+	out.DoubleSignaturePeriod = in.DoubleSignaturePeriod
+	if in.NSEC3 != nil {
+		in, out := &in.NSEC3, &out.NSEC3
+		*out = new(apiv1alpha1.NSEC3)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DNSSEC.
+func (in *DNSSEC) DeepCopy() *DNSSEC {
+	if in == nil {
+		return nil
+	}
+	out := new(DNSSEC)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DNSSECKey) DeepCopyInto(out *DNSSECKey) {
+	*out = *in
+	in.Created.DeepCopyInto(&out.Created)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DNSSECKey.
+func (in *DNSSECKey) DeepCopy() *DNSSECKey {
+	if in == nil {
+		return nil
+	}
+	out := new(DNSSECKey)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DS) DeepCopyInto(out *DS) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DS.
+func (in *DS) DeepCopy() *DS {
+	if in == nil {
+		return nil
+	}
+	out := new(DS)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Expose) DeepCopyInto(out *Expose) {
 	*out = *in
@@ -184,12 +236,19 @@ func (in *KsdnsSpec) DeepCopyInto(out *KsdnsSpec) {
 		}
 	}
 	in.CoreDNS.DeepCopyInto(&out.CoreDNS)
+	in.Zupd.DeepCopyInto(&out.Zupd)
 	if in.Secret != nil {
 		in, out := &in.Secret, &out.Secret
 		*out = new(v1.LocalObjectReference)
 		**out = **in
 	}
 	in.Expose.DeepCopyInto(&out.Expose)
+	if in.DNSSEC != nil {
+		in, out := &in.DNSSEC, &out.DNSSEC
+		*out = new(DNSSEC)
+		(*in).DeepCopyInto(*out)
+	}
+	in.Security.DeepCopyInto(&out.Security)
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KsdnsSpec.
@@ -212,6 +271,25 @@ func (in *KsdnsStatus) DeepCopyInto(out *KsdnsStatus) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.DNSSEC != nil {
+		in, out := &in.DNSSEC, &out.DNSSEC
+		*out = make([]ZoneDNSSECStatus, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.TSIGKeys != nil {
+		in, out := &in.TSIGKeys, &out.TSIGKeys
+		*out = make([]TSIGKeyStatus, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Zones != nil {
+		in, out := &in.Zones, &out.Zones
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KsdnsStatus.
@@ -224,9 +302,76 @@ func (in *KsdnsStatus) DeepCopy() *KsdnsStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PodSettings) DeepCopyInto(out *PodSettings) {
+	*out = *in
+	in.Resources.DeepCopyInto(&out.Resources)
+	if in.NodeSelector != nil {
+		in, out := &in.NodeSelector, &out.NodeSelector
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.Tolerations != nil {
+		in, out := &in.Tolerations, &out.Tolerations
+		*out = make([]v1.Toleration, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Affinity != nil {
+		in, out := &in.Affinity, &out.Affinity
+		*out = new(v1.Affinity)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.TopologySpreadConstraints != nil {
+		in, out := &in.TopologySpreadConstraints, &out.TopologySpreadConstraints
+		*out = make([]v1.TopologySpreadConstraint, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PodSettings.
+func (in *PodSettings) DeepCopy() *PodSettings {
+	if in == nil {
+		return nil
+	}
+	out := new(PodSettings)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Record) DeepCopyInto(out *Record) {
 	*out = *in
+	if in.Glue != nil {
+		in, out := &in.Glue, &out.Glue
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.CAA != nil {
+		in, out := &in.CAA, &out.CAA
+		*out = new(CAA)
+		**out = **in
+	}
+	if in.TLSA != nil {
+		in, out := &in.TLSA, &out.TLSA
+		*out = new(TLSA)
+		**out = **in
+	}
+	if in.SSHFP != nil {
+		in, out := &in.SSHFP, &out.SSHFP
+		*out = new(SSHFP)
+		**out = **in
+	}
+	if in.DS != nil {
+		in, out := &in.DS, &out.DS
+		*out = new(DS)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Record.
@@ -239,14 +384,152 @@ func (in *Record) DeepCopy() *Record {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SOA) DeepCopyInto(out *SOA) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SOA.
+func (in *SOA) DeepCopy() *SOA {
+	if in == nil {
+		return nil
+	}
+	out := new(SOA)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SSHFP) DeepCopyInto(out *SSHFP) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SSHFP.
+func (in *SSHFP) DeepCopy() *SSHFP {
+	if in == nil {
+		return nil
+	}
+	out := new(SSHFP)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Security) DeepCopyInto(out *Security) {
+	*out = *in
+	if in.RequireTSIG != nil {
+		in, out := &in.RequireTSIG, &out.RequireTSIG
+		*out = new(bool)
+		**out = **in
+	}
+	if in.Keys != nil {
+		in, out := &in.Keys, &out.Keys
+		*out = make([]TSIGKey, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.RotationPeriod != nil {
+		in, out := &in.RotationPeriod, &out.RotationPeriod
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	out.OverlapPeriod = in.OverlapPeriod
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Security.
+func (in *Security) DeepCopy() *Security {
+	if in == nil {
+		return nil
+	}
+	out := new(Security)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TLSA) DeepCopyInto(out *TLSA) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TLSA.
+func (in *TLSA) DeepCopy() *TLSA {
+	if in == nil {
+		return nil
+	}
+	out := new(TLSA)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TSIGKey) DeepCopyInto(out *TSIGKey) {
+	*out = *in
+	if in.Secret != nil {
+		in, out := &in.Secret, &out.Secret
+		*out = new(v1.LocalObjectReference)
+		**out = **in
+	}
+	if in.RotationPeriod != nil {
+		in, out := &in.RotationPeriod, &out.RotationPeriod
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TSIGKey.
+func (in *TSIGKey) DeepCopy() *TSIGKey {
+	if in == nil {
+		return nil
+	}
+	out := new(TSIGKey)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TSIGKeyStatus) DeepCopyInto(out *TSIGKeyStatus) {
+	*out = *in
+	if in.Created != nil {
+		in, out := &in.Created, &out.Created
+		*out = (*in).DeepCopy()
+	}
+	if in.NextRotation != nil {
+		in, out := &in.NextRotation, &out.NextRotation
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TSIGKeyStatus.
+func (in *TSIGKeyStatus) DeepCopy() *TSIGKeyStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(TSIGKeyStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Zone) DeepCopyInto(out *Zone) {
 	*out = *in
 	if in.Records != nil {
 		in, out := &in.Records, &out.Records
 		*out = make([]Record, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Nameservers != nil {
+		in, out := &in.Nameservers, &out.Nameservers
+		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
+	if in.SOA != nil {
+		in, out := &in.SOA, &out.SOA
+		*out = new(SOA)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Zone.
@@ -258,3 +541,45 @@ func (in *Zone) DeepCopy() *Zone {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ZoneDNSSECStatus) DeepCopyInto(out *ZoneDNSSECStatus) {
+	*out = *in
+	if in.Keys != nil {
+		in, out := &in.Keys, &out.Keys
+		*out = make([]DNSSECKey, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.NextRollover != nil {
+		in, out := &in.NextRollover, &out.NextRollover
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ZoneDNSSECStatus.
+func (in *ZoneDNSSECStatus) DeepCopy() *ZoneDNSSECStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ZoneDNSSECStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Zupd) DeepCopyInto(out *Zupd) {
+	*out = *in
+	in.PodSettings.DeepCopyInto(&out.PodSettings)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Zupd.
+func (in *Zupd) DeepCopy() *Zupd {
+	if in == nil {
+		return nil
+	}
+	out := new(Zupd)
+	in.DeepCopyInto(out)
+	return out
+}
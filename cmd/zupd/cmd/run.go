@@ -261,6 +261,7 @@ var Directives = []string{
 	"dynamicupdate",
 	"auto",
 	"secondary",
+	"secondarytsig",
 	"etcd",
 	"loop",
 	"forward",
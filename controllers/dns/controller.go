@@ -18,15 +18,25 @@ package dns
 
 import (
 	"context"
+	"net"
+	"time"
 
 	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	policyv1 "k8s.io/api/policy/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
 	dnsv1alpha1 "github.com/cldmnky/ksdns/apis/dns/v1alpha1"
@@ -36,15 +46,36 @@ import (
 )
 
 const (
-	defaultCoreDNSImage    = "quay.io/ksdns/zupd:latest"
-	defaultCoreDNSReplicas = int32(2)
+	defaultCoreDNSImage    = dnsv1alpha1.DefaultImage
+	defaultCoreDNSReplicas = dnsv1alpha1.DefaultReplicas
 	kdnsVersion            = "v0.0.1"
+	// mapTimeout bounds the lookups of the Ksdns to reconcile for a watched object
+	mapTimeout = 10 * time.Second
 )
 
+// CacheSelectors restricts the cache of the manager to the pods, EndpointSlices and services
+// managed by ksdns, the only ones watched and read by the Reconciler. Other objects of these kinds
+// are neither cached nor visible to the client of the manager.
+func CacheSelectors() cache.SelectorsByObject {
+	managed := cache.ObjectSelector{Label: labels.SelectorFromSet(labels.Set{"app.kubernetes.io/managed-by": "ksdns"})}
+	return cache.SelectorsByObject{
+		&corev1.Pod{}:                managed,
+		&discoveryv1.EndpointSlice{}: managed,
+		&corev1.Service{}:            managed,
+	}
+}
+
 // Reconciler reconciles a Ksdns object
 type Reconciler struct {
 	client.Client
 	Scheme *runtime.Scheme
+	// resolver resolves the hostnames of the load balancers, the default resolver if nil
+	resolver ipResolver
+}
+
+// ipResolver resolves hostnames, as net.Resolver.
+type ipResolver interface {
+	LookupIP(ctx context.Context, network, host string) ([]net.IP, error)
 }
 
 //+kubebuilder:rbac:groups=dns.ksdns.io,resources=ksdns,verbs=get;list;watch;create;update;patch;delete
@@ -54,6 +85,19 @@ type Reconciler struct {
 // +kubebuilder:rbac:groups=rfc1035.ksdns.io,resources=zones,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=rfc1035.ksdns.io,resources=zones/status,verbs=get;update;patch
 // +kubebuilder:rbac:groups=rfc1035.ksdns.io,resources=zones/finalizers,verbs=update
+
+// The CoreDNS and zupd deployments and their configuration
+// +kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=core,resources=configmaps;secrets;services;serviceaccounts,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=policy,resources=poddisruptionbudgets,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch
+// +kubebuilder:rbac:groups=discovery.k8s.io,resources=endpointslices,verbs=get;list;watch
+// The role of zupd, the operator holds the permissions it grants
+// +kubebuilder:rbac:groups=rbac.authorization.k8s.io,resources=roles;rolebindings,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=coordination.k8s.io,resources=leases,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
+
+// Reconcile brings the Secrets, services, Zones and deployments of a Ksdns to its spec.
 func (r *Reconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	/*
 		There are quite a bit of intertwined resources here.
@@ -88,7 +132,8 @@ func (r *Reconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Resu
 	}
 	// Set the status as Unknown when no status are available
 	if ksdns.Status.Conditions == nil || len(ksdns.Status.Conditions) == 0 {
-		meta.SetStatusCondition(&ksdns.Status.Conditions, metav1.Condition{Type: dnsv1alpha1.TypeAvailableKsdns, Status: metav1.ConditionUnknown, Reason: "Reconciling", Message: "Starting reconciliation"})
+		meta.SetStatusCondition(&ksdns.Status.Conditions, metav1.Condition{Type: dnsv1alpha1.TypeAvailableKsdns, Status: metav1.ConditionUnknown, Reason: reasonReconciling, Message: "Starting reconciliation"})
+		meta.SetStatusCondition(&ksdns.Status.Conditions, metav1.Condition{Type: dnsv1alpha1.TypeProgressingKsdns, Status: metav1.ConditionTrue, Reason: reasonReconciling, Message: "Starting reconciliation"})
 		if err = r.Status().Update(ctx, ksdns); err != nil {
 			log.Error(err, "Failed to update ksdns status")
 			return ctrl.Result{}, err
@@ -99,27 +144,59 @@ func (r *Reconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Resu
 			return ctrl.Result{}, err
 		}
 	}
+	// The steps record their results in the status, which is updated once at the end
+	previous := ksdns.Status.DeepCopy()
 
 	// ensureSecret
-	if err := r.ensureCoreDNSSecret(ctx, ksdns); err != nil {
+	tsigConf, rotation, err := r.ensureCoreDNSSecret(ctx, ksdns)
+	if err != nil {
 		log.Error(err, "Failed to ensure secret")
-		return ctrl.Result{}, err
+		return ctrl.Result{}, r.setFailedCondition(ctx, ksdns, reasonSecretFailed, err)
+	}
+
+	// The glue of the zones points at the CoreDNS service
+	if err := r.ensureCoreDNSservice(ctx, ksdns); err != nil {
+		log.Error(err, "Failed to ensure CoreDNS service")
+		return ctrl.Result{}, r.setFailedCondition(ctx, ksdns, reasonServiceFailed, err)
 	}
 
-	if err := r.ensureZones(ctx, ksdns); err != nil {
+	rollover, err := r.ensureDNSSEC(ctx, ksdns)
+	if err != nil {
+		log.Error(err, "Failed to ensure DNSSEC keys")
+		return ctrl.Result{}, r.setFailedCondition(ctx, ksdns, reasonDNSSECFailed, err)
+	}
+
+	lookup, err := r.ensureZones(ctx, ksdns)
+	if err != nil {
 		log.Error(err, "Failed to ensure zones")
-		return ctrl.Result{}, err
+		return ctrl.Result{}, r.setFailedCondition(ctx, ksdns, reasonZonesFailed, err)
 	}
 
-	if err := r.ensureCoreDNS(ctx, ksdns); err != nil {
+	if err := r.ensureCoreDNS(ctx, ksdns, tsigConf); err != nil {
 		log.Error(err, "Failed to ensure CoreDNS deployment")
-		return ctrl.Result{}, err
+		return ctrl.Result{}, r.setFailedCondition(ctx, ksdns, reasonCoreDNSFailed, err)
 	}
 
-	if err := r.ensureZupd(ctx, ksdns); err != nil {
+	if err := r.ensureZupd(ctx, ksdns, tsigConf); err != nil {
 		log.Error(err, "Failed to ensure zupd deployment")
+		return ctrl.Result{}, r.setFailedCondition(ctx, ksdns, reasonZupdFailed, err)
+	}
+
+	if err := r.updateStatus(ctx, ksdns, previous); err != nil {
+		log.Error(err, "Failed to update ksdns status")
 		return ctrl.Result{}, err
 	}
+	next := rollover
+	for _, t := range []time.Time{rotation, lookup} {
+		if !t.IsZero() && (next.IsZero() || t.Before(next)) {
+			next = t
+		}
+	}
+	if !next.IsZero() {
+		// Reconcile again for the next step of a key rollover or rotation, or the next lookup of
+		// the glue
+		return ctrl.Result{RequeueAfter: time.Until(next)}, nil
+	}
 	return ctrl.Result{}, nil
 }
 
@@ -127,10 +204,43 @@ func (r *Reconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Resu
 func (r *Reconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&dnsv1alpha1.Ksdns{}).
-		Owns(&rfc1035v1alpha1.Zone{}).
 		Owns(&appsv1.Deployment{}).
 		Owns(&corev1.Secret{}).
 		Owns(&corev1.ConfigMap{}).
-		Owns(&corev1.Service{}).
+		Owns(&policyv1.PodDisruptionBudget{}).
+		// The Corefiles serve all the zones of the namespace, not only the zones of the Ksdns
+		Watches(&source.Kind{Type: &rfc1035v1alpha1.Zone{}}, handler.EnqueueRequestsFromMapFunc(r.namespaceRequests)).
+		// The Corefiles and the status follow the pod IPs, the endpoints, the ClusterIPs and the
+		// load balancer status of the services
+		Watches(&source.Kind{Type: &corev1.Pod{}}, handler.EnqueueRequestsFromMapFunc(ksdnsRequests)).
+		Watches(&source.Kind{Type: &discoveryv1.EndpointSlice{}}, handler.EnqueueRequestsFromMapFunc(ksdnsRequests)).
+		Watches(&source.Kind{Type: &corev1.Service{}}, handler.EnqueueRequestsFromMapFunc(ksdnsRequests)).
 		Complete(r)
 }
+
+// namespaceRequests maps obj to all the Ksdns of its namespace.
+func (r *Reconciler) namespaceRequests(obj client.Object) []reconcile.Request {
+	ctx, cancel := context.WithTimeout(context.Background(), mapTimeout)
+	defer cancel()
+	ksdnsList := &dnsv1alpha1.KsdnsList{}
+	if err := r.List(ctx, ksdnsList, client.InNamespace(obj.GetNamespace())); err != nil {
+		log.Log.Error(err, "Failed to list ksdns", "namespace", obj.GetNamespace())
+		return nil
+	}
+	requests := make([]reconcile.Request, 0, len(ksdnsList.Items))
+	for _, ksdns := range ksdnsList.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: ksdns.Name, Namespace: ksdns.Namespace}})
+	}
+	return requests
+}
+
+// ksdnsRequests maps an object carrying the labels of a Ksdns to this Ksdns. The EndpointSlices
+// get the labels of their service.
+func ksdnsRequests(obj client.Object) []reconcile.Request {
+	labels := obj.GetLabels()
+	instance := labels["app.kubernetes.io/instance"]
+	if labels["app.kubernetes.io/managed-by"] != "ksdns" || instance == "" {
+		return nil
+	}
+	return []reconcile.Request{{NamespacedName: types.NamespacedName{Name: instance, Namespace: obj.GetNamespace()}}}
+}
@@ -176,7 +176,7 @@ var _ = Describe("ksdns controller", func() {
 		It("Should use the user provided secret", func() {
 			var (
 				tsigKey    = "test-key"
-				tsigSecret = generateTsigSecret()
+				tsigSecret = generateTsigSecret(32)
 			)
 			By("Creating a secret")
 			secret := &corev1.Secret{
@@ -231,7 +231,7 @@ var _ = Describe("ksdns controller", func() {
 			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: "user-secret", Namespace: ksdnsNameSpace}, secret)).Should(Succeed())
 			// update the secret
 			secret.Data["tsigKey"] = []byte("new-key")
-			secret.Data["tsigSecret"] = []byte(generateTsigSecret())
+			secret.Data["tsigSecret"] = []byte(generateTsigSecret(32))
 			Expect(k8sClient.Update(ctx, secret)).Should(Succeed())
 
 			By("Reconciling the custom resource created")
@@ -447,14 +447,16 @@ var _ = Describe("ksdns controller", func() {
 	Context("unit tests", func() {
 		Describe("newCaddyFile", func() {
 			It("should generate a caddyfile", func() {
-				cf, err := renderCoreDNSCorefile(
+				cf := coreDNSCorefile(
 					[]string{"example.com", "sub.example.com"},
 					[]string{"1.2.3.4", "2.3.4.5"},
+					[]string{"10.0.0.5"},
+					tsigKey,
+					security(&dnsv1alpha1.Ksdns{}),
 					false,
 				)
-				Expect(err).To(Not(HaveOccurred()))
 				// parse the caddyfile cf
-				_, err = caddyfile.ToJSON([]byte(cf))
+				_, err := caddyfile.ToJSON([]byte(cf.String()))
 				Expect(err).To(Not(HaveOccurred()))
 			})
 		})
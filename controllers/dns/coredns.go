@@ -6,19 +6,25 @@ import (
 	"crypto/rand"
 	"encoding/base64"
 	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
 	"text/template"
+	"time"
 
 	"github.com/Masterminds/sprig/v3"
+	"github.com/miekg/dns"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
-	utilerrors "k8s.io/apimachinery/pkg/util/errors"
 	"k8s.io/apimachinery/pkg/util/intstr"
 
 	dnsv1alpha1 "github.com/cldmnky/ksdns/apis/dns/v1alpha1"
@@ -31,79 +37,85 @@ var (
 	coreDNSTsigSecretTmpl = template.Must(
 		template.New("tsig").
 			Funcs(sprig.FuncMap()).
-			Parse(`key "{{.Name}}" {
+			Parse(`{{range .Keys}}key "{{.Name}}" {
+  algorithm {{$.Algorithm}};
   secret "{{.Secret}}";
 };
-`))
-	coreDNSCorefileTmpl = template.Must(
-		template.New("Corefile").
-			Funcs(sprig.FuncMap()).
-			Parse(`# This files is auto-generated by ksdns-controller. Do NOT edit.
-{{range $index, $element := .Zones}}{{$element}}:1053{{end}} {
-  {{if .Debug}}debug{{end}}
-  {{if .Debug}}log{{end}}
-  reload
-  ready
-  health
-  prometheus
-  tsig {
-	secrets /etc/coredns/secret/tsig.conf
-	require none
-  }
-  secondary {
-	transfer from {{range $index, $element := .SecondaryFrom}}{{$element}}:1053 {{end}}
-  }
-}
-`))
+{{end}}`))
 )
 
-func (r *Reconciler) ensureCoreDNS(ctx context.Context, ksdns *dnsv1alpha1.Ksdns) error {
+func (r *Reconciler) ensureCoreDNS(ctx context.Context, ksdns *dnsv1alpha1.Ksdns, tsigConf string) error {
 	log := log.FromContext(ctx)
 
-	if err := r.ensureCoreDNSConfigMap(ctx, ksdns); err != nil {
+	cf, err := r.ensureCoreDNSConfigMap(ctx, ksdns)
+	if err != nil {
 		return err
 	}
+	configHash := configHash(cf, tsigConf)
 
 	if err := r.ensureCoreDNSserviceaccount(ctx, ksdns); err != nil {
 		return err
 	}
 
-	if err := r.ensureCoreDNSservice(ctx, ksdns); err != nil {
-		return err
+	desired := coreDNSDeployment(ksdns, configHash)
+	if cf.empty() {
+		stopDeployment(desired)
 	}
-
-	deployment := coreDNSDeployment(ksdns)
+	deployment := &appsv1.Deployment{ObjectMeta: desired.ObjectMeta}
 	op, err := CreateOrUpdateWithRetries(ctx, r.Client, deployment, func() error {
+		mutateDeployment(deployment, desired)
 		return ctrl.SetControllerReference(ksdns, deployment, r.Scheme)
 	})
 	if err != nil {
 		return err
 	}
-	log.Info("coredns", "deployment", corednsName(ksdns), "op", op)
+	log.Info("coredns", "deployment", corednsName(ksdns), "op", op, "configHash", configHash)
+	ksdns.Status.CoreDNSConfigHash = configHash
+
+	// Keep a CoreDNS secondary serving during voluntary disruptions
+	pdb := &policyv1.PodDisruptionBudget{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      corednsName(ksdns),
+			Namespace: ksdns.Namespace,
+			Labels:    makeLabels("coredns", ksdns),
+		},
+	}
+	op, err = CreateOrUpdateWithRetries(ctx, r.Client, pdb, func() error {
+		maxUnavailable := intstr.FromInt(1)
+		pdb.Spec.MaxUnavailable = &maxUnavailable
+		pdb.Spec.Selector = &metav1.LabelSelector{MatchLabels: makeSelector("coredns", ksdns)}
+		return ctrl.SetControllerReference(ksdns, pdb, r.Scheme)
+	})
+	if err != nil {
+		return err
+	}
+	log.Info("coredns", "poddisruptionbudget", corednsName(ksdns), "op", op)
 	return nil
 
 }
 
-func (r *Reconciler) ensureCoreDNSConfigMap(ctx context.Context, ksdns *dnsv1alpha1.Ksdns) error {
+// ensureCoreDNSConfigMap ensures the ConfigMap holding the Corefile of CoreDNS, and returns the Corefile.
+func (r *Reconciler) ensureCoreDNSConfigMap(ctx context.Context, ksdns *dnsv1alpha1.Ksdns) (corefile, error) {
 	log := log.FromContext(ctx)
 	labels := makeLabels("coredns", ksdns)
 
 	// get the service ip for zupd
 	secondaryFrom, err := r.getZupdIPs(ctx, ksdns)
 	if err != nil {
-		return err
+		return corefile{}, err
 	}
 
 	// get all zones in the current namespace
 	zones, err := r.getZones(ctx, ksdns)
 	if err != nil {
-		return err
+		return corefile{}, err
 	}
-	// Render the corefile
-	corefile, err := renderCoreDNSCorefile(zones, secondaryFrom, false)
+	// the zupd pods send the NOTIFYs
+	notifiers, err := r.getZupdNotifiers(ctx, ksdns)
 	if err != nil {
-		return err
+		return corefile{}, err
 	}
+	cf := coreDNSCorefile(zones, secondaryFrom, notifiers, currentTSIGKey(ksdns), security(ksdns), false)
 	coreFile := &corev1.ConfigMap{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      corednsName(ksdns),
@@ -114,84 +126,38 @@ func (r *Reconciler) ensureCoreDNSConfigMap(ctx context.Context, ksdns *dnsv1alp
 	// create or update the corefile
 	op, err := CreateOrUpdateWithRetries(ctx, r.Client, coreFile, func() error {
 		coreFile.Data = map[string]string{
-			"Corefile": corefile,
+			"Corefile": cf.String(),
 		}
 		return ctrl.SetControllerReference(ksdns, coreFile, r.Scheme)
 	})
 	if err != nil {
-		return err
+		return corefile{}, err
 	}
 	log.Info("coredns", "configmap", corednsName(ksdns), "op", op)
 
-	return nil
+	return cf, nil
 }
 
-// ensureCoreDNSSecret ensures that the secret(s) exists and has the correct data.
-func (r Reconciler) ensureCoreDNSSecret(ctx context.Context, ksdns *dnsv1alpha1.Ksdns) error {
+// ensureCoreDNSSecret ensures the TSIG keys and the Secret holding the tsig.conf of the keys
+// accepted by CoreDNS and zupd. It returns the tsig.conf and the time of the next rotation of a
+// key.
+func (r Reconciler) ensureCoreDNSSecret(ctx context.Context, ksdns *dnsv1alpha1.Ksdns) (string, time.Time, error) {
 	log := log.FromContext(ctx)
-	// Get the secret
-	labels := makeLabels("ksdns", ksdns)
-	secret := &corev1.Secret{}
-	if ksdns.Spec.Secret == nil {
-		// No secret specified, Create a new one if it does no exist
-		var ts string
-
-		err := r.Get(ctx, types.NamespacedName{Name: ksdns.Name, Namespace: ksdns.Namespace}, secret)
-		if err != nil && apierrors.IsNotFound(err) {
-			// Secret does not exist, create
-
-			ts = generateTsigSecret()
-			secret = &corev1.Secret{
-				ObjectMeta: metav1.ObjectMeta{
-					Name:      ksdns.Name,
-					Namespace: ksdns.Namespace,
-					Labels:    labels,
-				},
-				StringData: map[string]string{
-					"tsigKey":    tsigKey,
-					"tsigSecret": ts,
-				},
-			}
-			err = ctrl.SetControllerReference(ksdns, secret, r.Scheme)
-			if err != nil {
-				return err
-			}
-			if err := r.Create(ctx, secret); err != nil {
-				return err
-			}
-		}
-	} else {
-		// User provided secret, check if it has the correct data
-		// try to get it
-		if err := r.Get(ctx, types.NamespacedName{Name: ksdns.Spec.Secret.Name, Namespace: ksdns.Namespace}, secret); err != nil {
-			log.Error(err, "failed to get user provided secret", "secret", ksdns.Spec.Secret.Name)
-			return err
-		}
-		// check if it has the correct data
-		errs := []error{}
-		if _, ok := secret.Data["tsigKey"]; !ok {
-			errs = append(errs, fmt.Errorf("secret %s does not have the correct data, missing tsigKey field", ksdns.Spec.Secret.Name))
-		}
-		if _, ok := secret.Data["tsigSecret"]; !ok {
-			errs = append(errs, fmt.Errorf("secret %s does not have the correct data, missing tsigSecret field", ksdns.Spec.Secret.Name))
-		}
-		if len(errs) > 0 {
-			return utilerrors.NewAggregate(errs)
-		}
+	keys, next, err := r.ensureTSIGKeys(ctx, ksdns)
+	if err != nil {
+		return "", time.Time{}, err
 	}
 
-	// We should now have a secret
-
 	// Create the core DNS tsig secret
-	tsigSecret, err := renderCoreFileTsigSecret(string(secret.Data["tsigKey"]), string(secret.Data["tsigSecret"]))
+	tsigSecret, err := renderCoreFileTsigSecret(keys, security(ksdns).TSIGAlgorithm)
 	if err != nil {
-		return err
+		return "", time.Time{}, err
 	}
 	coreDNSSecret := &corev1.Secret{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      corednsName(ksdns),
 			Namespace: ksdns.Namespace,
-			Labels:    labels,
+			Labels:    makeLabels("ksdns", ksdns),
 		},
 	}
 	op, err := CreateOrUpdateWithRetries(ctx, r.Client, coreDNSSecret, func() error {
@@ -201,12 +167,20 @@ func (r Reconciler) ensureCoreDNSSecret(ctx context.Context, ksdns *dnsv1alpha1.
 		return ctrl.SetControllerReference(ksdns, coreDNSSecret, r.Scheme)
 	})
 	if err != nil {
-		return err
+		return "", time.Time{}, err
 	}
 	log.Info("coredns secret", "op", op)
 
-	return utilerrors.NewAggregate(nil)
+	return tsigSecret, next, nil
+}
 
+// configHash returns the hash of the configuration of a deployment: its Corefile, without the
+// addresses of the pods, and the rendered tsig.conf.
+func configHash(cf corefile, tsigConf string) string {
+	return hashData(map[string][]byte{
+		"Corefile":  []byte(cf.withoutPodAddresses().String()),
+		"tsig.conf": []byte(tsigConf),
+	})
 }
 
 func (r *Reconciler) ensureCoreDNSserviceaccount(ctx context.Context, ksdns *dnsv1alpha1.Ksdns) error {
@@ -242,22 +216,21 @@ func (r *Reconciler) getZones(ctx context.Context, ksdns *dnsv1alpha1.Ksdns) ([]
 		zones = append(zones, zone.Name)
 	}
 
-	if len(zones) == 0 {
-		return []string{"."}, nil
-	}
+	sort.Strings(zones)
 	return zones, nil
 }
 
-// getZupdIPs gets the ip address of the zupd service
+// getZupdIPs gets the addresses, as ip:port, of the zupd service
 func (r *Reconciler) getZupdIPs(ctx context.Context, ksdns *dnsv1alpha1.Ksdns) ([]string, error) {
 	log := log.FromContext(ctx)
+	port := strconv.Itoa(int(servicePort(ksdns.Spec.Expose.Zupd, 1053)))
 
 	// see if we can get the service ip from zupd
 	zupdSvc := &corev1.Service{}
 	if err := r.Get(ctx, types.NamespacedName{Name: zupdName(ksdns), Namespace: ksdns.Namespace}, zupdSvc); err != nil {
 		if apierrors.IsNotFound(err) {
 			log.Info("zupd service not found, skipping ip lookup")
-			return []string{"169.254.0.1"}, nil // set ip to link local
+			return []string{net.JoinHostPort("169.254.0.1", port)}, nil // set ip to link local
 		} else {
 			return nil, err
 		}
@@ -266,12 +239,12 @@ func (r *Reconciler) getZupdIPs(ctx context.Context, ksdns *dnsv1alpha1.Ksdns) (
 	// get the service ip from the zupd service
 	// if we can't get it, we'll just use the service name
 	// and hope for the best
-	secondaryFrom := []string{zupdSvc.Spec.ClusterIP}
-	if secondaryFrom[0] == "" {
-		secondaryFrom = []string{"169.254.0.1"} // set ip to link local
+	ip := zupdSvc.Spec.ClusterIP
+	if ip == "" {
+		ip = "169.254.0.1" // set ip to link local
 	}
 
-	return secondaryFrom, nil
+	return []string{net.JoinHostPort(ip, port)}, nil
 }
 
 // service
@@ -290,6 +263,7 @@ func (r *Reconciler) ensureCoreDNSservice(ctx context.Context, ksdns *dnsv1alpha
 		},
 	}
 	op, err := CreateOrUpdateWithRetries(ctx, r.Client, svc, func() error {
+		setServiceAnnotations(svc, ksdns.Spec.Expose.CoreDNS)
 		svc.Spec = *spec
 		return ctrl.SetControllerReference(ksdns, svc, r.Scheme)
 	})
@@ -297,38 +271,35 @@ func (r *Reconciler) ensureCoreDNSservice(ctx context.Context, ksdns *dnsv1alpha
 		return err
 	}
 	log.Info("coredns", "service", corednsName(ksdns), "op", op)
+	ksdns.Status.CoreDNSEndpoint = serviceEndpoint(svc)
 	return nil
 }
 
 func coreDNSServiceSpec(ksdns *dnsv1alpha1.Ksdns) (*corev1.ServiceSpec, error) {
-	// handle type
-	switch ksdns.Spec.Expose.CoreDNS.ServiceType {
-	case corev1.ServiceTypeClusterIP:
-		return &corev1.ServiceSpec{
-			Selector: makeSelector("coredns", ksdns),
-			Type:     corev1.ServiceTypeClusterIP,
-			Ports: []corev1.ServicePort{
-				{
-					Name:       "dns-udp",
-					Port:       int32(53),
-					TargetPort: intstr.FromInt(1053),
-					Protocol:   corev1.ProtocolUDP,
-				},
-				{
-					Name:       "dns-tcp",
-					Port:       int32(53),
-					TargetPort: intstr.FromInt(1053),
-					Protocol:   corev1.ProtocolTCP,
-				},
-			},
-		}, nil
-		// case corev1.ServiceTypeNodePort:
-	}
-	return nil, fmt.Errorf("unsupported service type: %s", ksdns.Spec.Expose.CoreDNS.ServiceType)
+	return exposedServiceSpec(ksdns.Spec.Expose.CoreDNS, makeSelector("coredns", ksdns), []corev1.ServicePort{
+		{
+			Name:       "dns-udp",
+			Port:       servicePort(ksdns.Spec.Expose.CoreDNS, 53),
+			TargetPort: intstr.FromInt(1053),
+			Protocol:   corev1.ProtocolUDP,
+		},
+		{
+			Name:       "dns-tcp",
+			Port:       servicePort(ksdns.Spec.Expose.CoreDNS, 53),
+			TargetPort: intstr.FromInt(1053),
+			Protocol:   corev1.ProtocolTCP,
+		},
+	})
 }
 
-func coreDNSDeployment(ksdns *dnsv1alpha1.Ksdns) *appsv1.Deployment {
+// coreDNSDeployment returns the CoreDNS deployment of ksdns. The pods are annotated with
+// configHash, so that they roll when the configuration changes.
+func coreDNSDeployment(ksdns *dnsv1alpha1.Ksdns, configHash string) *appsv1.Deployment {
 	labels := makeLabels("coredns", ksdns)
+	settings := ksdns.Spec.CoreDNS.PodSettings
+	if len(settings.TopologySpreadConstraints) == 0 {
+		settings.TopologySpreadConstraints = defaultTopologySpreadConstraints
+	}
 	deployment := &appsv1.Deployment{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      corednsName(ksdns),
@@ -336,12 +307,14 @@ func coreDNSDeployment(ksdns *dnsv1alpha1.Ksdns) *appsv1.Deployment {
 		},
 		Spec: appsv1.DeploymentSpec{
 			Replicas: &ksdns.Spec.CoreDNS.Replicas,
+			Strategy: rollingUpdate(),
 			Selector: &metav1.LabelSelector{
 				MatchLabels: makeSelector("coredns", ksdns),
 			},
 			Template: corev1.PodTemplateSpec{
 				ObjectMeta: metav1.ObjectMeta{
-					Labels: labels,
+					Labels:      labels,
+					Annotations: map[string]string{configHashAnnotation: configHash},
 				},
 				Spec: corev1.PodSpec{
 					ServiceAccountName: corednsName(ksdns),
@@ -396,33 +369,58 @@ func coreDNSDeployment(ksdns *dnsv1alpha1.Ksdns) *appsv1.Deployment {
 			},
 		},
 	}
+	applyPodSettings(&deployment.Spec.Template.Spec, settings, makeSelector("coredns", ksdns))
 	return deployment
 }
 
-func renderCoreDNSCorefile(zones, secondaryFrom []string, debug bool) (string, error) {
-	params := struct {
-		Zones         []string
-		SecondaryFrom []string
-		Debug         bool
-	}{
-		Zones:         zones,
-		SecondaryFrom: secondaryFrom,
-		Debug:         debug,
+// coreDNSCorefile returns the Corefile of CoreDNS, serving zones as a secondary of secondaryFrom
+// and accepting their NOTIFYs from notifiers. The transfers are signed with tsigKey.
+func coreDNSCorefile(zones, secondaryFrom, notifiers []string, tsigKey string, security dnsv1alpha1.Security, debug bool) corefile {
+	secondary := []directive{
+		{name: "transfer", args: append([]string{"from"}, secondaryFrom...)},
 	}
-	var buf bytes.Buffer
-	if err := coreDNSCorefileTmpl.Execute(&buf, params); err != nil {
-		return "", err
+	if len(notifiers) > 0 {
+		secondary = append(secondary, directive{name: "notify", args: notifiers})
 	}
-	return buf.String(), nil
+	secondary = append(secondary,
+		directive{name: "key", args: []string{tsigKey}},
+		directive{name: "algorithm", args: []string{security.TSIGAlgorithm}},
+	)
+	directives := append(debugDirectives(debug), commonDirectives()...)
+	directives = append(directives,
+		directive{name: "tsig", block: []directive{
+			{name: "secrets", args: []string{"/etc/coredns/secret/tsig.conf"}},
+			// Queries are not authenticated
+			{name: "require", args: []string{"none"}},
+		}},
+		directive{name: "secondarytsig", block: secondary},
+	)
+	c := corefile{}
+	c.add(newServerBlock(zones, 1053, directives...))
+	return c
 }
 
-func renderCoreFileTsigSecret(key, secret string) (string, error) {
-	params := struct {
+// renderCoreFileTsigSecret renders the tsig.conf of keys. A key name is only defined once, by
+// its first version in keys.
+func renderCoreFileTsigSecret(keys []tsigKeyVersion, algorithm string) (string, error) {
+	type key struct {
 		Name   string
 		Secret string
+	}
+	params := struct {
+		Keys      []key
+		Algorithm string
 	}{
-		Name:   key,
-		Secret: secret,
+		Algorithm: algorithm,
+	}
+	defined := map[string]bool{}
+	for _, k := range keys {
+		name := strings.ToLower(dns.Fqdn(k.name))
+		if defined[name] {
+			continue
+		}
+		defined[name] = true
+		params.Keys = append(params.Keys, key{Name: k.name, Secret: k.secret})
 	}
 	var tmpl bytes.Buffer
 	err := coreDNSTsigSecretTmpl.Execute(&tmpl, params)
@@ -432,8 +430,9 @@ func renderCoreFileTsigSecret(key, secret string) (string, error) {
 	return tmpl.String(), nil
 }
 
-func generateTsigSecret() string {
-	key := make([]byte, 32)
+// generateTsigSecret returns a random TSIG secret of size bytes, base64 encoded.
+func generateTsigSecret(size int) string {
+	key := make([]byte, size)
 	_, err := rand.Read(key)
 	if err != nil {
 		// panic
@@ -441,3 +440,11 @@ func generateTsigSecret() string {
 	}
 	return base64.StdEncoding.EncodeToString(key)
 }
+
+// tsigSecretSize returns the size of the secrets generated for algorithm: the size of its hash.
+func tsigSecretSize(algorithm string) int {
+	if algorithm == "hmac-sha512" {
+		return 64
+	}
+	return 32
+}
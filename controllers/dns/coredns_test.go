@@ -1,18 +1,138 @@
 package dns
 
 import (
+	"encoding/base64"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	dnsv1alpha1 "github.com/cldmnky/ksdns/apis/dns/v1alpha1"
 )
 
 func TestCoreDNSTsigTemplate(t *testing.T) {
-	s, err := renderCoreFileTsigSecret("foo", "bar")
+	s, err := renderCoreFileTsigSecret([]tsigKeyVersion{
+		{name: "foo", secret: "bar"},
+		{name: "foo-2.", secret: "baz"},
+		{name: "Foo.", secret: "duplicate"},
+	}, "hmac-sha256")
 	require.NoError(t, err)
 	expected := `key "foo" {
+  algorithm hmac-sha256;
   secret "bar";
 };
+key "foo-2." {
+  algorithm hmac-sha256;
+  secret "baz";
+};
 `
 	assert.Equal(t, s, expected)
 }
+
+func TestGenerateTsigSecret(t *testing.T) {
+	for algorithm, size := range map[string]int{"hmac-sha256": 32, "hmac-sha512": 64} {
+		secret, err := base64.StdEncoding.DecodeString(generateTsigSecret(tsigSecretSize(algorithm)))
+		require.NoError(t, err)
+		assert.Len(t, secret, size, algorithm)
+	}
+}
+
+func TestDeploymentPodSettings(t *testing.T) {
+	ksdns := &dnsv1alpha1.Ksdns{
+		ObjectMeta: metav1.ObjectMeta{Name: "ksdns", Namespace: "default"},
+		Spec: dnsv1alpha1.KsdnsSpec{
+			CoreDNS: dnsv1alpha1.CoreDNS{
+				Image:    "coredns:test",
+				Replicas: 3,
+				PodSettings: dnsv1alpha1.PodSettings{
+					ImagePullPolicy: corev1.PullAlways,
+					Resources: corev1.ResourceRequirements{
+						Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m")},
+					},
+					NodeSelector: map[string]string{"dns": "true"},
+					Tolerations:  []corev1.Toleration{{Key: "dns", Operator: corev1.TolerationOpExists}},
+				},
+			},
+			Zupd: dnsv1alpha1.Zupd{
+				Replicas: 1,
+				PodSettings: dnsv1alpha1.PodSettings{
+					TopologySpreadConstraints: []corev1.TopologySpreadConstraint{
+						{MaxSkew: 1, TopologyKey: corev1.LabelHostname, WhenUnsatisfiable: corev1.DoNotSchedule},
+					},
+				},
+			},
+		},
+	}
+
+	coredns := coreDNSDeployment(ksdns, "0123456789abcdef")
+	assert.Equal(t, int32(3), *coredns.Spec.Replicas)
+	assert.Equal(t, 0, coredns.Spec.Strategy.RollingUpdate.MaxUnavailable.IntValue())
+	assert.Equal(t, "0123456789abcdef", coredns.Spec.Template.Annotations[configHashAnnotation])
+	pod := coredns.Spec.Template.Spec
+	assert.Equal(t, corev1.PullAlways, pod.Containers[0].ImagePullPolicy)
+	assert.Equal(t, ksdns.Spec.CoreDNS.Resources, pod.Containers[0].Resources)
+	assert.Equal(t, ksdns.Spec.CoreDNS.NodeSelector, pod.NodeSelector)
+	assert.Equal(t, ksdns.Spec.CoreDNS.Tolerations, pod.Tolerations)
+	// The CoreDNS pods are spread over the nodes and zones by default
+	require.Len(t, pod.TopologySpreadConstraints, 2)
+	assert.Equal(t, corev1.LabelTopologyZone, pod.TopologySpreadConstraints[1].TopologyKey)
+	assert.Equal(t, makeSelector("coredns", ksdns), pod.TopologySpreadConstraints[0].LabelSelector.MatchLabels)
+	assert.Nil(t, defaultTopologySpreadConstraints[0].LabelSelector)
+
+	zupd := zupdDeployment(ksdns, "fedcba9876543210")
+	assert.Equal(t, int32(1), *zupd.Spec.Replicas)
+	assert.Equal(t, "fedcba9876543210", zupd.Spec.Template.Annotations[configHashAnnotation])
+	pod = zupd.Spec.Template.Spec
+	assert.Equal(t, "coredns:test", pod.Containers[0].Image)
+	assert.Empty(t, pod.NodeSelector)
+	require.Len(t, pod.TopologySpreadConstraints, 1)
+	assert.Equal(t, corev1.DoNotSchedule, pod.TopologySpreadConstraints[0].WhenUnsatisfiable)
+	assert.Equal(t, makeSelector("zupd", ksdns), pod.TopologySpreadConstraints[0].LabelSelector.MatchLabels)
+
+	// The replicas of zupd default to 2
+	ksdns.Spec.Zupd = dnsv1alpha1.Zupd{Image: "zupd:test"}
+	zupd = zupdDeployment(ksdns, "fedcba9876543210")
+	assert.Equal(t, dnsv1alpha1.DefaultReplicas, *zupd.Spec.Replicas)
+	assert.Equal(t, "zupd:test", zupd.Spec.Template.Spec.Containers[0].Image)
+}
+
+func TestConfigHash(t *testing.T) {
+	zupd := func(secondaries ...string) corefile {
+		return zupdCorefile([]string{"example.org"}, secondaries, "dns", security(&dnsv1alpha1.Ksdns{}), false)
+	}
+	tsigConf := `key "a" { secret "b"; };`
+	hash := configHash(zupd("10.0.0.1:1053"), tsigConf)
+	assert.Len(t, hash, 16)
+
+	// The hash is stable
+	assert.Equal(t, hash, configHash(zupd("10.0.0.1:1053"), tsigConf))
+
+	// The hash does not change with the pods of the secondaries
+	assert.Equal(t, hash, configHash(zupd("10.0.0.2:1053", "10.0.0.3:1053"), tsigConf))
+
+	// The hash changes with the Corefile
+	changed := zupdCorefile([]string{"example.org", "example.com"}, []string{"10.0.0.1:1053"}, "dns", security(&dnsv1alpha1.Ksdns{}), false)
+	assert.NotEqual(t, hash, configHash(changed, tsigConf))
+
+	// The hash changes with the TSIG secret
+	assert.NotEqual(t, hash, configHash(zupd("10.0.0.1:1053"), `key "a" { secret "c"; };`))
+}
+
+func TestCorefileWithoutPodAddresses(t *testing.T) {
+	cf := zupdCorefile([]string{"example.org"}, []string{"10.0.0.1:1053"}, "dns", security(&dnsv1alpha1.Ksdns{}), false)
+	stable := cf.withoutPodAddresses().String()
+	assert.NotContains(t, stable, "10.0.0.1")
+	assert.Contains(t, stable, "transfer {\n    to\n  }")
+	// The Corefile is not modified
+	assert.Contains(t, cf.String(), "to 10.0.0.1:1053")
+
+	cf = coreDNSCorefile([]string{"example.org"}, []string{"10.96.0.10:1053"}, []string{"10.0.0.5"}, tsigKey, security(&dnsv1alpha1.Ksdns{}), false)
+	stable = cf.withoutPodAddresses().String()
+	assert.NotContains(t, stable, "10.0.0.5")
+	assert.Contains(t, stable, "\n    notify\n")
+	// The address of the zupd service is kept
+	assert.Contains(t, stable, "transfer from 10.96.0.10:1053")
+}
@@ -0,0 +1,128 @@
+package dns
+
+import (
+	"strconv"
+	"strings"
+)
+
+// corefileHeader is the first line of the generated Corefiles
+const corefileHeader = "# This file is auto-generated by ksdns-controller. Do NOT edit."
+
+// corefile is a Corefile made of server blocks.
+type corefile struct {
+	servers []serverBlock
+}
+
+// serverBlock is a server block serving zones on port with its directives.
+type serverBlock struct {
+	zones      []string
+	port       int
+	directives []directive
+}
+
+// directive is a plugin, or a property in the block of a plugin, with its arguments.
+type directive struct {
+	name  string
+	args  []string
+	block []directive
+}
+
+// newServerBlock returns a server block serving zones on port. It returns nil if there are no
+// zones: a server block needs at least one key.
+func newServerBlock(zones []string, port int, directives ...directive) *serverBlock {
+	if len(zones) == 0 {
+		return nil
+	}
+	return &serverBlock{zones: zones, port: port, directives: directives}
+}
+
+// add adds the server block s if not nil.
+func (c *corefile) add(s *serverBlock) {
+	if s != nil {
+		c.servers = append(c.servers, *s)
+	}
+}
+
+// empty returns true if the Corefile has no server block. CoreDNS starts a default server for
+// such a Corefile, it must not be run with it.
+func (c corefile) empty() bool {
+	return len(c.servers) == 0
+}
+
+// String renders the Corefile.
+func (c corefile) String() string {
+	var b strings.Builder
+	b.WriteString(corefileHeader + "\n")
+	for _, s := range c.servers {
+		keys := make([]string, 0, len(s.zones))
+		for _, z := range s.zones {
+			keys = append(keys, z+":"+strconv.Itoa(s.port))
+		}
+		b.WriteString(strings.Join(keys, " ") + " {\n")
+		writeDirectives(&b, s.directives, 1)
+		b.WriteString("}\n")
+	}
+	return b.String()
+}
+
+// podProperties are the properties, by directive, listing the addresses of pods.
+var podProperties = map[string]string{
+	// the secondaries zupd transfers to
+	"transfer": "to",
+	// the zupd pods sending NOTIFYs to CoreDNS
+	"secondarytsig": "notify",
+}
+
+// withoutPodAddresses returns a copy of c without the addresses of the pods: the to properties
+// of the transfer directives and the notify properties of the secondarytsig directives. They
+// change with the pods while reload picks up the Corefile.
+func (c corefile) withoutPodAddresses() corefile {
+	stable := corefile{}
+	for _, s := range c.servers {
+		directives := make([]directive, len(s.directives))
+		for i, d := range s.directives {
+			directives[i] = d
+			property, ok := podProperties[d.name]
+			if !ok {
+				continue
+			}
+			directives[i].block = make([]directive, len(d.block))
+			for j, p := range d.block {
+				if p.name == property {
+					p.args = nil
+				}
+				directives[i].block[j] = p
+			}
+		}
+		s.directives = directives
+		stable.servers = append(stable.servers, s)
+	}
+	return stable
+}
+
+// writeDirectives writes directives to b, indented by depth.
+func writeDirectives(b *strings.Builder, directives []directive, depth int) {
+	indent := strings.Repeat("  ", depth)
+	for _, d := range directives {
+		b.WriteString(indent + strings.Join(append([]string{d.name}, d.args...), " "))
+		if len(d.block) > 0 {
+			b.WriteString(" {\n")
+			writeDirectives(b, d.block, depth+1)
+			b.WriteString(indent + "}")
+		}
+		b.WriteString("\n")
+	}
+}
+
+// debugDirectives returns the debug and log directives if debug is set.
+func debugDirectives(debug bool) []directive {
+	if !debug {
+		return nil
+	}
+	return []directive{{name: "debug"}, {name: "log"}}
+}
+
+// commonDirectives are the directives of the server blocks of both CoreDNS and zupd.
+func commonDirectives() []directive {
+	return []directive{{name: "reload"}, {name: "ready"}, {name: "health"}, {name: "prometheus"}}
+}
@@ -0,0 +1,95 @@
+package dns
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/coredns/caddy/caddyfile"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	dnsv1alpha1 "github.com/cldmnky/ksdns/apis/dns/v1alpha1"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+// assertGolden compares actual to the golden file testdata/name, updating it with -update.
+func assertGolden(t *testing.T, name, actual string) {
+	t.Helper()
+	golden := filepath.Join("testdata", name)
+	if *update {
+		require.NoError(t, os.MkdirAll("testdata", 0o755))
+		require.NoError(t, os.WriteFile(golden, []byte(actual), 0o644))
+	}
+	expected, err := os.ReadFile(golden)
+	require.NoError(t, err)
+	assert.Equal(t, string(expected), actual)
+}
+
+func TestCorefile(t *testing.T) {
+	requireTSIG := false
+	zones := map[string][]string{
+		"no-zones":       nil,
+		"one-zone":       {"example.org"},
+		"multiple-zones": {"example.com", "example.org", "sub.example.org"},
+	}
+	testCases := []struct {
+		name     string
+		security dnsv1alpha1.Security
+		debug    bool
+	}{
+		{name: "default"},
+		{name: "debug", debug: true},
+		{name: "no-tsig", security: dnsv1alpha1.Security{RequireTSIG: &requireTSIG}},
+	}
+	for zonesName, zones := range zones {
+		for _, tc := range testCases {
+			ksdns := &dnsv1alpha1.Ksdns{Spec: dnsv1alpha1.KsdnsSpec{Security: tc.security}}
+			corefiles := map[string]corefile{
+				"coredns": coreDNSCorefile(zones, []string{"10.0.0.1:1053", "10.0.0.2:1053"}, []string{"10.0.0.5", "10.0.0.6"}, tsigKey, security(ksdns), tc.debug),
+				"zupd":    zupdCorefile(zones, []string{"10.0.0.3:1053", "10.0.0.4:1053"}, "dns", security(ksdns), tc.debug),
+			}
+			for server, cf := range corefiles {
+				name := server + "-" + zonesName + "-" + tc.name
+				t.Run(name, func(t *testing.T) {
+					assertGolden(t, name+".golden", cf.String())
+					assert.Equal(t, len(zones) == 0, cf.empty())
+					if cf.empty() {
+						return
+					}
+					_, err := caddyfile.ToJSON([]byte(cf.String()))
+					assert.NoError(t, err)
+				})
+			}
+		}
+	}
+}
+
+func TestCorefileString(t *testing.T) {
+	cf := corefile{}
+	cf.add(newServerBlock(nil, 53, directive{name: "whoami"}))
+	assert.True(t, cf.empty(), "a server block without zones is not added")
+	assert.Equal(t, corefileHeader+"\n", cf.String())
+
+	cf.add(newServerBlock([]string{"a.example", "b.example"}, 53,
+		directive{name: "log"},
+		directive{name: "forward", args: []string{".", "1.1.1.1"}, block: []directive{
+			{name: "except", args: []string{"internal.example"}},
+		}},
+	))
+	cf.add(newServerBlock([]string{"."}, 5353, directive{name: "whoami"}))
+	assert.False(t, cf.empty())
+	assert.Equal(t, corefileHeader+`
+a.example:53 b.example:53 {
+  log
+  forward . 1.1.1.1 {
+    except internal.example
+  }
+}
+.:5353 {
+  whoami
+}
+`, cf.String())
+}
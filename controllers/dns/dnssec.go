@@ -0,0 +1,427 @@
+package dns
+
+import (
+	"context"
+	"crypto"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	dnsv1alpha1 "github.com/cldmnky/ksdns/apis/dns/v1alpha1"
+	rfc1035v1alpha1 "github.com/cldmnky/ksdns/pkg/zupd/api/v1alpha1"
+)
+
+const (
+	dnssecKSK = "KSK"
+	dnssecZSK = "ZSK"
+	// Key states, as read by the dynamicupdate plugin from NAME.state
+	dnssecKeyActive    = "active"
+	dnssecKeyPublished = "published"
+)
+
+var (
+	dnssecName = func(ksdns *dnsv1alpha1.Ksdns, origin string) string {
+		return fmt.Sprintf("%s-dnssec-%s", ksdns.Name, strings.TrimSuffix(origin, "."))
+	}
+)
+
+// dnssecKey is a key in the Secret holding the keys of a zone. For each key the Secret holds
+// NAME.key, NAME.private and NAME.state, as read by the dynamicupdate plugin, and the times the
+// key was created, activated and retired.
+type dnssecKey struct {
+	name    string
+	dnskey  *dns.DNSKEY
+	private string
+	created time.Time
+	// activated is zero if the key never signed the zone
+	activated time.Time
+	// retired is zero if the key did not stop signing the zone
+	retired time.Time
+}
+
+func (k dnssecKey) ksk() bool { return k.dnskey.Flags&dns.SEP != 0 }
+
+func (k dnssecKey) state() string {
+	if !k.activated.IsZero() && k.retired.IsZero() {
+		return dnssecKeyActive
+	}
+	return dnssecKeyPublished
+}
+
+func (r *Reconciler) ensureDNSSEC(ctx context.Context, ksdns *dnsv1alpha1.Ksdns) (time.Time, error) {
+	log := log.FromContext(ctx)
+	statuses := []dnsv1alpha1.ZoneDNSSECStatus{}
+	var next time.Time
+	if ksdns.Spec.DNSSEC != nil {
+		spec := withDNSSECDefaults(*ksdns.Spec.DNSSEC)
+		now := time.Now()
+		for _, z := range ksdns.Spec.Zones {
+			origin := dns.Fqdn(z.Origin)
+			secret := &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      dnssecName(ksdns, origin),
+					Namespace: ksdns.Namespace,
+					Labels:    makeLabels("dnssec", ksdns),
+				},
+			}
+			var (
+				keys     []dnssecKey
+				zoneNext time.Time
+			)
+			op, err := CreateOrUpdateWithRetries(ctx, r.Client, secret, func() error {
+				current, err := readDNSSECKeys(secret)
+				if err != nil {
+					return err
+				}
+				if keys, zoneNext, err = rolloverDNSSECKeys(origin, spec, current, now); err != nil {
+					return err
+				}
+				secret.Data = writeDNSSECKeys(keys)
+				return ctrl.SetControllerReference(ksdns, secret, r.Scheme)
+			})
+			if err != nil {
+				return time.Time{}, fmt.Errorf("keys of %s: %w", origin, err)
+			}
+			log.Info("dnssec", "secret", secret.Name, "op", op)
+			statuses = append(statuses, dnssecStatus(origin, keys, zoneNext))
+			if next.IsZero() || zoneNext.Before(next) {
+				next = zoneNext
+			}
+		}
+	}
+
+	if len(statuses) == 0 {
+		statuses = nil
+	}
+	ksdns.Status.DNSSEC = statuses
+	return next, nil
+}
+
+// zoneDNSSEC returns the DNSSEC configuration of the Zone with origin, or nil if DNSSEC is disabled.
+func (r *Reconciler) zoneDNSSEC(ctx context.Context, ksdns *dnsv1alpha1.Ksdns, origin string) (*rfc1035v1alpha1.ZoneDNSSEC, error) {
+	if ksdns.Spec.DNSSEC == nil {
+		return nil, nil
+	}
+	secret := &corev1.Secret{}
+	if err := r.Get(ctx, types.NamespacedName{Name: dnssecName(ksdns, origin), Namespace: ksdns.Namespace}, secret); err != nil {
+		return nil, err
+	}
+	return &rfc1035v1alpha1.ZoneDNSSEC{
+		SecretName: secret.Name,
+		KeysHash:   hashData(secret.Data),
+		NSEC3:      ksdns.Spec.DNSSEC.NSEC3.DeepCopy(),
+	}, nil
+}
+
+// dnssecSecretNames returns the names of the Secrets holding the DNSSEC keys of the zones of ksdns,
+// sorted, or nil if DNSSEC is disabled.
+func dnssecSecretNames(ksdns *dnsv1alpha1.Ksdns) []string {
+	if ksdns.Spec.DNSSEC == nil {
+		return nil
+	}
+	names := []string{}
+	for _, z := range ksdns.Spec.Zones {
+		names = append(names, dnssecName(ksdns, dns.Fqdn(z.Origin)))
+	}
+	sort.Strings(names)
+	return names
+}
+
+// withDNSSECDefaults returns spec with the unset fields set to their defaults.
+func withDNSSECDefaults(spec dnsv1alpha1.DNSSEC) dnsv1alpha1.DNSSEC {
+	spec.Default()
+	return spec
+}
+
+// rolloverDNSSECKeys returns the keys of origin at now, and the time of the next change to the
+// keys. Missing keys are generated.
+//
+// The ZSK is rolled over with a pre-publish rollover (RFC 6781, section 4.1.1.1): the new ZSK is
+// published PrePublishPeriod before it replaces the old ZSK, which stays published for another
+// PrePublishPeriod. The KSK is rolled over with a double-signature rollover (RFC 6781, section
+// 4.1.2): the new KSK signs the DNSKEY RRset together with the old KSK for DoubleSignaturePeriod,
+// in which the DS record at the parent must be replaced.
+func rolloverDNSSECKeys(origin string, spec dnsv1alpha1.DNSSEC, keys []dnssecKey, now time.Time) ([]dnssecKey, time.Time, error) {
+	var next time.Time
+	schedule := func(t time.Time) {
+		if next.IsZero() || t.Before(next) {
+			next = t
+		}
+	}
+	tags := map[uint16]bool{}
+	for i := range keys {
+		tags[keys[i].dnskey.KeyTag()] = true
+		// Keys added to the Secret by hand
+		if keys[i].created.IsZero() {
+			keys[i].created = now
+		}
+		if keys[i].ksk() && keys[i].activated.IsZero() {
+			keys[i].activated = keys[i].created
+		}
+	}
+	generate := func(ksk bool) (dnssecKey, error) {
+		for {
+			k, err := generateDNSSECKey(origin, spec, ksk, now)
+			if err != nil {
+				return dnssecKey{}, err
+			}
+			// Key tags must be unique in the zone
+			if !tags[k.dnskey.KeyTag()] {
+				tags[k.dnskey.KeyTag()] = true
+				return k, nil
+			}
+		}
+	}
+
+	var ksks, zsks []dnssecKey
+	for _, k := range keys {
+		if k.ksk() {
+			ksks = append(ksks, k)
+		} else {
+			zsks = append(zsks, k)
+		}
+	}
+
+	// KSK double-signature rollover, the newest KSK is the current KSK
+	if len(ksks) > 1 {
+		newest := ksks[len(ksks)-1]
+		if end := newest.activated.Add(spec.DoubleSignaturePeriod.Duration); !now.Before(end) {
+			ksks = ksks[len(ksks)-1:]
+		} else {
+			schedule(end)
+		}
+	}
+	switch {
+	case len(ksks) == 0:
+		k, err := generate(true)
+		if err != nil {
+			return nil, next, err
+		}
+		ksks = append(ksks, k)
+		schedule(now.Add(spec.KSKRolloverPeriod.Duration))
+	case len(ksks) == 1:
+		if rollover := ksks[0].activated.Add(spec.KSKRolloverPeriod.Duration); !now.Before(rollover) {
+			k, err := generate(true)
+			if err != nil {
+				return nil, next, err
+			}
+			ksks = append(ksks, k)
+			schedule(now.Add(spec.DoubleSignaturePeriod.Duration))
+		} else {
+			schedule(rollover)
+		}
+	}
+
+	// ZSK pre-publish rollover
+	var current, successor *dnssecKey
+	retired := []dnssecKey{}
+	for i := range zsks {
+		k := zsks[i]
+		switch {
+		case !k.retired.IsZero():
+			if end := k.retired.Add(spec.PrePublishPeriod.Duration); now.Before(end) {
+				retired = append(retired, k)
+				schedule(end)
+			}
+		case k.activated.IsZero():
+			successor = &k
+		default:
+			if current != nil {
+				// Only one ZSK signs the zone
+				current.retired = now
+				retired = append(retired, *current)
+				schedule(now.Add(spec.PrePublishPeriod.Duration))
+			}
+			current = &k
+		}
+	}
+	switch {
+	case current == nil && successor == nil:
+		k, err := generate(false)
+		if err != nil {
+			return nil, next, err
+		}
+		current = &k
+		schedule(now.Add(spec.ZSKRolloverPeriod.Duration - spec.PrePublishPeriod.Duration))
+	case current == nil:
+		successor.activated = now
+		current, successor = successor, nil
+		schedule(now.Add(spec.ZSKRolloverPeriod.Duration - spec.PrePublishPeriod.Duration))
+	case successor != nil:
+		if activate := successor.created.Add(spec.PrePublishPeriod.Duration); !now.Before(activate) {
+			current.retired = now
+			retired = append(retired, *current)
+			successor.activated = now
+			current, successor = successor, nil
+			schedule(now.Add(spec.PrePublishPeriod.Duration))
+			schedule(now.Add(spec.ZSKRolloverPeriod.Duration - spec.PrePublishPeriod.Duration))
+		} else {
+			schedule(activate)
+		}
+	default:
+		if prePublish := current.activated.Add(spec.ZSKRolloverPeriod.Duration - spec.PrePublishPeriod.Duration); !now.Before(prePublish) {
+			k, err := generate(false)
+			if err != nil {
+				return nil, next, err
+			}
+			k.activated = time.Time{}
+			successor = &k
+			schedule(now.Add(spec.PrePublishPeriod.Duration))
+		} else {
+			schedule(prePublish)
+		}
+	}
+
+	rolled := append(ksks, retired...)
+	rolled = append(rolled, *current)
+	if successor != nil {
+		rolled = append(rolled, *successor)
+	}
+	sortDNSSECKeys(rolled)
+	return rolled, next, nil
+}
+
+// generateDNSSECKey generates an active key for origin.
+func generateDNSSECKey(origin string, spec dnsv1alpha1.DNSSEC, ksk bool, now time.Time) (dnssecKey, error) {
+	alg, ok := dns.StringToAlgorithm[spec.Algorithm]
+	if !ok {
+		return dnssecKey{}, fmt.Errorf("unsupported algorithm %s", spec.Algorithm)
+	}
+	dnskey := &dns.DNSKEY{
+		Hdr:       dns.RR_Header{Name: origin, Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: 3600},
+		Flags:     dns.ZONE,
+		Protocol:  3,
+		Algorithm: alg,
+	}
+	bits, typ := spec.ZSKSize, dnssecZSK
+	if ksk {
+		dnskey.Flags |= dns.SEP
+		bits, typ = spec.KSKSize, dnssecKSK
+	}
+	switch alg {
+	case dns.ECDSAP256SHA256, dns.ED25519:
+		bits = 256
+	case dns.ECDSAP384SHA384:
+		bits = 384
+	}
+	priv, err := dnskey.Generate(bits)
+	if err != nil {
+		return dnssecKey{}, err
+	}
+	return dnssecKey{
+		name:      fmt.Sprintf("%s-%d", strings.ToLower(typ), dnskey.KeyTag()),
+		dnskey:    dnskey,
+		private:   dnskey.PrivateKeyString(priv.(crypto.PrivateKey)),
+		created:   now,
+		activated: now,
+	}, nil
+}
+
+// readDNSSECKeys reads the keys from secret.
+func readDNSSECKeys(secret *corev1.Secret) ([]dnssecKey, error) {
+	keys := []dnssecKey{}
+	for k, pub := range secret.Data {
+		if !strings.HasSuffix(k, ".key") {
+			continue
+		}
+		name := strings.TrimSuffix(k, ".key")
+		rr, err := dns.NewRR(string(pub))
+		if err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", k, err)
+		}
+		dnskey, ok := rr.(*dns.DNSKEY)
+		if !ok {
+			return nil, fmt.Errorf("no DNSKEY in %s", k)
+		}
+		key := dnssecKey{name: name, dnskey: dnskey, private: string(secret.Data[name+".private"])}
+		for field, t := range map[string]*time.Time{"created": &key.created, "activated": &key.activated, "retired": &key.retired} {
+			v, ok := secret.Data[name+"."+field]
+			if !ok {
+				continue
+			}
+			if *t, err = time.Parse(time.RFC3339, string(v)); err != nil {
+				return nil, fmt.Errorf("parsing %s.%s: %w", name, field, err)
+			}
+		}
+		keys = append(keys, key)
+	}
+	sortDNSSECKeys(keys)
+	return keys, nil
+}
+
+// writeDNSSECKeys returns the data of the Secret holding keys.
+func writeDNSSECKeys(keys []dnssecKey) map[string][]byte {
+	data := map[string][]byte{}
+	for _, k := range keys {
+		data[k.name+".key"] = []byte(k.dnskey.String() + "\n")
+		data[k.name+".private"] = []byte(k.private)
+		data[k.name+".state"] = []byte(k.state())
+		for field, t := range map[string]time.Time{"created": k.created, "activated": k.activated, "retired": k.retired} {
+			if !t.IsZero() {
+				data[k.name+"."+field] = []byte(t.UTC().Format(time.RFC3339))
+			}
+		}
+	}
+	return data
+}
+
+// sortDNSSECKeys sorts keys from old to new.
+func sortDNSSECKeys(keys []dnssecKey) {
+	sort.SliceStable(keys, func(i, j int) bool {
+		if !keys[i].created.Equal(keys[j].created) {
+			return keys[i].created.Before(keys[j].created)
+		}
+		return keys[i].name < keys[j].name
+	})
+}
+
+// dnssecStatus returns the status of the keys of origin. The DS record is the DS record of the
+// newest KSK.
+func dnssecStatus(origin string, keys []dnssecKey, next time.Time) dnsv1alpha1.ZoneDNSSECStatus {
+	status := dnsv1alpha1.ZoneDNSSECStatus{Zone: origin}
+	for _, k := range keys {
+		typ := dnssecZSK
+		if k.ksk() {
+			typ = dnssecKSK
+			if ds := k.dnskey.ToDS(dns.SHA256); ds != nil {
+				status.DS = ds.String()
+			}
+		}
+		status.Keys = append(status.Keys, dnsv1alpha1.DNSSECKey{
+			KeyTag:  k.dnskey.KeyTag(),
+			Type:    typ,
+			State:   k.state(),
+			Created: metav1.NewTime(k.created.UTC().Truncate(time.Second)),
+		})
+	}
+	if !next.IsZero() {
+		t := metav1.NewTime(next.UTC().Truncate(time.Second))
+		status.NextRollover = &t
+	}
+	return status
+}
+
+// hashData returns a short hash of data.
+func hashData(data map[string][]byte) string {
+	names := make([]string, 0, len(data))
+	for k := range data {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	h := sha256.New()
+	for _, k := range names {
+		fmt.Fprintf(h, "%s=%s\n", k, data[k])
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
@@ -0,0 +1,107 @@
+package dns
+
+import (
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	dnsv1alpha1 "github.com/cldmnky/ksdns/apis/dns/v1alpha1"
+)
+
+func TestRolloverDNSSECKeys(t *testing.T) {
+	spec := withDNSSECDefaults(dnsv1alpha1.DNSSEC{
+		KSKRolloverPeriod: metav1.Duration{Duration: 100 * time.Hour},
+		ZSKRolloverPeriod: metav1.Duration{Duration: 10 * time.Hour},
+	})
+	now := time.Now().UTC().Truncate(time.Second)
+
+	// rollover rolls the keys over at now, through a Secret
+	rollover := func(keys []dnssecKey) ([]dnssecKey, time.Time) {
+		keys, next, err := rolloverDNSSECKeys("example.org.", spec, keys, now)
+		require.NoError(t, err)
+		read, err := readDNSSECKeys(&corev1.Secret{Data: writeDNSSECKeys(keys)})
+		require.NoError(t, err)
+		return read, next
+	}
+	states := func(keys []dnssecKey) []string {
+		s := []string{}
+		for _, k := range keys {
+			typ := dnssecZSK
+			if k.ksk() {
+				typ = dnssecKSK
+			}
+			s = append(s, typ+" "+k.state())
+		}
+		return s
+	}
+
+	// A KSK and a ZSK are generated
+	keys, next := rollover(nil)
+	assert.Equal(t, []string{"KSK active", "ZSK active"}, states(keys))
+	assert.Equal(t, now.Add(9*time.Hour), next)
+	ksk, zsk := keys[0], keys[1]
+
+	// Nothing changes until the next step
+	now = now.Add(time.Hour)
+	keys, _ = rollover(keys)
+	assert.Len(t, keys, 2)
+
+	// The new ZSK is published before the rollover
+	now = now.Add(8 * time.Hour)
+	keys, next = rollover(keys)
+	assert.Equal(t, []string{"KSK active", "ZSK active", "ZSK published"}, states(keys))
+	assert.Equal(t, now.Add(time.Hour), next)
+
+	// The new ZSK signs the zone, the old ZSK is still published
+	now = next
+	keys, next = rollover(keys)
+	assert.Equal(t, []string{"KSK active", "ZSK published", "ZSK active"}, states(keys))
+	assert.Equal(t, zsk.name, keys[1].name)
+	assert.Equal(t, now.Add(time.Hour), next)
+
+	// The old ZSK is removed
+	now = next
+	keys, _ = rollover(keys)
+	assert.Equal(t, []string{"KSK active", "ZSK active"}, states(keys))
+	assert.NotEqual(t, zsk.name, keys[1].name)
+
+	// The new KSK signs the DNSKEY RRset together with the old KSK
+	now = ksk.activated.Add(100 * time.Hour)
+	keys, _ = rollover(keys)
+	ksks := []dnssecKey{}
+	for _, k := range keys {
+		if k.ksk() {
+			ksks = append(ksks, k)
+		}
+	}
+	require.Len(t, ksks, 2)
+	assert.Equal(t, ksk.name, ksks[0].name)
+	newKSK := ksks[1]
+	assert.Equal(t, []string{dnssecKeyActive, dnssecKeyActive}, []string{ksks[0].state(), newKSK.state()})
+	status := dnssecStatus("example.org.", keys, next)
+	assert.Equal(t, newKSK.dnskey.ToDS(dns.SHA256).String(), status.DS)
+
+	// The old KSK is removed after the double-signature period
+	now = now.Add(spec.DoubleSignaturePeriod.Duration)
+	keys, _ = rollover(keys)
+	ksks = ksks[:0]
+	for _, k := range keys {
+		if k.ksk() {
+			ksks = append(ksks, k)
+		}
+	}
+	require.Len(t, ksks, 1)
+	assert.Equal(t, newKSK.name, ksks[0].name)
+}
+
+func TestHashData(t *testing.T) {
+	a := hashData(map[string][]byte{"a.key": []byte("1"), "b.key": []byte("2")})
+	assert.Len(t, a, 16)
+	assert.Equal(t, a, hashData(map[string][]byte{"b.key": []byte("2"), "a.key": []byte("1")}))
+	assert.NotEqual(t, a, hashData(map[string][]byte{"a.key": []byte("1"), "b.key": []byte("3")}))
+}
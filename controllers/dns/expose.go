@@ -0,0 +1,162 @@
+package dns
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+
+	dnsv1alpha1 "github.com/cldmnky/ksdns/apis/dns/v1alpha1"
+)
+
+const (
+	providerMetalLB = "metallb"
+	providerAWS     = "aws"
+
+	metalLBAddressPoolAnnotation  = "metallb.universe.tf/address-pool"
+	metalLBIPsAnnotation          = "metallb.universe.tf/loadBalancerIPs"
+	awsLoadBalancerTypeAnnotation = "service.beta.kubernetes.io/aws-load-balancer-type"
+
+	// managedAnnotationsAnnotation lists the annotations of a service set by the operator
+	managedAnnotationsAnnotation = "ksdns.io/managed-annotations"
+)
+
+// providerAnnotations are the annotations set for the providers. They are always managed by the
+// operator, also on services created before managedAnnotationsAnnotation.
+var providerAnnotations = []string{metalLBAddressPoolAnnotation, metalLBIPsAnnotation, awsLoadBalancerTypeAnnotation}
+
+// exposedServiceSpec returns the spec of a service selecting selector with ports, exposed as
+// configured by expose. A nil expose is a ClusterIP service.
+func exposedServiceSpec(expose *dnsv1alpha1.ExposeService, selector map[string]string, ports []corev1.ServicePort) (*corev1.ServiceSpec, error) {
+	spec := &corev1.ServiceSpec{
+		Selector: selector,
+		Type:     corev1.ServiceTypeClusterIP,
+		Ports:    ports,
+	}
+	if expose == nil {
+		return spec, nil
+	}
+	switch expose.ServiceType {
+	case "", corev1.ServiceTypeClusterIP:
+	case corev1.ServiceTypeNodePort:
+		spec.Type = corev1.ServiceTypeNodePort
+	case corev1.ServiceTypeLoadBalancer:
+		spec.Type = corev1.ServiceTypeLoadBalancer
+		if expose.LoadBalancerIP != "" {
+			switch expose.Provider {
+			case providerAWS:
+				return nil, fmt.Errorf("loadBalancerIP is not supported by the %s provider", providerAWS)
+			case providerMetalLB:
+				// Set by annotation, spec.loadBalancerIP is deprecated
+			default:
+				spec.LoadBalancerIP = expose.LoadBalancerIP
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unsupported service type: %s", expose.ServiceType)
+	}
+	if expose.LoadBalancerIP != "" && spec.Type != corev1.ServiceTypeLoadBalancer {
+		return nil, fmt.Errorf("loadBalancerIP requires a service of type %s", corev1.ServiceTypeLoadBalancer)
+	}
+	spec.ExternalIPs = expose.ExternalIPs
+	return spec, nil
+}
+
+// exposedServiceAnnotations returns the annotations of a service exposed as configured by expose:
+// the annotations of the provider, overridden by the annotations of expose.
+func exposedServiceAnnotations(expose *dnsv1alpha1.ExposeService) map[string]string {
+	annotations := map[string]string{}
+	if expose == nil {
+		return annotations
+	}
+	if expose.ServiceType == corev1.ServiceTypeLoadBalancer {
+		switch expose.Provider {
+		case providerMetalLB:
+			if expose.AddressPool != "" {
+				annotations[metalLBAddressPoolAnnotation] = expose.AddressPool
+			}
+			if expose.LoadBalancerIP != "" {
+				annotations[metalLBIPsAnnotation] = expose.LoadBalancerIP
+			}
+		case providerAWS:
+			annotations[awsLoadBalancerTypeAnnotation] = "nlb"
+		}
+	}
+	for k, v := range expose.Annotations {
+		annotations[k] = v
+	}
+	return annotations
+}
+
+// setServiceAnnotations sets the annotations of svc exposed as configured by expose. The
+// annotations set by a previous configuration and no longer set are removed, the other annotations
+// of svc are kept.
+func setServiceAnnotations(svc *corev1.Service, expose *dnsv1alpha1.ExposeService) {
+	if svc.Annotations == nil {
+		svc.Annotations = map[string]string{}
+	}
+	stale := append([]string{}, providerAnnotations...)
+	if managed := svc.Annotations[managedAnnotationsAnnotation]; managed != "" {
+		stale = append(stale, strings.Split(managed, ",")...)
+	}
+	for _, k := range stale {
+		delete(svc.Annotations, k)
+	}
+	delete(svc.Annotations, managedAnnotationsAnnotation)
+
+	annotations := exposedServiceAnnotations(expose)
+	if len(annotations) == 0 {
+		return
+	}
+	keys := make([]string, 0, len(annotations))
+	for k, v := range annotations {
+		svc.Annotations[k] = v
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	svc.Annotations[managedAnnotationsAnnotation] = strings.Join(keys, ",")
+}
+
+// servicePort returns the port of a service exposed as configured by expose, or def if not set.
+func servicePort(expose *dnsv1alpha1.ExposeService, def int32) int32 {
+	if expose != nil && expose.Port != 0 {
+		return expose.Port
+	}
+	return def
+}
+
+// serviceEndpoint returns the address of the first port of svc as host:port, as reachable from
+// outside of the cluster if the service is exposed. It is empty if the address is not known yet,
+// and for a NodePort service without external IPs, which has no single address: it is reachable
+// on its node port on every node.
+func serviceEndpoint(svc *corev1.Service) string {
+	if len(svc.Spec.Ports) == 0 {
+		return ""
+	}
+	port := svc.Spec.Ports[0].Port
+	host := ""
+	switch {
+	case len(svc.Spec.ExternalIPs) > 0:
+		host = svc.Spec.ExternalIPs[0]
+	case svc.Spec.Type == corev1.ServiceTypeLoadBalancer:
+		if len(svc.Status.LoadBalancer.Ingress) == 0 {
+			return ""
+		}
+		ingress := svc.Status.LoadBalancer.Ingress[0]
+		host = ingress.IP
+		if host == "" {
+			host = ingress.Hostname
+		}
+	case svc.Spec.Type == corev1.ServiceTypeNodePort:
+		return ""
+	default:
+		host = svc.Spec.ClusterIP
+	}
+	if port == 0 || host == "" {
+		return ""
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(port)))
+}
@@ -0,0 +1,188 @@
+package dns
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+
+	dnsv1alpha1 "github.com/cldmnky/ksdns/apis/dns/v1alpha1"
+)
+
+func TestExposedService(t *testing.T) {
+	testCases := []struct {
+		name                string
+		expose              *dnsv1alpha1.ExposeService
+		expectedType        corev1.ServiceType
+		expectedIP          string
+		expectedAnnotations map[string]string
+		expectedError       bool
+	}{
+		{
+			name:                "Default",
+			expectedType:        corev1.ServiceTypeClusterIP,
+			expectedAnnotations: map[string]string{},
+		},
+		{
+			name:                "NodePort",
+			expose:              &dnsv1alpha1.ExposeService{ServiceType: corev1.ServiceTypeNodePort, ExternalIPs: []string{"192.168.1.1"}},
+			expectedType:        corev1.ServiceTypeNodePort,
+			expectedAnnotations: map[string]string{},
+		},
+		{
+			name:          "NodePort with loadBalancerIP",
+			expose:        &dnsv1alpha1.ExposeService{ServiceType: corev1.ServiceTypeNodePort, LoadBalancerIP: "192.168.1.1"},
+			expectedError: true,
+		},
+		{
+			name:                "LoadBalancer",
+			expose:              &dnsv1alpha1.ExposeService{ServiceType: corev1.ServiceTypeLoadBalancer, LoadBalancerIP: "192.168.1.1"},
+			expectedType:        corev1.ServiceTypeLoadBalancer,
+			expectedIP:          "192.168.1.1",
+			expectedAnnotations: map[string]string{},
+		},
+		{
+			name: "MetalLB",
+			expose: &dnsv1alpha1.ExposeService{
+				ServiceType:    corev1.ServiceTypeLoadBalancer,
+				Provider:       providerMetalLB,
+				AddressPool:    "public",
+				LoadBalancerIP: "192.168.1.1",
+				Annotations:    map[string]string{"foo": "bar"},
+			},
+			expectedType: corev1.ServiceTypeLoadBalancer,
+			expectedAnnotations: map[string]string{
+				metalLBAddressPoolAnnotation: "public",
+				metalLBIPsAnnotation:         "192.168.1.1",
+				"foo":                        "bar",
+			},
+		},
+		{
+			name: "AWS",
+			expose: &dnsv1alpha1.ExposeService{
+				ServiceType: corev1.ServiceTypeLoadBalancer,
+				Provider:    providerAWS,
+				Annotations: map[string]string{"service.beta.kubernetes.io/aws-load-balancer-scheme": "internet-facing"},
+			},
+			expectedType: corev1.ServiceTypeLoadBalancer,
+			expectedAnnotations: map[string]string{
+				awsLoadBalancerTypeAnnotation:                         "nlb",
+				"service.beta.kubernetes.io/aws-load-balancer-scheme": "internet-facing",
+			},
+		},
+		{
+			name:          "AWS with loadBalancerIP",
+			expose:        &dnsv1alpha1.ExposeService{ServiceType: corev1.ServiceTypeLoadBalancer, Provider: providerAWS, LoadBalancerIP: "192.168.1.1"},
+			expectedError: true,
+		},
+		{
+			name:          "Unsupported type",
+			expose:        &dnsv1alpha1.ExposeService{ServiceType: corev1.ServiceTypeExternalName},
+			expectedError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			spec, err := exposedServiceSpec(tc.expose, map[string]string{"app": "test"}, []corev1.ServicePort{{Name: "dns-udp", Port: 53}})
+			if tc.expectedError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedType, spec.Type)
+			assert.Equal(t, tc.expectedIP, spec.LoadBalancerIP)
+			assert.Len(t, spec.Ports, 1)
+			if tc.expose != nil {
+				assert.Equal(t, tc.expose.ExternalIPs, spec.ExternalIPs)
+			}
+			assert.Equal(t, tc.expectedAnnotations, exposedServiceAnnotations(tc.expose))
+		})
+	}
+}
+
+func TestSetServiceAnnotations(t *testing.T) {
+	svc := &corev1.Service{}
+	svc.Annotations = map[string]string{"example.com/owner": "team"}
+	setServiceAnnotations(svc, &dnsv1alpha1.ExposeService{
+		ServiceType:    corev1.ServiceTypeLoadBalancer,
+		Provider:       "metallb",
+		AddressPool:    "public",
+		LoadBalancerIP: "192.168.1.1",
+		Annotations:    map[string]string{"example.com/ttl": "60"},
+	})
+	assert.Equal(t, map[string]string{
+		"example.com/owner":          "team",
+		"example.com/ttl":            "60",
+		metalLBAddressPoolAnnotation: "public",
+		metalLBIPsAnnotation:         "192.168.1.1",
+		managedAnnotationsAnnotation: "example.com/ttl,metallb.universe.tf/address-pool,metallb.universe.tf/loadBalancerIPs",
+	}, svc.Annotations)
+
+	// The annotations of the previous provider and configuration are removed
+	setServiceAnnotations(svc, &dnsv1alpha1.ExposeService{ServiceType: corev1.ServiceTypeLoadBalancer, Provider: "aws"})
+	assert.Equal(t, map[string]string{
+		"example.com/owner":           "team",
+		awsLoadBalancerTypeAnnotation: "nlb",
+		managedAnnotationsAnnotation:  awsLoadBalancerTypeAnnotation,
+	}, svc.Annotations)
+
+	// Only the annotations of the operator are removed when the service is no longer exposed
+	setServiceAnnotations(svc, nil)
+	assert.Equal(t, map[string]string{"example.com/owner": "team"}, svc.Annotations)
+
+	// The provider annotations of a service without the list of managed annotations are removed
+	svc.Annotations[metalLBAddressPoolAnnotation] = "public"
+	setServiceAnnotations(svc, nil)
+	assert.Equal(t, map[string]string{"example.com/owner": "team"}, svc.Annotations)
+}
+
+func TestServiceEndpoint(t *testing.T) {
+	ports := []corev1.ServicePort{{Name: "dns-tcp", Port: 1053, NodePort: 31053}}
+	testCases := []struct {
+		name     string
+		svc      corev1.Service
+		expected string
+	}{
+		{
+			name:     "ClusterIP",
+			svc:      corev1.Service{Spec: corev1.ServiceSpec{Type: corev1.ServiceTypeClusterIP, ClusterIP: "10.0.0.1", Ports: ports}},
+			expected: "10.0.0.1:1053",
+		},
+		{
+			name:     "External IP",
+			svc:      corev1.Service{Spec: corev1.ServiceSpec{Type: corev1.ServiceTypeClusterIP, ClusterIP: "10.0.0.1", ExternalIPs: []string{"2001:db8::1"}, Ports: ports}},
+			expected: "[2001:db8::1]:1053",
+		},
+		{
+			name:     "NodePort",
+			svc:      corev1.Service{Spec: corev1.ServiceSpec{Type: corev1.ServiceTypeNodePort, ClusterIP: "10.0.0.1", Ports: ports}},
+			expected: "",
+		},
+		{
+			name:     "NodePort with external IP",
+			svc:      corev1.Service{Spec: corev1.ServiceSpec{Type: corev1.ServiceTypeNodePort, ClusterIP: "10.0.0.1", ExternalIPs: []string{"192.168.1.1"}, Ports: ports}},
+			expected: "192.168.1.1:1053",
+		},
+		{
+			name:     "Pending LoadBalancer",
+			svc:      corev1.Service{Spec: corev1.ServiceSpec{Type: corev1.ServiceTypeLoadBalancer, ClusterIP: "10.0.0.1", Ports: ports}},
+			expected: "",
+		},
+		{
+			name: "LoadBalancer",
+			svc: corev1.Service{
+				Spec:   corev1.ServiceSpec{Type: corev1.ServiceTypeLoadBalancer, ClusterIP: "10.0.0.1", Ports: ports},
+				Status: corev1.ServiceStatus{LoadBalancer: corev1.LoadBalancerStatus{Ingress: []corev1.LoadBalancerIngress{{Hostname: "nlb.example.com"}}}},
+			},
+			expected: "nlb.example.com:1053",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, serviceEndpoint(&tc.svc))
+		})
+	}
+	assert.Equal(t, int32(1053), servicePort(nil, 1053))
+	assert.Equal(t, int32(5353), servicePort(&dnsv1alpha1.ExposeService{Port: 5353}, 1053))
+}
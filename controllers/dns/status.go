@@ -0,0 +1,158 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	dnsv1alpha1 "github.com/cldmnky/ksdns/apis/dns/v1alpha1"
+)
+
+// Reasons of the conditions of a Ksdns
+const (
+	reasonReconciling   = "Reconciling"
+	reasonReconciled    = "Reconciled"
+	reasonRollingOut    = "RollingOut"
+	reasonUnavailable   = "DeploymentsUnavailable"
+	reasonNoZones       = "NoZones"
+	reasonSecretFailed  = "SecretFailed"
+	reasonServiceFailed = "ServiceFailed"
+	reasonDNSSECFailed  = "DNSSECFailed"
+	reasonZonesFailed   = "ZonesFailed"
+	reasonCoreDNSFailed = "CoreDNSFailed"
+	reasonZupdFailed    = "ZupdFailed"
+)
+
+// Reasons of the Glue condition of a Ksdns
+const (
+	reasonServiceAddresses  = "ServiceAddresses"
+	reasonClusterIPFallback = "ClusterIPFallback"
+	reasonServicePending    = "ServicePending"
+)
+
+// setFailedCondition marks ksdns as degraded by err in the step of reason and updates its status.
+// It returns err.
+func (r *Reconciler) setFailedCondition(ctx context.Context, ksdns *dnsv1alpha1.Ksdns, reason string, err error) error {
+	meta.SetStatusCondition(&ksdns.Status.Conditions, metav1.Condition{
+		Type:    dnsv1alpha1.TypeDegradedKsdns,
+		Status:  metav1.ConditionTrue,
+		Reason:  reason,
+		Message: err.Error(),
+	})
+	meta.SetStatusCondition(&ksdns.Status.Conditions, metav1.Condition{
+		Type:    dnsv1alpha1.TypeProgressingKsdns,
+		Status:  metav1.ConditionFalse,
+		Reason:  reason,
+		Message: "Reconciliation failed",
+	})
+	ksdns.Status.ObservedGeneration = ksdns.Generation
+	if updateErr := r.Status().Update(ctx, ksdns); updateErr != nil {
+		log.FromContext(ctx).Error(updateErr, "Failed to update ksdns status")
+	}
+	return err
+}
+
+// updateStatus records the ready replicas of the deployments of ksdns and the resulting
+// conditions, and updates the status if it differs from previous.
+func (r *Reconciler) updateStatus(ctx context.Context, ksdns *dnsv1alpha1.Ksdns, previous *dnsv1alpha1.KsdnsStatus) error {
+	deployments := []*appsv1.Deployment{}
+	for _, name := range []string{corednsName(ksdns), zupdName(ksdns)} {
+		deployment := &appsv1.Deployment{}
+		if err := r.Get(ctx, types.NamespacedName{Name: name, Namespace: ksdns.Namespace}, deployment); err != nil {
+			if !apierrors.IsNotFound(err) {
+				return err
+			}
+			deployment = nil
+		}
+		deployments = append(deployments, deployment)
+	}
+	setDeploymentStatus(&ksdns.Status, ksdns.Generation, deployments[0], deployments[1])
+	if equality.Semantic.DeepEqual(previous, &ksdns.Status) {
+		return nil
+	}
+	return r.Status().Update(ctx, ksdns)
+}
+
+// setDeploymentStatus sets the ready replicas of the CoreDNS and zupd deployments in status and
+// the conditions of a reconciliation of generation that succeeded. A nil deployment is not
+// created yet.
+func setDeploymentStatus(status *dnsv1alpha1.KsdnsStatus, generation int64, coredns, zupd *appsv1.Deployment) {
+	status.ObservedGeneration = generation
+	status.CoreDNSReadyReplicas, status.ZupdReadyReplicas = 0, 0
+	if coredns != nil {
+		status.CoreDNSReadyReplicas = coredns.Status.ReadyReplicas
+	}
+	if zupd != nil {
+		status.ZupdReadyReplicas = zupd.Status.ReadyReplicas
+	}
+
+	available := metav1.Condition{
+		Type:    dnsv1alpha1.TypeAvailableKsdns,
+		Status:  metav1.ConditionTrue,
+		Reason:  reasonReconciled,
+		Message: fmt.Sprintf("Serving %d zones", len(status.Zones)),
+	}
+	progressing := metav1.Condition{
+		Type:    dnsv1alpha1.TypeProgressingKsdns,
+		Status:  metav1.ConditionFalse,
+		Reason:  reasonReconciled,
+		Message: "Deployments are up to date",
+	}
+	for _, d := range []struct {
+		name       string
+		deployment *appsv1.Deployment
+	}{
+		{"coredns", coredns},
+		{"zupd", zupd},
+	} {
+		if stopped(d.deployment) {
+			// Scaled to zero as there are no zones to serve
+			available.Status = metav1.ConditionFalse
+			available.Reason = reasonNoZones
+			available.Message = "There are no zones to serve"
+			continue
+		}
+		if d.deployment == nil || d.deployment.Status.ReadyReplicas == 0 {
+			available.Status = metav1.ConditionFalse
+			available.Reason = reasonUnavailable
+			available.Message = fmt.Sprintf("The %s deployment has no ready replicas", d.name)
+		}
+		if d.deployment == nil || rollingOut(d.deployment) {
+			progressing.Status = metav1.ConditionTrue
+			progressing.Reason = reasonRollingOut
+			progressing.Message = fmt.Sprintf("The %s deployment is rolling out", d.name)
+		}
+	}
+	meta.SetStatusCondition(&status.Conditions, available)
+	meta.SetStatusCondition(&status.Conditions, progressing)
+	meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+		Type:    dnsv1alpha1.TypeDegradedKsdns,
+		Status:  metav1.ConditionFalse,
+		Reason:  reasonReconciled,
+		Message: "Reconciliation succeeded",
+	})
+}
+
+// stopped returns true if deployment is scaled to zero.
+func stopped(deployment *appsv1.Deployment) bool {
+	return deployment != nil && deployment.Spec.Replicas != nil && *deployment.Spec.Replicas == 0
+}
+
+// rollingOut returns true if not all the replicas of deployment are updated and ready.
+func rollingOut(deployment *appsv1.Deployment) bool {
+	replicas := int32(1)
+	if deployment.Spec.Replicas != nil {
+		replicas = *deployment.Spec.Replicas
+	}
+	return deployment.Status.ObservedGeneration < deployment.Generation ||
+		deployment.Status.UpdatedReplicas < replicas ||
+		deployment.Status.ReadyReplicas < replicas ||
+		deployment.Status.Replicas > replicas
+}
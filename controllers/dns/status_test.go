@@ -0,0 +1,88 @@
+package dns
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	dnsv1alpha1 "github.com/cldmnky/ksdns/apis/dns/v1alpha1"
+)
+
+func TestSetDeploymentStatus(t *testing.T) {
+	deployment := func(replicas, updated, ready int32) *appsv1.Deployment {
+		return &appsv1.Deployment{
+			Spec:   appsv1.DeploymentSpec{Replicas: &replicas},
+			Status: appsv1.DeploymentStatus{Replicas: replicas, UpdatedReplicas: updated, ReadyReplicas: ready},
+		}
+	}
+	testCases := []struct {
+		name                string
+		coredns             *appsv1.Deployment
+		zupd                *appsv1.Deployment
+		expectedAvailable   metav1.ConditionStatus
+		expectedProgressing metav1.ConditionStatus
+		expectedReason      string
+	}{
+		{
+			name:                "Not created",
+			expectedAvailable:   metav1.ConditionFalse,
+			expectedProgressing: metav1.ConditionTrue,
+			expectedReason:      reasonUnavailable,
+		},
+		{
+			name:                "No ready replicas",
+			coredns:             deployment(2, 2, 0),
+			zupd:                deployment(2, 2, 2),
+			expectedAvailable:   metav1.ConditionFalse,
+			expectedProgressing: metav1.ConditionTrue,
+			expectedReason:      reasonUnavailable,
+		},
+		{
+			name:                "Rolling out",
+			coredns:             deployment(2, 1, 2),
+			zupd:                deployment(2, 2, 1),
+			expectedAvailable:   metav1.ConditionTrue,
+			expectedProgressing: metav1.ConditionTrue,
+			expectedReason:      reasonReconciled,
+		},
+		{
+			name:                "No zones",
+			coredns:             deployment(0, 0, 0),
+			zupd:                deployment(0, 0, 0),
+			expectedAvailable:   metav1.ConditionFalse,
+			expectedProgressing: metav1.ConditionFalse,
+			expectedReason:      reasonNoZones,
+		},
+		{
+			name:                "Available",
+			coredns:             deployment(2, 2, 2),
+			zupd:                deployment(2, 2, 2),
+			expectedAvailable:   metav1.ConditionTrue,
+			expectedProgressing: metav1.ConditionFalse,
+			expectedReason:      reasonReconciled,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			status := &dnsv1alpha1.KsdnsStatus{Zones: []string{"example.org"}}
+			meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+				Type: dnsv1alpha1.TypeDegradedKsdns, Status: metav1.ConditionTrue, Reason: reasonZonesFailed,
+			})
+			setDeploymentStatus(status, 3, tc.coredns, tc.zupd)
+			assert.Equal(t, int64(3), status.ObservedGeneration)
+			if tc.coredns != nil {
+				assert.Equal(t, tc.coredns.Status.ReadyReplicas, status.CoreDNSReadyReplicas)
+				assert.Equal(t, tc.zupd.Status.ReadyReplicas, status.ZupdReadyReplicas)
+			}
+			available := meta.FindStatusCondition(status.Conditions, dnsv1alpha1.TypeAvailableKsdns)
+			assert.Equal(t, tc.expectedAvailable, available.Status)
+			assert.Equal(t, tc.expectedReason, available.Reason)
+			progressing := meta.FindStatusCondition(status.Conditions, dnsv1alpha1.TypeProgressingKsdns)
+			assert.Equal(t, tc.expectedProgressing, progressing.Status)
+			assert.True(t, meta.IsStatusConditionFalse(status.Conditions, dnsv1alpha1.TypeDegradedKsdns))
+		})
+	}
+}
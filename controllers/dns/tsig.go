@@ -0,0 +1,438 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	dnsv1alpha1 "github.com/cldmnky/ksdns/apis/dns/v1alpha1"
+)
+
+const (
+	tsigConsumerExternalDNS = "external-dns"
+	tsigConsumerCertManager = "cert-manager"
+)
+
+var (
+	// tsigStateName is the Secret holding the versions of the generated TSIG keys
+	tsigStateName = func(ksdns *dnsv1alpha1.Ksdns) string { return fmt.Sprintf("%s-tsig", ksdns.Name) }
+	// tsigKeySecretName is the Secret publishing the named TSIG key name
+	tsigKeySecretName = func(ksdns *dnsv1alpha1.Ksdns, name string) string {
+		return fmt.Sprintf("%s-tsig-%s", ksdns.Name, name)
+	}
+)
+
+// tsigKeyVersion is a version of a TSIG key. Rotating a generated key adds a version with a new
+// name, as a TSIG key is identified by its name, and the previous version is accepted until the
+// end of the overlap period. For each generated version the state Secret holds NAME.secret,
+// NAME.key, NAME.version and the times the version was created and retired.
+type tsigKeyVersion struct {
+	// key is the name of the key in the spec, empty for the key of the Ksdns
+	key     string
+	version int
+	// name is the TSIG name of the version
+	name    string
+	secret  string
+	created time.Time
+	// retired is zero for the current version
+	retired time.Time
+}
+
+// tsigKeyName returns the TSIG name of version of key of ksdns. The first version of the key of
+// the Ksdns keeps the name it had before the keys were rotated.
+func tsigKeyName(ksdns *dnsv1alpha1.Ksdns, key string, version int) string {
+	label, rest := key, ksdns.Name+"."
+	if key == "" {
+		if version <= 1 {
+			return tsigKey
+		}
+		label, rest = "ksdns", "tsigKey."
+	}
+	if version > 1 {
+		label = fmt.Sprintf("%s-%d", label, version)
+	}
+	return label + "." + rest
+}
+
+// currentTSIGKey returns the TSIG name of the current version of the key of ksdns, which signs
+// the zone transfers, as recorded in the status by ensureTSIGKeys.
+func currentTSIGKey(ksdns *dnsv1alpha1.Ksdns) string {
+	for _, k := range ksdns.Status.TSIGKeys {
+		if k.Name == "" {
+			return k.KeyName
+		}
+	}
+	return tsigKey
+}
+
+// ensureTSIGKeys generates and rotates the TSIG keys of ksdns, publishes their current versions
+// and records them in the status. It returns the versions accepted by zupd and the time of the
+// next change to the versions, a rotation or the end of an overlap.
+func (r *Reconciler) ensureTSIGKeys(ctx context.Context, ksdns *dnsv1alpha1.Ksdns) ([]tsigKeyVersion, time.Time, error) {
+	log := log.FromContext(ctx)
+	spec := security(ksdns)
+	now := time.Now()
+
+	// The generated keys, with their rotation period
+	generated := map[string]time.Duration{}
+	if ksdns.Spec.Secret == nil {
+		generated[""] = rotationPeriod(spec.RotationPeriod)
+	}
+	for _, k := range spec.Keys {
+		if k.Secret == nil {
+			generated[k.Name] = rotationPeriod(k.RotationPeriod)
+		}
+	}
+	// The key of the Ksdns generated before the keys were rotated
+	legacy := &corev1.Secret{}
+	if err := r.Get(ctx, types.NamespacedName{Name: ksdns.Name, Namespace: ksdns.Namespace}, legacy); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return nil, time.Time{}, err
+		}
+		legacy = nil
+	}
+
+	state := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      tsigStateName(ksdns),
+			Namespace: ksdns.Namespace,
+			Labels:    makeLabels("tsig", ksdns),
+		},
+	}
+	var (
+		versions []tsigKeyVersion
+		next     = map[string]time.Time{}
+	)
+	op, err := CreateOrUpdateWithRetries(ctx, r.Client, state, func() error {
+		current, err := readTSIGKeys(state)
+		if err != nil {
+			return err
+		}
+		byKey := map[string][]tsigKeyVersion{}
+		for _, v := range current {
+			byKey[v.key] = append(byKey[v.key], v)
+		}
+		if _, ok := generated[""]; ok && len(byKey[""]) == 0 && legacy != nil && len(legacy.Data["tsigSecret"]) > 0 {
+			byKey[""] = []tsigKeyVersion{{
+				version: 1,
+				name:    tsigKeyName(ksdns, "", 1),
+				secret:  string(legacy.Data["tsigSecret"]),
+				created: now,
+			}}
+		}
+		versions = []tsigKeyVersion{}
+		for key, rotation := range generated {
+			key := key
+			generate := func(version int) tsigKeyVersion {
+				return tsigKeyVersion{
+					key:     key,
+					version: version,
+					name:    tsigKeyName(ksdns, key, version),
+					secret:  generateTsigSecret(tsigSecretSize(spec.TSIGAlgorithm)),
+					created: now,
+				}
+			}
+			var rotated []tsigKeyVersion
+			rotated, next[key] = rotateTSIGKey(byKey[key], rotation, spec.OverlapPeriod.Duration, generate, now)
+			versions = append(versions, rotated...)
+		}
+		sortTSIGKeys(versions)
+		state.Data = writeTSIGKeys(versions)
+		return ctrl.SetControllerReference(ksdns, state, r.Scheme)
+	})
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	log.Info("tsig", "secret", state.Name, "op", op)
+
+	currentVersions := map[string]tsigKeyVersion{}
+	for _, v := range versions {
+		if v.retired.IsZero() {
+			currentVersions[v.key] = v
+		}
+	}
+	accepted := append([]tsigKeyVersion{}, versions...)
+	statuses := []dnsv1alpha1.TSIGKeyStatus{}
+
+	// The key of the Ksdns
+	if ksdns.Spec.Secret != nil {
+		v, err := r.readTSIGSecret(ctx, ksdns, ksdns.Spec.Secret.Name)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		accepted = append(accepted, v)
+		statuses = append(statuses, tsigKeyStatus(v, ksdns.Spec.Secret.Name, 0))
+	} else {
+		v := currentVersions[""]
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      ksdns.Name,
+				Namespace: ksdns.Namespace,
+				Labels:    makeLabels("ksdns", ksdns),
+			},
+		}
+		op, err := CreateOrUpdateWithRetries(ctx, r.Client, secret, func() error {
+			secret.Data = map[string][]byte{
+				"tsigKey":    []byte(v.name),
+				"tsigSecret": []byte(v.secret),
+			}
+			return ctrl.SetControllerReference(ksdns, secret, r.Scheme)
+		})
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		log.Info("tsig", "secret", secret.Name, "op", op)
+		statuses = append(statuses, tsigKeyStatus(v, secret.Name, generated[""]))
+	}
+
+	// The named keys
+	published := map[string]bool{}
+	errs := []error{}
+	for _, k := range spec.Keys {
+		v, ok := currentVersions[k.Name]
+		if k.Secret != nil {
+			if v, err = r.readTSIGSecret(ctx, ksdns, k.Secret.Name); err != nil {
+				errs = append(errs, fmt.Errorf("key %s: %w", k.Name, err))
+				continue
+			}
+			v.key = k.Name
+			accepted = append(accepted, v)
+		} else if !ok {
+			continue
+		}
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      tsigKeySecretName(ksdns, k.Name),
+				Namespace: ksdns.Namespace,
+				Labels:    makeLabels("tsig-key", ksdns),
+			},
+		}
+		published[secret.Name] = true
+		op, err := CreateOrUpdateWithRetries(ctx, r.Client, secret, func() error {
+			secret.Data = tsigKeySecretData(ksdns, k.Consumer, v, spec.TSIGAlgorithm)
+			return ctrl.SetControllerReference(ksdns, secret, r.Scheme)
+		})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("key %s: %w", k.Name, err))
+			continue
+		}
+		log.Info("tsig", "secret", secret.Name, "op", op)
+		statuses = append(statuses, tsigKeyStatus(v, secret.Name, generated[k.Name]))
+	}
+	if len(errs) > 0 {
+		return nil, time.Time{}, utilerrors.NewAggregate(errs)
+	}
+
+	// Remove the Secrets of the keys that were removed from the spec
+	secrets := &corev1.SecretList{}
+	if err := r.List(ctx, secrets, client.InNamespace(ksdns.Namespace), client.MatchingLabels(makeSelector("tsig-key", ksdns))); err != nil {
+		return nil, time.Time{}, err
+	}
+	for i := range secrets.Items {
+		if published[secrets.Items[i].Name] || !metav1.IsControlledBy(&secrets.Items[i], ksdns) {
+			continue
+		}
+		if err := r.Delete(ctx, &secrets.Items[i]); err != nil && !apierrors.IsNotFound(err) {
+			return nil, time.Time{}, err
+		}
+		log.Info("tsig", "secret", secrets.Items[i].Name, "op", "deleted")
+	}
+
+	var nextRotation time.Time
+	for _, t := range next {
+		if !t.IsZero() && (nextRotation.IsZero() || t.Before(nextRotation)) {
+			nextRotation = t
+		}
+	}
+	sortTSIGKeys(accepted)
+	ksdns.Status.TSIGKeys = statuses
+	return accepted, nextRotation, nil
+}
+
+// readTSIGSecret reads the key in the tsigKey and tsigSecret fields of the Secret name.
+func (r *Reconciler) readTSIGSecret(ctx context.Context, ksdns *dnsv1alpha1.Ksdns, name string) (tsigKeyVersion, error) {
+	secret := &corev1.Secret{}
+	if err := r.Get(ctx, types.NamespacedName{Name: name, Namespace: ksdns.Namespace}, secret); err != nil {
+		log.FromContext(ctx).Error(err, "failed to get user provided secret", "secret", name)
+		return tsigKeyVersion{}, err
+	}
+	// check if it has the correct data
+	errs := []error{}
+	if _, ok := secret.Data["tsigKey"]; !ok {
+		errs = append(errs, fmt.Errorf("secret %s does not have the correct data, missing tsigKey field", name))
+	}
+	if _, ok := secret.Data["tsigSecret"]; !ok {
+		errs = append(errs, fmt.Errorf("secret %s does not have the correct data, missing tsigSecret field", name))
+	}
+	if len(errs) > 0 {
+		return tsigKeyVersion{}, utilerrors.NewAggregate(errs)
+	}
+	return tsigKeyVersion{name: string(secret.Data["tsigKey"]), secret: string(secret.Data["tsigSecret"])}, nil
+}
+
+// rotationPeriod returns the duration of period, zero if the key is not rotated.
+func rotationPeriod(period *metav1.Duration) time.Duration {
+	if period == nil {
+		return 0
+	}
+	return period.Duration
+}
+
+// rotateTSIGKey returns the versions of a key at now, sorted from old to new, and the time of the
+// next change to the versions. The newest version is the current version, it is generated if
+// missing and replaced after rotation, if set. A retired version is kept for overlap.
+func rotateTSIGKey(versions []tsigKeyVersion, rotation, overlap time.Duration, generate func(version int) tsigKeyVersion, now time.Time) ([]tsigKeyVersion, time.Time) {
+	var next time.Time
+	schedule := func(t time.Time) {
+		if next.IsZero() || t.Before(next) {
+			next = t
+		}
+	}
+	sortTSIGKeys(versions)
+	rotated := []tsigKeyVersion{}
+	version := 0
+	for i, v := range versions {
+		version = v.version
+		if i == len(versions)-1 && v.retired.IsZero() {
+			break
+		}
+		// Only the newest version is current
+		if v.retired.IsZero() {
+			v.retired = now
+		}
+		if end := v.retired.Add(overlap); now.Before(end) {
+			rotated = append(rotated, v)
+			schedule(end)
+		}
+	}
+	var current tsigKeyVersion
+	if n := len(versions); n > 0 && versions[n-1].retired.IsZero() {
+		current = versions[n-1]
+	} else {
+		current = generate(version + 1)
+	}
+	if rotation > 0 {
+		if rotate := current.created.Add(rotation); !now.Before(rotate) {
+			current.retired = now
+			rotated = append(rotated, current)
+			schedule(now.Add(overlap))
+			current = generate(current.version + 1)
+		}
+		schedule(current.created.Add(rotation))
+	}
+	return append(rotated, current), next
+}
+
+// readTSIGKeys reads the versions of the generated keys from secret.
+func readTSIGKeys(secret *corev1.Secret) ([]tsigKeyVersion, error) {
+	versions := []tsigKeyVersion{}
+	for k, s := range secret.Data {
+		if !strings.HasSuffix(k, ".secret") {
+			continue
+		}
+		name := strings.TrimSuffix(k, ".secret")
+		v := tsigKeyVersion{name: name + ".", secret: string(s), key: string(secret.Data[name+".key"])}
+		version, err := strconv.Atoi(string(secret.Data[name+".version"]))
+		if err != nil {
+			return nil, fmt.Errorf("parsing %s.version: %w", name, err)
+		}
+		v.version = version
+		for field, t := range map[string]*time.Time{"created": &v.created, "retired": &v.retired} {
+			value, ok := secret.Data[name+"."+field]
+			if !ok {
+				continue
+			}
+			if *t, err = time.Parse(time.RFC3339, string(value)); err != nil {
+				return nil, fmt.Errorf("parsing %s.%s: %w", name, field, err)
+			}
+		}
+		versions = append(versions, v)
+	}
+	sortTSIGKeys(versions)
+	return versions, nil
+}
+
+// writeTSIGKeys returns the data of the Secret holding versions.
+func writeTSIGKeys(versions []tsigKeyVersion) map[string][]byte {
+	data := map[string][]byte{}
+	for _, v := range versions {
+		name := strings.TrimSuffix(v.name, ".")
+		data[name+".secret"] = []byte(v.secret)
+		data[name+".key"] = []byte(v.key)
+		data[name+".version"] = []byte(strconv.Itoa(v.version))
+		for field, t := range map[string]time.Time{"created": v.created, "retired": v.retired} {
+			if !t.IsZero() {
+				data[name+"."+field] = []byte(t.UTC().Format(time.RFC3339))
+			}
+		}
+	}
+	return data
+}
+
+// sortTSIGKeys sorts versions by key, and from old to new.
+func sortTSIGKeys(versions []tsigKeyVersion) {
+	sort.SliceStable(versions, func(i, j int) bool {
+		if versions[i].key != versions[j].key {
+			return versions[i].key < versions[j].key
+		}
+		if versions[i].version != versions[j].version {
+			return versions[i].version < versions[j].version
+		}
+		return versions[i].name < versions[j].name
+	})
+}
+
+// tsigKeySecretData returns the data of the Secret publishing the current version v of a named
+// key of ksdns: the tsigKey and tsigSecret fields and the fields expected by consumer.
+func tsigKeySecretData(ksdns *dnsv1alpha1.Ksdns, consumer string, v tsigKeyVersion, algorithm string) map[string][]byte {
+	data := map[string][]byte{
+		"tsigKey":    []byte(v.name),
+		"tsigSecret": []byte(v.secret),
+	}
+	host := fmt.Sprintf("%s.%s.svc", zupdName(ksdns), ksdns.Namespace)
+	port := strconv.Itoa(int(servicePort(ksdns.Spec.Expose.Zupd, 1053)))
+	switch consumer {
+	case tsigConsumerExternalDNS:
+		// The environment of the rfc2136 provider, e.g. with envFrom
+		data["EXTERNAL_DNS_RFC2136_HOST"] = []byte(host)
+		data["EXTERNAL_DNS_RFC2136_PORT"] = []byte(port)
+		data["EXTERNAL_DNS_RFC2136_TSIG_KEYNAME"] = []byte(v.name)
+		data["EXTERNAL_DNS_RFC2136_TSIG_SECRET"] = []byte(v.secret)
+		data["EXTERNAL_DNS_RFC2136_TSIG_SECRET_ALG"] = []byte(algorithm)
+	case tsigConsumerCertManager:
+		// The settings of the rfc2136 solver, the secret is referenced by tsigSecretSecretRef
+		data["nameserver"] = []byte(net.JoinHostPort(host, port))
+		data["tsigKeyName"] = []byte(v.name)
+		data["tsigAlgorithm"] = []byte(strings.ToUpper(strings.ReplaceAll(algorithm, "-", "")))
+	}
+	return data
+}
+
+// tsigKeyStatus returns the status of the current version v of a key published in secret, rotated
+// after rotation if set.
+func tsigKeyStatus(v tsigKeyVersion, secret string, rotation time.Duration) dnsv1alpha1.TSIGKeyStatus {
+	status := dnsv1alpha1.TSIGKeyStatus{Name: v.key, KeyName: v.name, Secret: secret}
+	if v.created.IsZero() {
+		return status
+	}
+	created := metav1.NewTime(v.created.UTC().Truncate(time.Second))
+	status.Created = &created
+	if rotation > 0 {
+		next := metav1.NewTime(v.created.Add(rotation).UTC().Truncate(time.Second))
+		status.NextRotation = &next
+	}
+	return status
+}
@@ -0,0 +1,237 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	dnsv1alpha1 "github.com/cldmnky/ksdns/apis/dns/v1alpha1"
+)
+
+func TestTSIGKeyName(t *testing.T) {
+	ksdns := &dnsv1alpha1.Ksdns{ObjectMeta: metav1.ObjectMeta{Name: "ksdns"}}
+	assert.Equal(t, "ksdns.tsigKey.", tsigKeyName(ksdns, "", 1))
+	assert.Equal(t, "ksdns-2.tsigKey.", tsigKeyName(ksdns, "", 2))
+	assert.Equal(t, "external-dns.ksdns.", tsigKeyName(ksdns, "external-dns", 1))
+	assert.Equal(t, "external-dns-3.ksdns.", tsigKeyName(ksdns, "external-dns", 3))
+}
+
+func TestRotateTSIGKey(t *testing.T) {
+	now := time.Date(2022, 12, 1, 0, 0, 0, 0, time.UTC)
+	rotation, overlap := 30*24*time.Hour, 24*time.Hour
+	generate := func(version int) tsigKeyVersion {
+		return tsigKeyVersion{version: version, name: fmt.Sprintf("key-%d.", version), created: now}
+	}
+	version := func(v int, created, retired time.Time) tsigKeyVersion {
+		k := generate(v)
+		k.created, k.retired = created, retired
+		return k
+	}
+	versionNames := func(versions []tsigKeyVersion) []string {
+		names := []string{}
+		for _, v := range versions {
+			names = append(names, v.name)
+		}
+		return names
+	}
+
+	testCases := []struct {
+		name     string
+		versions []tsigKeyVersion
+		rotation time.Duration
+		expected []string
+		next     time.Time
+	}{
+		{
+			name:     "Generated",
+			expected: []string{"key-1."},
+		},
+		{
+			name:     "Generated with rotation",
+			rotation: rotation,
+			expected: []string{"key-1."},
+			next:     now.Add(rotation),
+		},
+		{
+			name:     "Not rotated",
+			versions: []tsigKeyVersion{version(1, now.Add(-rotation), time.Time{})},
+			expected: []string{"key-1."},
+		},
+		{
+			name:     "Before rotation",
+			versions: []tsigKeyVersion{version(1, now.Add(-time.Hour), time.Time{})},
+			rotation: rotation,
+			expected: []string{"key-1."},
+			next:     now.Add(rotation - time.Hour),
+		},
+		{
+			name:     "Rotated",
+			versions: []tsigKeyVersion{version(1, now.Add(-rotation), time.Time{})},
+			rotation: rotation,
+			expected: []string{"key-1.", "key-2."},
+			next:     now.Add(overlap),
+		},
+		{
+			name: "In overlap",
+			versions: []tsigKeyVersion{
+				version(1, now.Add(-rotation-time.Hour), now.Add(-time.Hour)),
+				version(2, now.Add(-time.Hour), time.Time{}),
+			},
+			rotation: rotation,
+			expected: []string{"key-1.", "key-2."},
+			next:     now.Add(overlap - time.Hour),
+		},
+		{
+			name: "After overlap",
+			versions: []tsigKeyVersion{
+				version(1, now.Add(-rotation-overlap), now.Add(-overlap)),
+				version(2, now.Add(-overlap), time.Time{}),
+			},
+			rotation: rotation,
+			expected: []string{"key-2."},
+			next:     now.Add(rotation - overlap),
+		},
+		{
+			name:     "Rotation disabled after rotating",
+			versions: []tsigKeyVersion{version(1, now.Add(-rotation), now.Add(-time.Hour))},
+			expected: []string{"key-1.", "key-2."},
+			next:     now.Add(overlap - time.Hour),
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			versions, next := rotateTSIGKey(tc.versions, tc.rotation, overlap, generate, now)
+			assert.Equal(t, tc.expected, versionNames(versions))
+			assert.Equal(t, tc.next, next)
+			assert.True(t, versions[len(versions)-1].retired.IsZero(), "the newest version is current")
+			for _, v := range versions[:len(versions)-1] {
+				assert.False(t, v.retired.IsZero(), "only the newest version is current")
+			}
+		})
+	}
+}
+
+func TestReadWriteTSIGKeys(t *testing.T) {
+	now := time.Date(2022, 12, 1, 0, 0, 0, 0, time.UTC)
+	versions := []tsigKeyVersion{
+		{version: 1, name: "ksdns.tsigKey.", secret: "a", created: now.Add(-time.Hour), retired: now},
+		{version: 2, name: "ksdns-2.tsigKey.", secret: "b", created: now},
+		{key: "external-dns", version: 1, name: "external-dns.ksdns.", secret: "c", created: now},
+	}
+	read, err := readTSIGKeys(&corev1.Secret{Data: writeTSIGKeys(versions)})
+	require.NoError(t, err)
+	assert.Equal(t, versions, read)
+
+	_, err = readTSIGKeys(&corev1.Secret{Data: map[string][]byte{"a.secret": []byte("a"), "a.version": []byte("first")}})
+	assert.Error(t, err)
+}
+
+func TestTSIGKeySecretData(t *testing.T) {
+	ksdns := &dnsv1alpha1.Ksdns{ObjectMeta: metav1.ObjectMeta{Name: "ksdns", Namespace: "dns"}}
+	v := tsigKeyVersion{name: "external-dns.ksdns.", secret: "c2VjcmV0"}
+
+	data := tsigKeySecretData(ksdns, "", v, "hmac-sha256")
+	assert.Equal(t, map[string][]byte{"tsigKey": []byte(v.name), "tsigSecret": []byte(v.secret)}, data)
+
+	data = tsigKeySecretData(ksdns, tsigConsumerExternalDNS, v, "hmac-sha256")
+	assert.Equal(t, "ksdns-zupd.dns.svc", string(data["EXTERNAL_DNS_RFC2136_HOST"]))
+	assert.Equal(t, "1053", string(data["EXTERNAL_DNS_RFC2136_PORT"]))
+	assert.Equal(t, v.name, string(data["EXTERNAL_DNS_RFC2136_TSIG_KEYNAME"]))
+	assert.Equal(t, v.secret, string(data["EXTERNAL_DNS_RFC2136_TSIG_SECRET"]))
+	assert.Equal(t, "hmac-sha256", string(data["EXTERNAL_DNS_RFC2136_TSIG_SECRET_ALG"]))
+
+	ksdns.Spec.Expose.Zupd = &dnsv1alpha1.ExposeService{Port: 53}
+	data = tsigKeySecretData(ksdns, tsigConsumerCertManager, v, "hmac-sha512")
+	assert.Equal(t, "ksdns-zupd.dns.svc:53", string(data["nameserver"]))
+	assert.Equal(t, v.name, string(data["tsigKeyName"]))
+	assert.Equal(t, "HMACSHA512", string(data["tsigAlgorithm"]))
+	assert.Equal(t, v.secret, string(data["tsigSecret"]))
+}
+
+func TestEnsureTSIGKeys(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+	require.NoError(t, dnsv1alpha1.AddToScheme(scheme))
+	ksdns := &dnsv1alpha1.Ksdns{
+		ObjectMeta: metav1.ObjectMeta{Name: "ksdns", Namespace: "dns", UID: "1"},
+		Spec: dnsv1alpha1.KsdnsSpec{
+			Security: dnsv1alpha1.Security{
+				Keys: []dnsv1alpha1.TSIGKey{
+					{Name: "external-dns", Consumer: tsigConsumerExternalDNS},
+					{Name: "break-glass", Secret: &corev1.LocalObjectReference{Name: "break-glass"}},
+				},
+			},
+		},
+	}
+	// The key of the Ksdns generated before the keys were rotated, and a key provided by the user
+	legacy := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "ksdns", Namespace: "dns"},
+		Data:       map[string][]byte{"tsigKey": []byte(tsigKey), "tsigSecret": []byte("bGVnYWN5")},
+	}
+	breakGlass := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "break-glass", Namespace: "dns"},
+		Data:       map[string][]byte{"tsigKey": []byte("break-glass."), "tsigSecret": []byte("YnJlYWs=")},
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(ksdns, legacy, breakGlass).Build()
+	r := &Reconciler{Client: c, Scheme: scheme}
+	ctx := context.Background()
+
+	keys, next, err := r.ensureTSIGKeys(ctx, ksdns)
+	require.NoError(t, err)
+	assert.True(t, next.IsZero())
+	names := map[string]string{}
+	for _, k := range keys {
+		names[k.name] = k.secret
+	}
+	assert.Len(t, names, 3)
+	assert.Equal(t, "bGVnYWN5", names[tsigKey], "the legacy key is kept")
+	assert.Equal(t, "YnJlYWs=", names["break-glass."])
+	assert.NotEmpty(t, names["external-dns.ksdns."])
+	assert.Equal(t, tsigKey, currentTSIGKey(ksdns))
+
+	published := &corev1.Secret{}
+	require.NoError(t, c.Get(ctx, types.NamespacedName{Name: "ksdns-tsig-external-dns", Namespace: "dns"}, published))
+	assert.Equal(t, "external-dns.ksdns.", string(published.Data["EXTERNAL_DNS_RFC2136_TSIG_KEYNAME"]))
+	require.NoError(t, c.Get(ctx, types.NamespacedName{Name: "ksdns-tsig-break-glass", Namespace: "dns"}, published))
+	assert.Equal(t, "break-glass.", string(published.Data["tsigKey"]))
+
+	// Rotate the key of the Ksdns
+	rotationPeriod := time.Hour
+	ksdns.Spec.Security.RotationPeriod = &metav1.Duration{Duration: rotationPeriod}
+	ksdns.Spec.Security.OverlapPeriod = metav1.Duration{Duration: time.Minute}
+	state := &corev1.Secret{}
+	require.NoError(t, c.Get(ctx, types.NamespacedName{Name: tsigStateName(ksdns), Namespace: "dns"}, state))
+	state.Data["ksdns.tsigKey.created"] = []byte(time.Now().Add(-2 * rotationPeriod).UTC().Format(time.RFC3339))
+	require.NoError(t, c.Update(ctx, state))
+
+	keys, next, err = r.ensureTSIGKeys(ctx, ksdns)
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), next, 5*time.Second)
+	names = map[string]string{}
+	for _, k := range keys {
+		names[k.name] = k.secret
+	}
+	assert.Contains(t, names, tsigKey, "the previous version is accepted")
+	assert.Contains(t, names, "ksdns-2.tsigKey.")
+	assert.Equal(t, "ksdns-2.tsigKey.", currentTSIGKey(ksdns))
+	require.NoError(t, c.Get(ctx, types.NamespacedName{Name: "ksdns", Namespace: "dns"}, published))
+	assert.Equal(t, "ksdns-2.tsigKey.", string(published.Data["tsigKey"]))
+
+	// Remove a key
+	ksdns.Spec.Security.Keys = ksdns.Spec.Security.Keys[:1]
+	_, _, err = r.ensureTSIGKeys(ctx, ksdns)
+	require.NoError(t, err)
+	err = c.Get(ctx, types.NamespacedName{Name: "ksdns-tsig-break-glass", Namespace: "dns"}, published)
+	assert.True(t, apierrors.IsNotFound(err), "the Secret of the removed key is deleted")
+}
@@ -4,6 +4,10 @@ import (
 	"context"
 
 	dnsv1alpha1 "github.com/cldmnky/ksdns/apis/dns/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/util/retry"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -12,6 +16,9 @@ import (
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 )
 
+// configHashAnnotation is the annotation of the pods holding the hash of their configuration
+const configHashAnnotation = "ksdns.io/config-hash"
+
 // make labels for the deployment
 func makeLabels(name string, ksdns *dnsv1alpha1.Ksdns) map[string]string {
 	return map[string]string{
@@ -50,3 +57,75 @@ func CreateOrUpdateWithRetries(
 	})
 	return operationResult, updateErr
 }
+
+// defaultTopologySpreadConstraints spread the pods of a deployment over the nodes and zones, as
+// far as possible.
+var defaultTopologySpreadConstraints = []corev1.TopologySpreadConstraint{
+	{
+		MaxSkew:           1,
+		TopologyKey:       corev1.LabelHostname,
+		WhenUnsatisfiable: corev1.ScheduleAnyway,
+	},
+	{
+		MaxSkew:           1,
+		TopologyKey:       corev1.LabelTopologyZone,
+		WhenUnsatisfiable: corev1.ScheduleAnyway,
+	},
+}
+
+// applyPodSettings applies settings to the pod spec and its containers. The topology spread
+// constraints without a label selector select the pods matching selector.
+func applyPodSettings(spec *corev1.PodSpec, settings dnsv1alpha1.PodSettings, selector map[string]string) {
+	for i := range spec.Containers {
+		spec.Containers[i].ImagePullPolicy = settings.ImagePullPolicy
+		spec.Containers[i].Resources = settings.Resources
+	}
+	spec.NodeSelector = settings.NodeSelector
+	spec.Tolerations = settings.Tolerations
+	spec.Affinity = settings.Affinity
+	spec.TopologySpreadConstraints = nil
+	for _, c := range settings.TopologySpreadConstraints {
+		if c.LabelSelector == nil {
+			c.LabelSelector = &metav1.LabelSelector{MatchLabels: selector}
+		}
+		spec.TopologySpreadConstraints = append(spec.TopologySpreadConstraints, c)
+	}
+}
+
+// rollingUpdate is the strategy of the deployments: a pod is only replaced once its successor is
+// ready, so that a configuration change does not reduce the capacity.
+func rollingUpdate() appsv1.DeploymentStrategy {
+	maxUnavailable, maxSurge := intstr.FromInt(0), intstr.FromInt(1)
+	return appsv1.DeploymentStrategy{
+		Type: appsv1.RollingUpdateDeploymentStrategyType,
+		RollingUpdate: &appsv1.RollingUpdateDeployment{
+			MaxUnavailable: &maxUnavailable,
+			MaxSurge:       &maxSurge,
+		},
+	}
+}
+
+// stopDeployment scales deployment to zero, when its Corefile has no server block to run.
+func stopDeployment(deployment *appsv1.Deployment) {
+	replicas := int32(0)
+	deployment.Spec.Replicas = &replicas
+}
+
+// mutateDeployment sets the fields of deployment managed by the operator to those of desired.
+// The selector is immutable and only set on creation.
+func mutateDeployment(deployment, desired *appsv1.Deployment) {
+	if deployment.CreationTimestamp.IsZero() {
+		deployment.Spec.Selector = desired.Spec.Selector
+	}
+	deployment.Spec.Replicas = desired.Spec.Replicas
+	deployment.Spec.Strategy = desired.Spec.Strategy
+	deployment.Spec.Template = desired.Spec.Template
+}
+
+// security returns the security configuration of ksdns with the defaults set, as the defaulting
+// webhook may not be enabled.
+func security(ksdns *dnsv1alpha1.Ksdns) dnsv1alpha1.Security {
+	s := *ksdns.Spec.Security.DeepCopy()
+	s.Default()
+	return s
+}
@@ -2,23 +2,62 @@ package dns
 
 import (
 	"context"
+	"fmt"
 	"net"
+	"strings"
+	"time"
 
 	dnsv1alpha1 "github.com/cldmnky/ksdns/apis/dns/v1alpha1"
 	rfc1035v1alpha1 "github.com/cldmnky/ksdns/pkg/zupd/api/v1alpha1"
+	"github.com/miekg/dns"
 
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 )
 
-func (r *Reconciler) ensureZones(ctx context.Context, ksdns *dnsv1alpha1.Ksdns) error {
+// zoneHashAnnotation holds the hash of the content of a Zone, the serial of the zone is only
+// changed with the content.
+const zoneHashAnnotation = "ksdns.io/zone-hash"
+
+// loadBalancerLookupPeriod is the period of the lookups of the addresses of a CoreDNS load
+// balancer without ingress IPs.
+const loadBalancerLookupPeriod = 30 * time.Second
+
+// ensureZones ensures the Zones of ksdns, with the glue of the CoreDNS service. It returns the
+// time of the next lookup of the glue, zero if the glue is updated by the watch of the service.
+func (r *Reconciler) ensureZones(ctx context.Context, ksdns *dnsv1alpha1.Ksdns) (time.Time, error) {
 	log := log.FromContext(ctx)
 	labels := makeLabels("zone", ksdns)
+	nsIPs, lookup, err := r.getCoreDNSIPs(ctx, ksdns)
+	if err != nil {
+		return time.Time{}, err
+	}
+	var retry time.Time
+	if lookup {
+		retry = time.Now().Add(loadBalancerLookupPeriod)
+	}
+	// A zone that fails does not prevent the others from being served
+	errs := []error{}
+	served := []string{}
 	for _, z := range ksdns.Spec.Zones {
-		zoneSpec, err := z.ToRfc1035Zone(net.IPv4(10, 0, 0, 10))
+		// The content of the zone, without its serial
+		content, err := z.ToRfc1035Zone(0, nsIPs...)
 		if err != nil {
 			log.Error(err, "Failed to convert ksdns zone to rfc1035 zone")
+			errs = append(errs, fmt.Errorf("zone %s: %w", z.Origin, err))
+			continue
+		}
+		dnssec, err := r.zoneDNSSEC(ctx, ksdns, z.Origin)
+		if err != nil {
+			log.Error(err, "Failed to get DNSSEC keys", "zone", z.Origin)
+			errs = append(errs, fmt.Errorf("zone %s: %w", z.Origin, err))
 			continue
 		}
 		// Create or update the zone
@@ -29,10 +68,222 @@ func (r *Reconciler) ensureZones(ctx context.Context, ksdns *dnsv1alpha1.Ksdns)
 				Labels:    labels,
 			},
 		}
-		CreateOrUpdateWithRetries(ctx, r.Client, zone, func() error {
-			zone.Spec = *zoneSpec
+		if _, err := CreateOrUpdateWithRetries(ctx, r.Client, zone, func() error {
+			// The labels of an orphaned zone declared again are restored
+			if zone.Labels == nil {
+				zone.Labels = map[string]string{}
+			}
+			for k, v := range labels {
+				zone.Labels[k] = v
+			}
+			// Only the managed fields are set: the records and the settings added by users, and
+			// the dynamic records in the status, are kept
+			hash := hashData(map[string][]byte{"zone": []byte(content.Zone), "records": []byte(zone.Spec.Records)})
+			zoneSpec, err := z.ToRfc1035Zone(zoneSerial(zone, hash, time.Now()), nsIPs...)
+			if err != nil {
+				return err
+			}
+			zone.Spec.Zone = zoneSpec.Zone
+			zone.Spec.DNSSEC = dnssec
+			if zone.Annotations == nil {
+				zone.Annotations = map[string]string{}
+			}
+			zone.Annotations[zoneHashAnnotation] = hash
 			return ctrl.SetControllerReference(ksdns, zone, r.Scheme)
-		})
+		}); err != nil {
+			errs = append(errs, fmt.Errorf("zone %s: %w", z.Origin, err))
+			continue
+		}
+		served = append(served, z.Origin)
+	}
+	if err := r.pruneZones(ctx, ksdns); err != nil {
+		errs = append(errs, err)
+	}
+	if len(served) == 0 {
+		served = nil
+	}
+	ksdns.Status.Zones = served
+	return retry, utilerrors.NewAggregate(errs)
+}
+
+// zoneSerial returns the serial of zone for the content with hash: its current serial if the
+// content did not change, or else a new serial, following the current serial and the serial of
+// the dynamic updates in serial number arithmetic.
+func zoneSerial(zone *rfc1035v1alpha1.Zone, hash string, now time.Time) uint32 {
+	current := zoneFileSerial(zone.Spec.Zone, zone.Name)
+	if current != 0 && zone.Annotations[zoneHashAnnotation] == hash {
+		return current
+	}
+	return rfc1035v1alpha1.NextSerial(now, current, zone.Status.Serial)
+}
+
+// zoneFileSerial returns the serial of the SOA record of the zone file of origin, 0 if none.
+func zoneFileSerial(zoneFile, origin string) uint32 {
+	zp := dns.NewZoneParser(strings.NewReader(zoneFile), dns.Fqdn(origin), "")
+	for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+		if soa, ok := rr.(*dns.SOA); ok {
+			return soa.Serial
+		}
+	}
+	return 0
+}
+
+// pruneZones deletes the Zones of ksdns that are no longer in its spec, or orphans them as set by
+// its zone pruning policy.
+func (r *Reconciler) pruneZones(ctx context.Context, ksdns *dnsv1alpha1.Ksdns) error {
+	log := log.FromContext(ctx)
+	declared := map[string]bool{}
+	for _, z := range ksdns.Spec.Zones {
+		declared[z.Origin] = true
+	}
+	zones := &rfc1035v1alpha1.ZoneList{}
+	if err := r.List(ctx, zones, client.InNamespace(ksdns.Namespace), client.MatchingLabels(makeSelector("zone", ksdns))); err != nil {
+		return err
+	}
+	errs := []error{}
+	for i := range zones.Items {
+		zone := &zones.Items[i]
+		if declared[zone.Name] || !metav1.IsControlledBy(zone, ksdns) {
+			continue
+		}
+		var err error
+		if orphanZone(ksdns.Spec.ZonePruning, zone) {
+			log.Info("Orphaning zone removed from ksdns", "zone", zone.Name)
+			err = r.orphanZone(ctx, ksdns, zone)
+		} else {
+			log.Info("Deleting zone removed from ksdns", "zone", zone.Name)
+			err = client.IgnoreNotFound(r.Delete(ctx, zone))
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("zone %s: %w", zone.Name, err))
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// orphanZone returns true if zone, removed from its Ksdns, is kept with policy.
+func orphanZone(policy dnsv1alpha1.ZonePruningPolicy, zone *rfc1035v1alpha1.Zone) bool {
+	switch policy {
+	case dnsv1alpha1.ZonePruningOrphan:
+		return true
+	case dnsv1alpha1.ZonePruningRetainDynamicRecords:
+		return len(zone.Status.DynamicRRs) > 0
+	default:
+		return false
+	}
+}
+
+// orphanZone removes the owner reference and the labels of ksdns from zone.
+func (r *Reconciler) orphanZone(ctx context.Context, ksdns *dnsv1alpha1.Ksdns, zone *rfc1035v1alpha1.Zone) error {
+	patch := client.MergeFrom(zone.DeepCopy())
+	refs := []metav1.OwnerReference{}
+	for _, ref := range zone.OwnerReferences {
+		if ref.UID != ksdns.UID {
+			refs = append(refs, ref)
+		}
+	}
+	zone.OwnerReferences = refs
+	for k := range makeLabels("zone", ksdns) {
+		delete(zone.Labels, k)
+	}
+	return r.Patch(ctx, zone, patch)
+}
+
+// getCoreDNSIPs gets the addresses of the CoreDNS service, used for the glue of the zones, see
+// serviceIPs. The hostnames of a load balancer without ingress IPs, e.g. an AWS NLB, are resolved.
+// A load balancer without any address falls back to its cluster IPs, which is reported by the
+// Glue condition of ksdns. It returns true if the addresses must be looked up again: they were
+// resolved, or the load balancer has no address yet.
+func (r *Reconciler) getCoreDNSIPs(ctx context.Context, ksdns *dnsv1alpha1.Ksdns) ([]net.IP, bool, error) {
+	log := log.FromContext(ctx)
+	svc := &corev1.Service{}
+	if err := r.Get(ctx, types.NamespacedName{Name: corednsName(ksdns), Namespace: ksdns.Namespace}, svc); err != nil {
+		if apierrors.IsNotFound(err) {
+			// The zones are updated once the service is created
+			log.Info("coredns service not found, skipping glue")
+			setGlueCondition(ksdns, metav1.ConditionUnknown, reasonServicePending, "The coredns service is not created yet")
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	if svc.Spec.Type != corev1.ServiceTypeLoadBalancer || len(parseIPs(ingressIPs(svc))) > 0 || len(parseIPs(svc.Spec.ExternalIPs)) > 0 {
+		setGlueCondition(ksdns, metav1.ConditionTrue, reasonServiceAddresses, "The glue of the zones holds the addresses of the coredns service")
+		return serviceIPs(svc), false, nil
+	}
+	ips := []net.IP{}
+	for _, ingress := range svc.Status.LoadBalancer.Ingress {
+		if ingress.Hostname == "" {
+			continue
+		}
+		resolved, err := r.lookupIP(ctx, ingress.Hostname)
+		if err != nil {
+			log.Info("failed to resolve the coredns load balancer", "hostname", ingress.Hostname, "error", err.Error())
+			continue
+		}
+		ips = append(ips, resolved...)
+	}
+	if len(ips) > 0 {
+		setGlueCondition(ksdns, metav1.ConditionTrue, reasonServiceAddresses, "The glue of the zones holds the addresses of the coredns load balancer")
+		return ips, true, nil
+	}
+	// The cluster IPs are only reachable in the cluster, they are replaced once the load balancer
+	// has an address
+	log.Info("coredns load balancer has no address yet, using its cluster IPs as glue")
+	setGlueCondition(ksdns, metav1.ConditionFalse, reasonClusterIPFallback, "The coredns load balancer has no address yet, the glue of the zones holds its cluster IPs")
+	return serviceIPs(svc), true, nil
+}
+
+// setGlueCondition sets the Glue condition of ksdns.
+func setGlueCondition(ksdns *dnsv1alpha1.Ksdns, status metav1.ConditionStatus, reason, message string) {
+	meta.SetStatusCondition(&ksdns.Status.Conditions, metav1.Condition{
+		Type:    dnsv1alpha1.TypeGlueKsdns,
+		Status:  status,
+		Reason:  reason,
+		Message: message,
+	})
+}
+
+// lookupIP resolves host with the resolver of r, or the default resolver.
+func (r *Reconciler) lookupIP(ctx context.Context, host string) ([]net.IP, error) {
+	if r.resolver == nil {
+		return net.DefaultResolver.LookupIP(ctx, "ip", host)
+	}
+	return r.resolver.LookupIP(ctx, "ip", host)
+}
+
+// serviceIPs returns the addresses of svc: its load balancer ingress IPs, its external IPs or its
+// cluster IPs, in that order.
+func serviceIPs(svc *corev1.Service) []net.IP {
+	clusterIPs := svc.Spec.ClusterIPs
+	if len(clusterIPs) == 0 && svc.Spec.ClusterIP != "" {
+		clusterIPs = []string{svc.Spec.ClusterIP}
+	}
+	for _, addresses := range [][]string{ingressIPs(svc), svc.Spec.ExternalIPs, clusterIPs} {
+		if ips := parseIPs(addresses); len(ips) > 0 {
+			return ips
+		}
 	}
 	return nil
 }
+
+// ingressIPs returns the load balancer ingress IPs of svc.
+func ingressIPs(svc *corev1.Service) []string {
+	ips := []string{}
+	for _, i := range svc.Status.LoadBalancer.Ingress {
+		if i.IP != "" {
+			ips = append(ips, i.IP)
+		}
+	}
+	return ips
+}
+
+// parseIPs parses addresses, skipping the invalid ones.
+func parseIPs(addresses []string) []net.IP {
+	ips := []net.IP{}
+	for _, a := range addresses {
+		if ip := net.ParseIP(a); ip != nil {
+			ips = append(ips, ip)
+		}
+	}
+	return ips
+}
@@ -0,0 +1,240 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	dnsv1alpha1 "github.com/cldmnky/ksdns/apis/dns/v1alpha1"
+	rfc1035v1alpha1 "github.com/cldmnky/ksdns/pkg/zupd/api/v1alpha1"
+)
+
+func TestServiceIPs(t *testing.T) {
+	svc := &corev1.Service{Spec: corev1.ServiceSpec{ClusterIP: "10.0.0.10", ClusterIPs: []string{"10.0.0.10", "fd00::10"}}}
+	assert.Equal(t, []net.IP{net.ParseIP("10.0.0.10"), net.ParseIP("fd00::10")}, serviceIPs(svc))
+
+	svc.Spec.ExternalIPs = []string{"192.168.1.1"}
+	assert.Equal(t, []net.IP{net.ParseIP("192.168.1.1")}, serviceIPs(svc))
+
+	svc.Status.LoadBalancer.Ingress = []corev1.LoadBalancerIngress{{Hostname: "nlb.example.com"}}
+	assert.Equal(t, []net.IP{net.ParseIP("192.168.1.1")}, serviceIPs(svc))
+
+	svc.Status.LoadBalancer.Ingress = append(svc.Status.LoadBalancer.Ingress, corev1.LoadBalancerIngress{IP: "2001:db8::1"})
+	assert.Equal(t, []net.IP{net.ParseIP("2001:db8::1")}, serviceIPs(svc))
+
+	// The cluster IPs of a load balancer are used until it has an address
+	lb := &corev1.Service{Spec: corev1.ServiceSpec{Type: corev1.ServiceTypeLoadBalancer, ClusterIP: "10.0.0.10"}}
+	assert.Equal(t, []net.IP{net.ParseIP("10.0.0.10")}, serviceIPs(lb))
+	lb.Status.LoadBalancer.Ingress = []corev1.LoadBalancerIngress{{Hostname: "nlb.example.com"}}
+	assert.Equal(t, []net.IP{net.ParseIP("10.0.0.10")}, serviceIPs(lb))
+	lb.Spec.ExternalIPs = []string{"192.168.1.1"}
+	assert.Equal(t, []net.IP{net.ParseIP("192.168.1.1")}, serviceIPs(lb))
+
+	assert.Empty(t, serviceIPs(&corev1.Service{}))
+}
+
+// fakeResolver resolves the hostnames in its map.
+type fakeResolver map[string][]net.IP
+
+func (f fakeResolver) LookupIP(_ context.Context, _, host string) ([]net.IP, error) {
+	if ips, ok := f[host]; ok {
+		return ips, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+}
+
+func TestGetCoreDNSIPs(t *testing.T) {
+	ksdns := &dnsv1alpha1.Ksdns{ObjectMeta: metav1.ObjectMeta{Name: "ksdns", Namespace: "dns"}}
+	service := func(typ corev1.ServiceType, ingress ...corev1.LoadBalancerIngress) *corev1.Service {
+		svc := &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: corednsName(ksdns), Namespace: "dns"},
+			Spec:       corev1.ServiceSpec{Type: typ, ClusterIP: "10.0.0.10"},
+		}
+		svc.Status.LoadBalancer.Ingress = ingress
+		return svc
+	}
+	resolver := fakeResolver{"nlb.example.com": {net.ParseIP("203.0.113.1"), net.ParseIP("203.0.113.2")}}
+
+	testCases := []struct {
+		name     string
+		svc      *corev1.Service
+		expected []net.IP
+		lookup   bool
+		reason   string
+	}{
+		{"no service", nil, nil, false, reasonServicePending},
+		{"cluster IP", service(corev1.ServiceTypeClusterIP), []net.IP{net.ParseIP("10.0.0.10")}, false, reasonServiceAddresses},
+		{"load balancer IP", service(corev1.ServiceTypeLoadBalancer, corev1.LoadBalancerIngress{IP: "198.51.100.1"}), []net.IP{net.ParseIP("198.51.100.1")}, false, reasonServiceAddresses},
+		{"load balancer hostname", service(corev1.ServiceTypeLoadBalancer, corev1.LoadBalancerIngress{Hostname: "nlb.example.com"}), []net.IP{net.ParseIP("203.0.113.1"), net.ParseIP("203.0.113.2")}, true, reasonServiceAddresses},
+		{"unresolved load balancer hostname", service(corev1.ServiceTypeLoadBalancer, corev1.LoadBalancerIngress{Hostname: "other.example.com"}), []net.IP{net.ParseIP("10.0.0.10")}, true, reasonClusterIPFallback},
+		{"pending load balancer", service(corev1.ServiceTypeLoadBalancer), []net.IP{net.ParseIP("10.0.0.10")}, true, reasonClusterIPFallback},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			builder := fake.NewClientBuilder()
+			if tc.svc != nil {
+				builder = builder.WithObjects(tc.svc)
+			}
+			r := &Reconciler{Client: builder.Build(), resolver: resolver}
+			ksdns := ksdns.DeepCopy()
+			ips, lookup, err := r.getCoreDNSIPs(context.Background(), ksdns)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, ips)
+			assert.Equal(t, tc.lookup, lookup)
+			glue := meta.FindStatusCondition(ksdns.Status.Conditions, dnsv1alpha1.TypeGlueKsdns)
+			require.NotNil(t, glue)
+			assert.Equal(t, tc.reason, glue.Reason)
+		})
+	}
+}
+
+func TestPruneZones(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, dnsv1alpha1.AddToScheme(scheme))
+	require.NoError(t, rfc1035v1alpha1.AddToScheme(scheme))
+
+	testCases := []struct {
+		policy   dnsv1alpha1.ZonePruningPolicy
+		orphaned []string
+		deleted  []string
+	}{
+		{policy: "", deleted: []string{"static.org", "dynamic.org"}},
+		{policy: dnsv1alpha1.ZonePruningDelete, deleted: []string{"static.org", "dynamic.org"}},
+		{policy: dnsv1alpha1.ZonePruningOrphan, orphaned: []string{"static.org", "dynamic.org"}},
+		{policy: dnsv1alpha1.ZonePruningRetainDynamicRecords, orphaned: []string{"dynamic.org"}, deleted: []string{"static.org"}},
+	}
+	for _, tc := range testCases {
+		t.Run(string(tc.policy), func(t *testing.T) {
+			ksdns := &dnsv1alpha1.Ksdns{
+				ObjectMeta: metav1.ObjectMeta{Name: "ksdns", Namespace: "dns", UID: "1"},
+				Spec: dnsv1alpha1.KsdnsSpec{
+					Zones:       []dnsv1alpha1.Zone{{Origin: "example.org"}},
+					ZonePruning: tc.policy,
+				},
+			}
+			zone := func(name string, dynamicRRs ...string) *rfc1035v1alpha1.Zone {
+				z := &rfc1035v1alpha1.Zone{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "dns", Labels: makeLabels("zone", ksdns)}}
+				require.NoError(t, ctrl.SetControllerReference(ksdns, z, scheme))
+				for _, rr := range dynamicRRs {
+					z.Status.DynamicRRs = append(z.Status.DynamicRRs, rfc1035v1alpha1.DynamicRR{RR: rr})
+				}
+				return z
+			}
+			// A zone created by a user is not pruned
+			user := &rfc1035v1alpha1.Zone{ObjectMeta: metav1.ObjectMeta{Name: "user.org", Namespace: "dns"}}
+			c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+				ksdns,
+				zone("example.org"),
+				zone("static.org"),
+				zone("dynamic.org", "www.dynamic.org. 300 IN A 10.0.0.1"),
+				user,
+			).Build()
+			r := &Reconciler{Client: c, Scheme: scheme}
+			ctx := context.Background()
+
+			require.NoError(t, r.pruneZones(ctx, ksdns))
+			for _, name := range []string{"example.org", "user.org"} {
+				z := &rfc1035v1alpha1.Zone{}
+				assert.NoError(t, c.Get(ctx, types.NamespacedName{Name: name, Namespace: "dns"}, z), name)
+			}
+			for _, name := range tc.orphaned {
+				z := &rfc1035v1alpha1.Zone{}
+				require.NoError(t, c.Get(ctx, types.NamespacedName{Name: name, Namespace: "dns"}, z), name)
+				assert.False(t, metav1.IsControlledBy(z, ksdns), name)
+				assert.NotContains(t, z.Labels, "app.kubernetes.io/instance", name)
+			}
+			for _, name := range tc.deleted {
+				err := c.Get(ctx, types.NamespacedName{Name: name, Namespace: "dns"}, &rfc1035v1alpha1.Zone{})
+				assert.True(t, apierrors.IsNotFound(err), name)
+			}
+		})
+	}
+}
+
+func TestZoneSerial(t *testing.T) {
+	now := time.Unix(1000, 0)
+	zone := func(serial, dynamicSerial uint32, hash string) *rfc1035v1alpha1.Zone {
+		z := &rfc1035v1alpha1.Zone{ObjectMeta: metav1.ObjectMeta{Name: "example.org", Annotations: map[string]string{zoneHashAnnotation: hash}}}
+		if serial != 0 {
+			z.Spec.Zone = fmt.Sprintf("example.org. 30 IN SOA ns.dns.example.org. hostmaster.example.org. %d 7200 1800 86400 30\n", serial)
+		}
+		z.Status.Serial = dynamicSerial
+		return z
+	}
+	assert.Equal(t, uint32(1000), zoneSerial(zone(0, 0, ""), "a", now), "new zone")
+	assert.Equal(t, uint32(500), zoneSerial(zone(500, 0, "a"), "a", now), "unchanged")
+	assert.Equal(t, uint32(1000), zoneSerial(zone(500, 0, "a"), "b", now), "changed")
+	assert.Equal(t, uint32(2001), zoneSerial(zone(2000, 0, "a"), "b", now), "changed, serial ahead of time")
+	assert.Equal(t, uint32(3001), zoneSerial(zone(2000, 3000, "a"), "b", now), "changed, after dynamic updates")
+	assert.Equal(t, uint32(1000), zoneSerial(zone(500, 4000000000, "a"), "b", now), "changed, after dynamic updates with a wrapped serial")
+}
+
+func TestEnsureZones(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+	require.NoError(t, dnsv1alpha1.AddToScheme(scheme))
+	require.NoError(t, rfc1035v1alpha1.AddToScheme(scheme))
+	ksdns := &dnsv1alpha1.Ksdns{
+		ObjectMeta: metav1.ObjectMeta{Name: "ksdns", Namespace: "dns", UID: "1"},
+		Spec: dnsv1alpha1.KsdnsSpec{
+			Zones: []dnsv1alpha1.Zone{{Origin: "example.org", Records: []dnsv1alpha1.Record{{Name: "www", Type: "A", TTL: 300, Target: "10.0.0.1"}}}},
+		},
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(ksdns).Build()
+	r := &Reconciler{Client: c, Scheme: scheme}
+	ctx := context.Background()
+	key := types.NamespacedName{Name: "example.org", Namespace: "dns"}
+	ensureZones := func() {
+		t.Helper()
+		lookup, err := r.ensureZones(ctx, ksdns)
+		require.NoError(t, err)
+		assert.True(t, lookup.IsZero())
+	}
+
+	ensureZones()
+	created := &rfc1035v1alpha1.Zone{}
+	require.NoError(t, c.Get(ctx, key, created))
+	serial := zoneFileSerial(created.Spec.Zone, "example.org")
+	assert.NotZero(t, serial)
+
+	// Rendering the same zone again does not change it
+	ensureZones()
+	zone := &rfc1035v1alpha1.Zone{}
+	require.NoError(t, c.Get(ctx, key, zone))
+	assert.Equal(t, created.ResourceVersion, zone.ResourceVersion)
+
+	// The records and settings added by users, and the dynamic records, are kept
+	zone.Spec.Records = "mail.example.org. 300 IN A 10.0.0.25\n"
+	zone.Spec.Mode = rfc1035v1alpha1.ZoneModeOverlay
+	zone.Status.DynamicRRs = []rfc1035v1alpha1.DynamicRR{{RR: "api.example.org.\t300\tIN\tA\t10.0.0.2"}}
+	zone.Status.Serial = serial + 10
+	require.NoError(t, c.Update(ctx, zone))
+	ensureZones()
+	require.NoError(t, c.Get(ctx, key, zone))
+	assert.Equal(t, "mail.example.org. 300 IN A 10.0.0.25\n", zone.Spec.Records)
+	assert.Equal(t, rfc1035v1alpha1.ZoneModeOverlay, zone.Spec.Mode)
+	assert.Len(t, zone.Status.DynamicRRs, 1)
+	assert.Equal(t, serial+11, zoneFileSerial(zone.Spec.Zone, "example.org"), "the serial follows the dynamic updates")
+	assert.Contains(t, zone.Spec.ZoneFile(), "mail.example.org.")
+
+	// A change of the Ksdns changes the serial
+	ksdns.Spec.Zones[0].Records[0].Target = "10.0.0.3"
+	ensureZones()
+	require.NoError(t, c.Get(ctx, key, zone))
+	assert.Contains(t, zone.Spec.Zone, "10.0.0.3")
+	assert.Greater(t, zoneFileSerial(zone.Spec.Zone, "example.org"), serial+11)
+}
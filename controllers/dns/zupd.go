@@ -1,17 +1,16 @@
 package dns
 
 import (
-	"bytes"
 	"context"
 	"fmt"
-	"text/template"
+	"net"
+	"sort"
 
-	"github.com/Masterminds/sprig/v3"
 	dnsv1alpha1 "github.com/cldmnky/ksdns/apis/dns/v1alpha1"
-	rfc1035v1alpha1 "github.com/cldmnky/ksdns/pkg/zupd/api/v1alpha1"
 
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
 	rbacv1 "k8s.io/api/rbac/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/intstr"
@@ -21,33 +20,10 @@ import (
 )
 
 var (
-	zupdName         = func(ksdns *dnsv1alpha1.Ksdns) string { return fmt.Sprintf("%s-zupd", ksdns.Name) }
-	zupdCorefileTmpl = template.Must(
-		template.New("Corefile").
-			Funcs(sprig.FuncMap()).
-			Parse(`# This files is auto-generated by ksdns-controller. Do NOT edit.
-{{range $index, $element := .Zones}}{{$element}}:1053{{end}} {
-  {{if .Debug}}debug{{end}}
-  {{if .Debug}}log{{end}}
-  reload
-  ready
-  health
-  prometheus
-  tsig {
-	secrets /etc/coredns/secret/tsig.conf
-	require none
-  }
-  dynamicupdate {{.Namespace}}
-  transfer {
-    to *
-    #{{range $index, $element := .TransferTo}}to {{$element}}
-    #{{end}}
-  }
-}
-`))
+	zupdName = func(ksdns *dnsv1alpha1.Ksdns) string { return fmt.Sprintf("%s-zupd", ksdns.Name) }
 )
 
-func (r *Reconciler) ensureZupd(ctx context.Context, ksdns *dnsv1alpha1.Ksdns) error {
+func (r *Reconciler) ensureZupd(ctx context.Context, ksdns *dnsv1alpha1.Ksdns, tsigConf string) error {
 	log := log.FromContext(ctx)
 
 	if err := r.ensureZupdSvc(ctx, ksdns); err != nil {
@@ -60,18 +36,26 @@ func (r *Reconciler) ensureZupd(ctx context.Context, ksdns *dnsv1alpha1.Ksdns) e
 	if err := r.ensureZupdNamespaceAdminRole(ctx, ksdns); err != nil {
 		return err
 	}
-	if err := r.ensureZupdConfigMap(ctx, ksdns); err != nil {
+	cf, err := r.ensureZupdConfigMap(ctx, ksdns)
+	if err != nil {
 		return err
 	}
+	configHash := configHash(cf, tsigConf)
 
-	deployment := zupdDeployment(ksdns)
+	desired := zupdDeployment(ksdns, configHash)
+	if cf.empty() {
+		stopDeployment(desired)
+	}
+	deployment := &appsv1.Deployment{ObjectMeta: desired.ObjectMeta}
 	op, err := CreateOrUpdateWithRetries(ctx, r.Client, deployment, func() error {
+		mutateDeployment(deployment, desired)
 		return ctrl.SetControllerReference(ksdns, deployment, r.Scheme)
 	})
 	if err != nil {
 		return err
 	}
-	log.Info("zupd", "deployment", zupdName(ksdns), "op", op)
+	log.Info("zupd", "deployment", zupdName(ksdns), "op", op, "configHash", configHash)
+	ksdns.Status.ZupdConfigHash = configHash
 	return nil
 }
 
@@ -92,32 +76,15 @@ func (r *Reconciler) ensureZupdServiceAccount(ctx context.Context, ksdns *dnsv1a
 }
 
 func (r *Reconciler) ensureZupdNamespaceAdminRole(ctx context.Context, ksdns *dnsv1alpha1.Ksdns) error {
+	secrets := dnssecSecretNames(ksdns)
 	role := &rbacv1.Role{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      zupdName(ksdns),
 			Namespace: ksdns.Namespace,
 		},
-		Rules: []rbacv1.PolicyRule{
-			{
-				APIGroups: []string{"rfc1035.ksdns.io"},
-				Resources: []string{"zones"},
-				Verbs:     []string{"get", "list", "watch", "create", "update", "patch", "delete"},
-			},
-			// leases
-			{
-				APIGroups: []string{"coordination.k8s.io"},
-				Resources: []string{"leases"},
-				Verbs:     []string{"get", "list", "watch", "create", "update", "patch", "delete"},
-			},
-			// Events
-			{
-				APIGroups: []string{""},
-				Resources: []string{"events"},
-				Verbs:     []string{"create", "patch"},
-			},
-		},
 	}
 	op, err := CreateOrUpdateWithRetries(ctx, r.Client, role, func() error {
+		role.Rules = zupdRoleRules(secrets)
 		return ctrl.SetControllerReference(ksdns, role, r.Scheme)
 	})
 	if err != nil {
@@ -153,8 +120,65 @@ func (r *Reconciler) ensureZupdNamespaceAdminRole(ctx context.Context, ksdns *dn
 
 }
 
+// zupdRoleRules returns the rules of the role of zupd. zupd reads the DNSSEC keys from secrets,
+// the only Secrets it can read.
+func zupdRoleRules(secrets []string) []rbacv1.PolicyRule {
+	rules := []rbacv1.PolicyRule{
+		{
+			APIGroups: []string{"rfc1035.ksdns.io"},
+			Resources: []string{"zones"},
+			Verbs:     []string{"get", "list", "watch", "create", "update", "patch", "delete"},
+		},
+		{
+			APIGroups: []string{"rfc1035.ksdns.io"},
+			Resources: []string{"zones/status"},
+			Verbs:     []string{"get", "update", "patch"},
+		},
+		// leases
+		{
+			APIGroups: []string{"coordination.k8s.io"},
+			Resources: []string{"leases"},
+			Verbs:     []string{"get", "list", "watch", "create", "update", "patch", "delete"},
+		},
+		// Events
+		{
+			APIGroups: []string{""},
+			Resources: []string{"events"},
+			Verbs:     []string{"create", "patch"},
+		},
+	}
+	if len(secrets) > 0 {
+		// DNSSEC keys, a rule without resource names would grant all Secrets
+		rules = append(rules, rbacv1.PolicyRule{
+			APIGroups:     []string{""},
+			Resources:     []string{"secrets"},
+			Verbs:         []string{"get"},
+			ResourceNames: secrets,
+		})
+	}
+	return rules
+}
+
 func (r *Reconciler) ensureZupdSvc(ctx context.Context, ksdns *dnsv1alpha1.Ksdns) error {
 	labels := makeLabels("zupd", ksdns)
+	port := servicePort(ksdns.Spec.Expose.Zupd, 1053)
+	spec, err := exposedServiceSpec(ksdns.Spec.Expose.Zupd, makeSelector("zupd", ksdns), []corev1.ServicePort{
+		{
+			Name:       "dns-tcp",
+			Port:       port,
+			TargetPort: intstr.FromInt(1053),
+			Protocol:   corev1.ProtocolTCP,
+		},
+		{
+			Name:       "dns-udp",
+			Port:       port,
+			TargetPort: intstr.FromInt(1053),
+			Protocol:   corev1.ProtocolUDP,
+		},
+	})
+	if err != nil {
+		return err
+	}
 	svc := &corev1.Service{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      zupdName(ksdns),
@@ -162,31 +186,23 @@ func (r *Reconciler) ensureZupdSvc(ctx context.Context, ksdns *dnsv1alpha1.Ksdns
 			Labels:    labels,
 		},
 	}
-	CreateOrUpdateWithRetries(ctx, r.Client, svc, func() error {
-		svc.Spec = corev1.ServiceSpec{
-			Selector: makeSelector("zupd", ksdns),
-			Ports: []corev1.ServicePort{
-				{
-					Name:       "dns-tcp",
-					Port:       1053,
-					TargetPort: intstr.FromInt(1053),
-					Protocol:   corev1.ProtocolTCP,
-				},
-				{
-					Name:       "dns-udp",
-					Port:       1053,
-					TargetPort: intstr.FromInt(1053),
-					Protocol:   corev1.ProtocolUDP,
-				},
-			},
-		}
+	op, err := CreateOrUpdateWithRetries(ctx, r.Client, svc, func() error {
+		setServiceAnnotations(svc, ksdns.Spec.Expose.Zupd)
+		svc.Spec = *spec
 		return ctrl.SetControllerReference(ksdns, svc, r.Scheme)
 	})
+	if err != nil {
+		return err
+	}
+	log.FromContext(ctx).Info("zupd", "service", zupdName(ksdns), "op", op)
+
+	// Report the address of the update endpoint
+	ksdns.Status.ZupdEndpoint = serviceEndpoint(svc)
 	return nil
-	// see if we can get the service ip from zupd
 }
 
-func (r *Reconciler) ensureZupdConfigMap(ctx context.Context, ksdns *dnsv1alpha1.Ksdns) error {
+// ensureZupdConfigMap ensures the ConfigMap holding the Corefile of zupd, and returns the Corefile.
+func (r *Reconciler) ensureZupdConfigMap(ctx context.Context, ksdns *dnsv1alpha1.Ksdns) (corefile, error) {
 	log := log.FromContext(ctx)
 	labels := makeLabels("zupd", ksdns)
 	coreFile := &corev1.ConfigMap{
@@ -196,71 +212,141 @@ func (r *Reconciler) ensureZupdConfigMap(ctx context.Context, ksdns *dnsv1alpha1
 			Labels:    labels,
 		},
 	}
-	// see if we can get the pod's ip from coredns
-	coreDNSPods := &corev1.PodList{}
-	if err := r.Client.List(ctx, coreDNSPods, client.InNamespace(ksdns.Namespace), client.MatchingLabels(makeLabels("coredns", ksdns))); err != nil {
-		return err
-	}
-	transferTo := []string{}
-	for _, pod := range coreDNSPods.Items {
-		if pod.Status.PodIP != "" {
-			transferTo = append(transferTo, pod.Status.PodIP)
-			log.Info("found coredns pod", "pod", pod.Name, "ip", pod.Status.PodIP)
-		}
+	// Transfers are restricted to the CoreDNS secondaries
+	transferTo, err := r.getCoreDNSSecondaries(ctx, ksdns)
+	if err != nil {
+		return corefile{}, err
 	}
 	if len(transferTo) == 0 {
-		transferTo = append(transferTo, "169.254.0.1")
+		transferTo = append(transferTo, net.JoinHostPort("169.254.0.1", "1053"))
 		log.Info("no coredns pods found, using default", "ip", "169.254.0.1")
 	}
 	// Get all zones in the current namespace
-	rfc1035v1alpha1Zones := &rfc1035v1alpha1.ZoneList{}
-	if err := r.List(ctx, rfc1035v1alpha1Zones, &client.ListOptions{
-		Namespace: ksdns.Namespace,
-	}); err != nil {
-		return err
-	}
-	zones := []string{}
-	for _, zone := range rfc1035v1alpha1Zones.Items {
-		zones = append(zones, zone.Name)
-	}
-	// Render the corefile
-	corefile, err := renderZupdDNSCorefile(zones, transferTo, ksdns.Namespace, false)
+	zones, err := r.getZones(ctx, ksdns)
 	if err != nil {
-		return err
+		return corefile{}, err
 	}
+	cf := zupdCorefile(zones, transferTo, ksdns.Namespace, security(ksdns), false)
 	// create or update the corefile
-	CreateOrUpdateWithRetries(ctx, r.Client, coreFile, func() error {
+	op, err := CreateOrUpdateWithRetries(ctx, r.Client, coreFile, func() error {
 		coreFile.Data = map[string]string{
-			"Corefile": corefile,
+			"Corefile": cf.String(),
 		}
 		return ctrl.SetControllerReference(ksdns, coreFile, r.Scheme)
 	})
+	if err != nil {
+		return corefile{}, err
+	}
+	log.Info("zupd", "configmap", zupdName(ksdns), "op", op)
 
-	return nil
+	return cf, nil
+}
+
+// getCoreDNSSecondaries gets the addresses, as ip:port, of the CoreDNS pods transferring the zones
+// from zupd.
+func (r *Reconciler) getCoreDNSSecondaries(ctx context.Context, ksdns *dnsv1alpha1.Ksdns) ([]string, error) {
+	pods := &corev1.PodList{}
+	if err := r.List(ctx, pods, client.InNamespace(ksdns.Namespace), client.MatchingLabels(makeSelector("coredns", ksdns))); err != nil {
+		return nil, err
+	}
+	slices := &discoveryv1.EndpointSliceList{}
+	if err := r.List(ctx, slices, client.InNamespace(ksdns.Namespace), client.MatchingLabels{discoveryv1.LabelServiceName: corednsName(ksdns)}); err != nil {
+		return nil, err
+	}
+	return secondaryAddresses(pods.Items, slices.Items), nil
+}
+
+// getZupdNotifiers gets the IPs of the zupd pods, the sources of the NOTIFYs received by CoreDNS.
+func (r *Reconciler) getZupdNotifiers(ctx context.Context, ksdns *dnsv1alpha1.Ksdns) ([]string, error) {
+	pods := &corev1.PodList{}
+	if err := r.List(ctx, pods, client.InNamespace(ksdns.Namespace), client.MatchingLabels(makeSelector("zupd", ksdns))); err != nil {
+		return nil, err
+	}
+	slices := &discoveryv1.EndpointSliceList{}
+	if err := r.List(ctx, slices, client.InNamespace(ksdns.Namespace), client.MatchingLabels{discoveryv1.LabelServiceName: zupdName(ksdns)}); err != nil {
+		return nil, err
+	}
+	return podIPs(pods.Items, slices.Items), nil
+}
+
+// secondaryAddresses returns the sorted addresses, as ip:port, of the CoreDNS pods and of the
+// endpoints of the CoreDNS service, ready or not: a secondary only becomes ready once it
+// transferred the zones. Terminating pods and endpoints are left out.
+func secondaryAddresses(pods []corev1.Pod, slices []discoveryv1.EndpointSlice) []string {
+	ips := podIPs(pods, slices)
+	addresses := make([]string, 0, len(ips))
+	for _, ip := range ips {
+		addresses = append(addresses, net.JoinHostPort(ip, "1053"))
+	}
+	sort.Strings(addresses)
+	return addresses
+}
+
+// podIPs returns the sorted IPs of the running pods and of the ready or serving endpoints of
+// slices.
+func podIPs(pods []corev1.Pod, slices []discoveryv1.EndpointSlice) []string {
+	ips := map[string]bool{}
+	for _, pod := range pods {
+		if pod.Status.PodIP != "" && pod.DeletionTimestamp == nil {
+			ips[pod.Status.PodIP] = true
+		}
+	}
+	for _, slice := range slices {
+		for _, endpoint := range slice.Endpoints {
+			if endpoint.Conditions.Terminating != nil && *endpoint.Conditions.Terminating {
+				continue
+			}
+			for _, ip := range endpoint.Addresses {
+				ips[ip] = true
+			}
+		}
+	}
+	list := make([]string, 0, len(ips))
+	for ip := range ips {
+		list = append(list, ip)
+	}
+	sort.Strings(list)
+	return list
 }
 
-func renderZupdDNSCorefile(zones, transferTo []string, namespace string, debug bool) (string, error) {
-	params := struct {
-		Zones      []string
-		TransferTo []string
-		Namespace  string
-		Debug      bool
-	}{
-		Zones:      zones,
-		TransferTo: transferTo,
-		Debug:      debug,
-		Namespace:  namespace,
-	}
-	var buf bytes.Buffer
-	if err := zupdCorefileTmpl.Execute(&buf, params); err != nil {
-		return "", err
-	}
-	return buf.String(), nil
+// zupdCorefile returns the Corefile of zupd, serving zones from the Zones of namespace and
+// transferring them to transferTo.
+func zupdCorefile(zones, transferTo []string, namespace string, security dnsv1alpha1.Security, debug bool) corefile {
+	require := directive{name: "require", args: []string{"none"}}
+	dynamicUpdate := directive{name: "dynamicupdate", args: []string{namespace}}
+	if security.TSIGRequired() {
+		require.args = []string{"AXFR", "IXFR"}
+		dynamicUpdate.block = []directive{{name: "require_tsig", args: []string{security.TSIGAlgorithm}}}
+	}
+	directives := append(debugDirectives(debug), commonDirectives()...)
+	directives = append(directives,
+		directive{name: "metadata"},
+		directive{name: "tsig", block: []directive{
+			{name: "secrets", args: []string{"/etc/coredns/secret/tsig.conf"}},
+			require,
+		}},
+		dynamicUpdate,
+		directive{name: "transfer", block: []directive{
+			{name: "to", args: transferTo},
+		}},
+	)
+	c := corefile{}
+	c.add(newServerBlock(zones, 1053, directives...))
+	return c
 }
 
-func zupdDeployment(ksdns *dnsv1alpha1.Ksdns) *appsv1.Deployment {
+// zupdDeployment returns the zupd deployment of ksdns. The pods are annotated with configHash,
+// so that they roll when the configuration changes.
+func zupdDeployment(ksdns *dnsv1alpha1.Ksdns, configHash string) *appsv1.Deployment {
 	labels := makeLabels("zupd", ksdns)
-	replicas := int32(2)
+	replicas := ksdns.Spec.Zupd.Replicas
+	if replicas == 0 {
+		replicas = dnsv1alpha1.DefaultReplicas
+	}
+	image := ksdns.Spec.Zupd.Image
+	if image == "" {
+		image = ksdns.Spec.CoreDNS.Image
+	}
 	deployment := &appsv1.Deployment{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      zupdName(ksdns),
@@ -268,19 +354,21 @@ func zupdDeployment(ksdns *dnsv1alpha1.Ksdns) *appsv1.Deployment {
 		},
 		Spec: appsv1.DeploymentSpec{
 			Replicas: &replicas,
+			Strategy: rollingUpdate(),
 			Selector: &metav1.LabelSelector{
 				MatchLabels: makeSelector("zupd", ksdns),
 			},
 			Template: corev1.PodTemplateSpec{
 				ObjectMeta: metav1.ObjectMeta{
-					Labels: labels,
+					Labels:      labels,
+					Annotations: map[string]string{configHashAnnotation: configHash},
 				},
 				Spec: corev1.PodSpec{
 					ServiceAccountName: zupdName(ksdns),
 					Containers: []corev1.Container{
 						{
 							Name:  "zupd",
-							Image: ksdns.Spec.CoreDNS.Image,
+							Image: image,
 							Ports: []corev1.ContainerPort{
 								{
 									ContainerPort: 1053,
@@ -351,5 +439,6 @@ func zupdDeployment(ksdns *dnsv1alpha1.Ksdns) *appsv1.Deployment {
 			},
 		},
 	}
+	applyPodSettings(&deployment.Spec.Template.Spec, ksdns.Spec.Zupd.PodSettings, makeSelector("zupd", ksdns))
 	return deployment
 }
@@ -0,0 +1,179 @@
+package dns
+
+import (
+	"testing"
+
+	"github.com/coredns/caddy/caddyfile"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	dnsv1alpha1 "github.com/cldmnky/ksdns/apis/dns/v1alpha1"
+	rfc1035v1alpha1 "github.com/cldmnky/ksdns/pkg/zupd/api/v1alpha1"
+)
+
+func TestSecondaryAddresses(t *testing.T) {
+	now := metav1.Now()
+	pods := []corev1.Pod{
+		{Status: corev1.PodStatus{PodIP: "10.0.0.2"}},
+		{Status: corev1.PodStatus{PodIP: "10.0.0.1"}},
+		{Status: corev1.PodStatus{}},
+		{ObjectMeta: metav1.ObjectMeta{DeletionTimestamp: &now}, Status: corev1.PodStatus{PodIP: "10.0.0.9"}},
+	}
+	terminating := true
+	slices := []discoveryv1.EndpointSlice{
+		{
+			Endpoints: []discoveryv1.Endpoint{
+				{Addresses: []string{"10.0.0.1"}},
+				{Addresses: []string{"fd00::3"}},
+				{Addresses: []string{"10.0.0.8"}, Conditions: discoveryv1.EndpointConditions{Terminating: &terminating}},
+			},
+		},
+	}
+	assert.Equal(t, []string{"10.0.0.1:1053", "10.0.0.2:1053", "[fd00::3]:1053"}, secondaryAddresses(pods, slices))
+	assert.Empty(t, secondaryAddresses(nil, nil))
+	assert.Equal(t, []string{"10.0.0.1", "10.0.0.2", "fd00::3"}, podIPs(pods, slices))
+}
+
+func TestZupdCorefileTransfer(t *testing.T) {
+	cf := zupdCorefile([]string{"example.org"}, []string{"10.0.0.1:1053", "10.0.0.2:1053"}, "default", security(&dnsv1alpha1.Ksdns{}), false).String()
+	assert.Contains(t, cf, "require AXFR IXFR")
+	assert.Contains(t, cf, "to 10.0.0.1:1053 10.0.0.2:1053\n")
+	assert.NotContains(t, cf, "to *")
+	_, err := caddyfile.ToJSON([]byte(cf))
+	assert.NoError(t, err)
+}
+
+func TestZupdCorefileSecurity(t *testing.T) {
+	requireTSIG := false
+	testCases := []struct {
+		name        string
+		security    dnsv1alpha1.Security
+		contains    []string
+		notContains []string
+	}{
+		{
+			name:     "Default",
+			contains: []string{"require AXFR IXFR", "require_tsig hmac-sha256\n"},
+		},
+		{
+			name:     "hmac-sha512",
+			security: dnsv1alpha1.Security{TSIGAlgorithm: "hmac-sha512"},
+			contains: []string{"require AXFR IXFR", "require_tsig hmac-sha512\n"},
+		},
+		{
+			name:        "Not required",
+			security:    dnsv1alpha1.Security{RequireTSIG: &requireTSIG},
+			contains:    []string{"require none", "dynamicupdate default\n"},
+			notContains: []string{"require AXFR IXFR", "require_tsig"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ksdns := &dnsv1alpha1.Ksdns{Spec: dnsv1alpha1.KsdnsSpec{Security: tc.security}}
+			cf := zupdCorefile([]string{"example.org"}, []string{"10.0.0.1:1053"}, "default", security(ksdns), false).String()
+			for _, s := range tc.contains {
+				assert.Contains(t, cf, s)
+			}
+			for _, s := range tc.notContains {
+				assert.NotContains(t, cf, s)
+			}
+			_, err := caddyfile.ToJSON([]byte(cf))
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestKsdnsRequests(t *testing.T) {
+	ksdns := &dnsv1alpha1.Ksdns{ObjectMeta: metav1.ObjectMeta{Name: "ksdns", Namespace: "dns"}}
+	meta := func(name, app string) metav1.ObjectMeta {
+		return metav1.ObjectMeta{Name: name, Namespace: "dns", Labels: makeLabels(app, ksdns)}
+	}
+	request := []reconcile.Request{{NamespacedName: types.NamespacedName{Name: "ksdns", Namespace: "dns"}}}
+
+	testCases := []struct {
+		name     string
+		obj      client.Object
+		expected []reconcile.Request
+	}{
+		{"CoreDNS pod", &corev1.Pod{ObjectMeta: meta("ksdns-coredns-abc", "coredns")}, request},
+		{"zupd pod", &corev1.Pod{ObjectMeta: meta("ksdns-zupd-abc", "zupd")}, request},
+		{"Unmanaged pod", &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "dns"}}, nil},
+		{"CoreDNS endpoint slice", &discoveryv1.EndpointSlice{ObjectMeta: meta("ksdns-coredns-abc", "ksdns")}, request},
+		{"zupd service", &corev1.Service{ObjectMeta: meta("ksdns-zupd", "zupd")}, request},
+		{"Unmanaged service", &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "dns", Labels: map[string]string{"app.kubernetes.io/instance": "ksdns"}}}, nil},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, ksdnsRequests(tc.obj))
+		})
+	}
+}
+
+func TestCacheSelectors(t *testing.T) {
+	ksdns := &dnsv1alpha1.Ksdns{ObjectMeta: metav1.ObjectMeta{Name: "ksdns", Namespace: "dns"}}
+	selectors := CacheSelectors()
+	assert.Len(t, selectors, 3)
+	for obj, selector := range selectors {
+		assert.True(t, selector.Label.Matches(labels.Set(makeLabels("coredns", ksdns))), "%T", obj)
+		assert.False(t, selector.Label.Matches(labels.Set{"app.kubernetes.io/instance": "ksdns"}), "%T", obj)
+	}
+}
+
+func TestNamespaceRequests(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, dnsv1alpha1.AddToScheme(scheme))
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		&dnsv1alpha1.Ksdns{ObjectMeta: metav1.ObjectMeta{Name: "a", Namespace: "dns"}},
+		&dnsv1alpha1.Ksdns{ObjectMeta: metav1.ObjectMeta{Name: "b", Namespace: "dns"}},
+		&dnsv1alpha1.Ksdns{ObjectMeta: metav1.ObjectMeta{Name: "c", Namespace: "other"}},
+	).Build()
+	r := &Reconciler{Client: c, Scheme: scheme}
+
+	zone := &rfc1035v1alpha1.Zone{ObjectMeta: metav1.ObjectMeta{Name: "example.org", Namespace: "dns"}}
+	assert.ElementsMatch(t, []reconcile.Request{
+		{NamespacedName: types.NamespacedName{Name: "a", Namespace: "dns"}},
+		{NamespacedName: types.NamespacedName{Name: "b", Namespace: "dns"}},
+	}, r.namespaceRequests(zone))
+	zone.Namespace = "empty"
+	assert.Empty(t, r.namespaceRequests(zone))
+}
+
+func TestZupdRoleRules(t *testing.T) {
+	ksdns := &dnsv1alpha1.Ksdns{
+		ObjectMeta: metav1.ObjectMeta{Name: "ksdns", Namespace: "dns"},
+		Spec:       dnsv1alpha1.KsdnsSpec{Zones: []dnsv1alpha1.Zone{{Origin: "example.org"}, {Origin: "example.com."}}},
+	}
+	secrets := func(rules []rbacv1.PolicyRule) []rbacv1.PolicyRule {
+		s := []rbacv1.PolicyRule{}
+		for _, rule := range rules {
+			for _, r := range rule.Resources {
+				if r == "secrets" {
+					s = append(s, rule)
+				}
+			}
+		}
+		return s
+	}
+
+	// Without DNSSEC zupd can not read any Secret
+	assert.Nil(t, dnssecSecretNames(ksdns))
+	assert.Empty(t, secrets(zupdRoleRules(dnssecSecretNames(ksdns))))
+
+	// With DNSSEC only the key Secrets
+	ksdns.Spec.DNSSEC = &dnsv1alpha1.DNSSEC{}
+	names := []string{dnssecName(ksdns, "example.com."), dnssecName(ksdns, "example.org.")}
+	rules := secrets(zupdRoleRules(dnssecSecretNames(ksdns)))
+	require.Len(t, rules, 1)
+	assert.Equal(t, []string{"get"}, rules[0].Verbs)
+	assert.Equal(t, names, rules[0].ResourceNames)
+}
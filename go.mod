@@ -11,6 +11,7 @@ require (
 	github.com/onsi/gomega v1.20.1
 	github.com/spf13/cobra v1.6.1
 	github.com/spf13/viper v1.14.0
+	golang.org/x/time v0.0.0-20220609170525-579cf78fd858
 	k8s.io/apimachinery v0.25.4
 	k8s.io/client-go v0.25.2
 	sigs.k8s.io/controller-runtime v0.13.0
@@ -158,7 +159,6 @@ require (
 	golang.org/x/sys v0.5.0 // indirect
 	golang.org/x/term v0.5.0 // indirect
 	golang.org/x/text v0.7.0 // indirect
-	golang.org/x/time v0.0.0-20220609170525-579cf78fd858 // indirect
 	gomodules.xyz/jsonpatch/v2 v2.2.0 // indirect
 	google.golang.org/appengine v1.6.7 // indirect
 	google.golang.org/protobuf v1.28.1 // indirect
@@ -17,26 +17,157 @@ limitations under the License.
 package v1alpha1
 
 import (
+	"strings"
+	"time"
+
 	"github.com/coredns/coredns/plugin/file"
 	"github.com/miekg/dns"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
+// ZoneMode defines how dynamic updates interact with the records in the spec of a zone.
+type ZoneMode string
+
+const (
+	// ZoneModeOverlay serves dynamic records on top of the static records, updates may add
+	// records to static names.
+	ZoneModeOverlay ZoneMode = "overlay"
+	// ZoneModeProtectStatic refuses updates touching names or RRsets defined in the spec.
+	ZoneModeProtectStatic ZoneMode = "protect-static"
+	// ZoneModeDynamicOnly only serves the apex and the glue of the static zone, all other
+	// records come from dynamic updates.
+	ZoneModeDynamicOnly ZoneMode = "dynamic-only"
+)
+
 // ZoneSpec defines the desired state of Zone
 type ZoneSpec struct {
+	// Zone is the zone in the zone file format. It is managed by the ksdns operator for the
+	// zones of a Ksdns, and rendered again when the Ksdns changes.
 	// +operator-sdk:csv:customresourcedefinitions:type=spec
 	Zone string `json:"zone,omitempty"`
+	// Records are records in the zone file format served in addition to Zone. They are never
+	// changed by the ksdns operator, records can be added here to a zone of a Ksdns.
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	// +optional
+	Records string `json:"records,omitempty"`
+	// Mode defines how dynamic updates interact with the records in Zone.
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	// +kubebuilder:default:="protect-static"
+	// +kubebuilder:validation:Enum=overlay;protect-static;dynamic-only
+	// +optional
+	Mode ZoneMode `json:"mode,omitempty"`
+	// DefaultLease is the lifetime of records added by dynamic updates that do not carry an
+	// EDNS0 UPDATE-LEASE option. Records without a lease never expire.
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	// +optional
+	DefaultLease *metav1.Duration `json:"defaultLease,omitempty"`
+	// Limits for dynamic updates to the zone, these override the limits in the Corefile of zupd.
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	// +optional
+	Limits *ZoneLimits `json:"limits,omitempty"`
+	// DNSSEC enables online signing of the zone.
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	// +optional
+	DNSSEC *ZoneDNSSEC `json:"dnssec,omitempty"`
+}
+
+// ZoneDNSSEC configures the signing of a zone.
+type ZoneDNSSEC struct {
+	// SecretName is the name of the Secret, in the namespace of the Zone, holding the keys. For
+	// each key the Secret holds NAME.key and NAME.private, in the format written by
+	// dnssec-keygen, and an optional NAME.state of active (the default) or published. Keys with
+	// the SEP flag sign the DNSKEY RRset, the other keys sign the zone. Published keys are only
+	// added to the DNSKEY RRset.
+	SecretName string `json:"secretName"`
+	// KeysHash identifies the keys in the Secret. The keys are read again when it changes, so a
+	// change to the keys is picked up without waiting for the zone to be signed again.
+	// +optional
+	KeysHash string `json:"keysHash,omitempty"`
+	// NSEC3 enables NSEC3 (RFC 5155) instead of NSEC for authenticated denial of existence.
+	// +optional
+	NSEC3 *NSEC3 `json:"nsec3,omitempty"`
+}
+
+// NSEC3 configures the hashing of the owner names in the NSEC3 chain of a zone. Opt-out is not
+// supported.
+type NSEC3 struct {
+	// Iterations is the number of additional iterations of the hash, RFC 9276 recommends 0.
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=100
+	// +optional
+	Iterations uint16 `json:"iterations,omitempty"`
+	// Salt is the salt of the hash in hexadecimal, RFC 9276 recommends no salt.
+	// +kubebuilder:validation:Pattern=`^([0-9a-fA-F]{2})*$`
+	// +kubebuilder:validation:MaxLength=510
+	// +optional
+	Salt string `json:"salt,omitempty"`
+}
+
+// Limits restricts dynamic updates, a zero value means no limit.
+type Limits struct {
+	// MaxRecords is the maximum number of dynamic records.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	MaxRecords int32 `json:"maxRecords,omitempty"`
+	// MaxRRsPerMessage is the maximum number of RRs in the update section of a message.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	MaxRRsPerMessage int32 `json:"maxRRsPerMessage,omitempty"`
+	// UpdatesPerSecond is the rate at which updates are accepted.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	UpdatesPerSecond int32 `json:"updatesPerSecond,omitempty"`
+	// Burst is the number of updates accepted above UpdatesPerSecond, defaults to UpdatesPerSecond.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	Burst int32 `json:"burst,omitempty"`
+}
+
+// ZoneLimits restricts dynamic updates to a zone, and the updates signed with a TSIG key.
+type ZoneLimits struct {
+	// Limits for all updates to the zone.
+	Limits `json:",inline"`
+	// Keys holds the limits for updates signed with a TSIG key, MaxRecords applies to the
+	// records added with the key.
+	// +optional
+	Keys []KeyLimits `json:"keys,omitempty"`
+}
+
+// KeyLimits restricts dynamic updates signed with a TSIG key.
+type KeyLimits struct {
+	// Name of the TSIG key.
+	Name   string `json:"name"`
+	Limits `json:",inline"`
 }
 
 func (zs *ZoneSpec) GetZone() string {
 	return zs.Zone
 }
 
+// ZoneFile returns the zone file of the zone: Zone followed by Records.
+func (zs *ZoneSpec) ZoneFile() string {
+	if zs.Records == "" {
+		return zs.Zone
+	}
+	return strings.TrimSuffix(zs.Zone, "\n") + "\n" + zs.Records
+}
+
+// GetMode returns the mode of the zone, protect-static if unset.
+func (zs *ZoneSpec) GetMode() ZoneMode {
+	if zs.Mode == "" {
+		return ZoneModeProtectStatic
+	}
+	return zs.Mode
+}
+
 // ZoneStatus defines the observed state of Zone
 type ZoneStatus struct {
 	// +operator-sdk:csv:customresourcedefinitions:type=status
 	DynamicRRs []DynamicRR `json:"dynamicRRs,omitempty"`
 	Serial     uint32      `json:"serial,omitempty"`
+	// History holds the most recent dynamic updates to the zone, if enabled in zupd.
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	History []ZoneUpdate `json:"history,omitempty"`
 }
 
 func (zs *ZoneStatus) GetDynamicRRs() []DynamicRR {
@@ -76,6 +207,57 @@ func (zs *ZoneStatus) GetDynamicRRsAsZone(name string) *file.Zone {
 
 type DynamicRR struct {
 	RR string `json:"rr,omitempty"`
+	// Owner is the name of the TSIG key that added the record.
+	Owner string `json:"owner,omitempty"`
+	// Expires is the time the lease of the record ends, the record is removed after that.
+	Expires *metav1.Time `json:"expires,omitempty"`
+}
+
+// ZoneUpdate is an audit record of an accepted or rejected dynamic update.
+type ZoneUpdate struct {
+	// Time the update was handled.
+	Time metav1.Time `json:"time"`
+	// Client is the IP address of the client sending the update.
+	Client string `json:"client,omitempty"`
+	// Key is the name of the TSIG key the update was signed with.
+	Key string `json:"key,omitempty"`
+	// Rcode is the response code the update was answered with.
+	Rcode string `json:"rcode"`
+	// Added holds the RRs added by the update.
+	Added []string `json:"added,omitempty"`
+	// Removed holds the RRs, RRsets or names removed by the update.
+	Removed []string `json:"removed,omitempty"`
+	// Serial is the serial of the zone after the update was applied.
+	Serial uint32 `json:"serial,omitempty"`
+}
+
+// AddHistory appends u to the history, keeping at most max entries.
+func (zs *ZoneStatus) AddHistory(u ZoneUpdate, max int) {
+	if max <= 0 {
+		return
+	}
+	zs.History = append(zs.History, u)
+	if len(zs.History) > max {
+		zs.History = zs.History[len(zs.History)-max:]
+	}
+}
+
+// SerialLess reports whether serial a is less than b in serial number arithmetic (RFC 1982).
+func SerialLess(a, b uint32) bool {
+	return int32(b-a) > 0
+}
+
+// NextSerial returns the serial of a zone changed at now, after serials: the time in seconds, or
+// the successor of the greatest of serials if that is not less than the time. The serials of the
+// static and dynamic changes of a zone share this clock.
+func NextSerial(now time.Time, serials ...uint32) uint32 {
+	next := uint32(now.Unix())
+	for _, s := range serials {
+		if s != 0 && !SerialLess(s, next) {
+			next = s + 1
+		}
+	}
+	return next
 }
 
 //+kubebuilder:object:root=true
@@ -22,12 +22,17 @@ limitations under the License.
 package v1alpha1
 
 import (
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *DynamicRR) DeepCopyInto(out *DynamicRR) {
 	*out = *in
+	if in.Expires != nil {
+		in, out := &in.Expires, &out.Expires
+		*out = (*in).DeepCopy()
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DynamicRR.
@@ -40,12 +45,58 @@ func (in *DynamicRR) DeepCopy() *DynamicRR {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KeyLimits) DeepCopyInto(out *KeyLimits) {
+	*out = *in
+	out.Limits = in.Limits
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KeyLimits.
+func (in *KeyLimits) DeepCopy() *KeyLimits {
+	if in == nil {
+		return nil
+	}
+	out := new(KeyLimits)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Limits) DeepCopyInto(out *Limits) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Limits.
+func (in *Limits) DeepCopy() *Limits {
+	if in == nil {
+		return nil
+	}
+	out := new(Limits)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NSEC3) DeepCopyInto(out *NSEC3) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NSEC3.
+func (in *NSEC3) DeepCopy() *NSEC3 {
+	if in == nil {
+		return nil
+	}
+	out := new(NSEC3)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Zone) DeepCopyInto(out *Zone) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
-	out.Spec = in.Spec
+	in.Spec.DeepCopyInto(&out.Spec)
 	in.Status.DeepCopyInto(&out.Status)
 }
 
@@ -67,6 +118,47 @@ func (in *Zone) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ZoneDNSSEC) DeepCopyInto(out *ZoneDNSSEC) {
+	*out = *in
+	if in.NSEC3 != nil {
+		in, out := &in.NSEC3, &out.NSEC3
+		*out = new(NSEC3)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ZoneDNSSEC.
+func (in *ZoneDNSSEC) DeepCopy() *ZoneDNSSEC {
+	if in == nil {
+		return nil
+	}
+	out := new(ZoneDNSSEC)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ZoneLimits) DeepCopyInto(out *ZoneLimits) {
+	*out = *in
+	out.Limits = in.Limits
+	if in.Keys != nil {
+		in, out := &in.Keys, &out.Keys
+		*out = make([]KeyLimits, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ZoneLimits.
+func (in *ZoneLimits) DeepCopy() *ZoneLimits {
+	if in == nil {
+		return nil
+	}
+	out := new(ZoneLimits)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ZoneList) DeepCopyInto(out *ZoneList) {
 	*out = *in
@@ -102,6 +194,21 @@ func (in *ZoneList) DeepCopyObject() runtime.Object {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ZoneSpec) DeepCopyInto(out *ZoneSpec) {
 	*out = *in
+	if in.DefaultLease != nil {
+		in, out := &in.DefaultLease, &out.DefaultLease
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.Limits != nil {
+		in, out := &in.Limits, &out.Limits
+		*out = new(ZoneLimits)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.DNSSEC != nil {
+		in, out := &in.DNSSEC, &out.DNSSEC
+		*out = new(ZoneDNSSEC)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ZoneSpec.
@@ -120,7 +227,16 @@ func (in *ZoneStatus) DeepCopyInto(out *ZoneStatus) {
 	if in.DynamicRRs != nil {
 		in, out := &in.DynamicRRs, &out.DynamicRRs
 		*out = make([]DynamicRR, len(*in))
-		copy(*out, *in)
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.History != nil {
+		in, out := &in.History, &out.History
+		*out = make([]ZoneUpdate, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
 	}
 }
 
@@ -133,3 +249,29 @@ func (in *ZoneStatus) DeepCopy() *ZoneStatus {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ZoneUpdate) DeepCopyInto(out *ZoneUpdate) {
+	*out = *in
+	in.Time.DeepCopyInto(&out.Time)
+	if in.Added != nil {
+		in, out := &in.Added, &out.Added
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Removed != nil {
+		in, out := &in.Removed, &out.Removed
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ZoneUpdate.
+func (in *ZoneUpdate) DeepCopy() *ZoneUpdate {
+	if in == nil {
+		return nil
+	}
+	out := new(ZoneUpdate)
+	in.DeepCopyInto(out)
+	return out
+}
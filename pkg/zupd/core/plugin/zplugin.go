@@ -58,5 +58,6 @@ import (
 
 	// Include all plugins that are not imported by the above.
 	_ "github.com/cldmnky/ksdns/pkg/zupd/plugin/dynamicupdate"
+	_ "github.com/cldmnky/ksdns/pkg/zupd/plugin/secondarytsig"
 	_ "github.com/coredns/kubeapi"
 )
@@ -0,0 +1,77 @@
+package dynamicupdate
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/coredns/coredns/request"
+	"github.com/miekg/dns"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	rfc1035v1alpha1 "github.com/cldmnky/ksdns/pkg/zupd/api/v1alpha1"
+)
+
+const (
+	reasonUpdateAccepted = "UpdateAccepted"
+	reasonUpdateRejected = "UpdateRejected"
+)
+
+// auditEntry is the structured log line written for every dynamic update.
+type auditEntry struct {
+	Zone string `json:"zone"`
+	rfc1035v1alpha1.ZoneUpdate
+}
+
+// newZoneUpdate returns the audit record for the update in state, the rcode and serial are
+// filled in once the update has been handled.
+func newZoneUpdate(state request.Request, key string) rfc1035v1alpha1.ZoneUpdate {
+	u := rfc1035v1alpha1.ZoneUpdate{
+		Time:   metav1.Now(),
+		Client: state.IP(),
+		Key:    key,
+	}
+	for _, rr := range state.Req.Ns {
+		if updateType(rr.Header()) == "insert" {
+			u.Added = append(u.Added, rr.String())
+		} else {
+			u.Removed = append(u.Removed, rr.String())
+		}
+	}
+	return u
+}
+
+// audit writes the audit trail of an update to zone: a structured log line, an event on the
+// Zone object and an entry in the history of the zone. Accepted updates are added to the history
+// when the dynamic RRs are written, rejected updates with the next write, so rejected updates do
+// not cause writes. zoneObj may be nil, in which case the Zone object is looked up in the cache.
+func (d *DynamicUpdate) audit(ctx context.Context, zone string, zoneObj *rfc1035v1alpha1.Zone, u rfc1035v1alpha1.ZoneUpdate) {
+	if b, err := json.Marshal(auditEntry{Zone: zone, ZoneUpdate: u}); err != nil {
+		log.Errorf("Failed to marshal audit record: %s", err)
+	} else {
+		log.Infof("%s", b)
+	}
+
+	accepted := u.Rcode == dns.RcodeToString[dns.RcodeSuccess]
+	if !accepted && d.history > 0 {
+		d.committers.get(d, zone).reject(u)
+	}
+
+	if d.recorder == nil {
+		return
+	}
+	if zoneObj == nil {
+		var err error
+		if zoneObj, err = d.cachedZone(ctx, zone); err != nil {
+			log.Debugf("Not recording audit event for %s: %s", zone, err)
+			return
+		}
+	}
+	if accepted {
+		d.recorder.Eventf(zoneObj, corev1.EventTypeNormal, reasonUpdateAccepted,
+			"Update from %s (key %q) accepted: %d added, %d removed, serial %d", u.Client, u.Key, len(u.Added), len(u.Removed), u.Serial)
+	} else {
+		d.recorder.Eventf(zoneObj, corev1.EventTypeWarning, reasonUpdateRejected,
+			"Update from %s (key %q) rejected: %s", u.Client, u.Key, u.Rcode)
+	}
+}
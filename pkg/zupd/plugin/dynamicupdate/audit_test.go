@@ -0,0 +1,85 @@
+package dynamicupdate
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	golog "log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestAuditLog(t *testing.T) {
+	var buf bytes.Buffer
+	golog.SetOutput(&buf)
+	defer golog.SetOutput(os.Stderr)
+
+	d := newTestUpdate(t, 0, newTestZoneObject())
+	a, err := dns.NewRR("a.example.org. 60 IN A 10.0.0.1")
+	require.NoError(t, err)
+	require.Equal(t, dns.RcodeSuccess, update(t, d, []dns.RR{a}, nil))
+
+	var entry auditEntry
+	for _, line := range strings.Split(buf.String(), "\n") {
+		if i := strings.Index(line, "{"); i >= 0 && strings.Contains(line, "plugin/dynamicupdate") {
+			require.NoError(t, json.Unmarshal([]byte(line[i:]), &entry))
+		}
+	}
+	assert.Equal(t, exampleOrgZone, entry.Zone)
+	assert.Equal(t, "NOERROR", entry.Rcode)
+	assert.Equal(t, []string{a.String()}, entry.Added)
+	assert.NotZero(t, entry.Serial)
+}
+
+func TestAuditEvents(t *testing.T) {
+	d := newTestUpdate(t, 0, newTestZoneObject())
+	recorder := record.NewFakeRecorder(10)
+	d.recorder = recorder
+	a, err := dns.NewRR("a.example.org. 60 IN A 10.0.0.1")
+	require.NoError(t, err)
+	ns, err := dns.NewRR("a.example.org. 60 IN NS ns1.example.org.")
+	require.NoError(t, err)
+
+	require.Equal(t, dns.RcodeSuccess, update(t, d, []dns.RR{a}, nil))
+	assert.True(t, strings.HasPrefix(<-recorder.Events, "Normal "+reasonUpdateAccepted+" "))
+	require.Equal(t, dns.RcodeRefused, update(t, d, []dns.RR{ns}, nil))
+	assert.True(t, strings.HasPrefix(<-recorder.Events, "Warning "+reasonUpdateRejected+" "))
+}
+
+func TestAuditHistory(t *testing.T) {
+	d := newTestUpdate(t, 0, newTestZoneObject())
+	d.history = 3
+	ns, err := dns.NewRR("a.example.org. 60 IN NS ns1.example.org.")
+	require.NoError(t, err)
+	a, err := dns.NewRR("a.example.org. 60 IN A 10.0.0.1")
+	require.NoError(t, err)
+
+	// Rejected updates are not written by themselves
+	before, err := d.getZone(context.Background(), exampleOrgZone)
+	require.NoError(t, err)
+	for i := 0; i < 5; i++ {
+		require.Equal(t, dns.RcodeRefused, update(t, d, []dns.RR{ns}, nil))
+		time.Sleep(time.Millisecond)
+	}
+	after, err := d.getZone(context.Background(), exampleOrgZone)
+	require.NoError(t, err)
+	assert.Equal(t, before.ResourceVersion, after.ResourceVersion)
+
+	// They are written with the next accepted update, keeping the last entries
+	require.Equal(t, dns.RcodeSuccess, update(t, d, []dns.RR{a}, nil))
+	written, err := d.getZone(context.Background(), exampleOrgZone)
+	require.NoError(t, err)
+	require.Len(t, written.Status.History, 3)
+	for i, rcode := range []string{"REFUSED", "REFUSED", "NOERROR"} {
+		assert.Equal(t, rcode, written.Status.History[i].Rcode)
+	}
+	assert.Equal(t, []string{a.String()}, written.Status.History[2].Added)
+	assert.Empty(t, d.committers.get(d, exampleOrgZone).rejected)
+}
@@ -0,0 +1,305 @@
+package dynamicupdate
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/coredns/coredns/plugin/file"
+	"github.com/miekg/dns"
+	"k8s.io/client-go/util/retry"
+
+	rfc1035v1alpha1 "github.com/cldmnky/ksdns/pkg/zupd/api/v1alpha1"
+)
+
+const (
+	// defaultCommitWindow is the time updates are collected before they are written, in batch mode.
+	defaultCommitWindow = 50 * time.Millisecond
+	// writeTimeout bounds a write of the dynamic RRs of a zone to the API server.
+	writeTimeout = 10 * time.Second
+)
+
+// committers holds the committer of each zone.
+type committers struct {
+	sync.Mutex
+	m map[string]*committer
+}
+
+// get returns the committer of zone, creating it if needed.
+func (c *committers) get(d *DynamicUpdate, zone string) *committer {
+	c.Lock()
+	defer c.Unlock()
+	if c.m == nil {
+		c.m = make(map[string]*committer)
+	}
+	cm, ok := c.m[zone]
+	if !ok {
+		cm = &committer{d: d, zone: zone, window: d.commitWindow}
+		c.m[zone] = cm
+	}
+	return cm
+}
+
+// commitRequest is a request to write the dynamic RRs of a zone to the API server.
+type commitRequest struct {
+	// record is the audit record of the update, nil if not written by an update.
+	record *rfc1035v1alpha1.ZoneUpdate
+	done   chan commitResult
+}
+
+type commitResult struct {
+	zoneObj *rfc1035v1alpha1.Zone
+	err     error
+}
+
+// wait returns the written Zone object once a write including the changes of the request
+// completed.
+func (r commitRequest) wait() (*rfc1035v1alpha1.Zone, error) {
+	res := <-r.done
+	return res.zoneObj, res.err
+}
+
+// errDiscarded is returned to the requests made on top of changes that failed to be written.
+var errDiscarded = errors.New("preceding changes failed to be written")
+
+// dynamicState holds the dynamic RRs of a zone with their leases.
+type dynamicState struct {
+	zone   *file.Zone
+	leases map[string]lease
+	// generation is the generation of the committer the state was based on.
+	generation uint64
+}
+
+// copyState returns a copy of the dynamic RRs of dz and of their leases, which can be changed
+// without affecting dz. The caller must hold a lock on dz.
+func copyState(origin string, dz *file.Zone, leases map[string]lease) dynamicState {
+	s := dynamicState{zone: file.NewZone(origin, ""), leases: make(map[string]lease, len(leases))}
+	rrs := append([]dns.RR{}, dz.Apex.NS...)
+	for _, e := range dz.All() {
+		rrs = append(rrs, e.All()...)
+	}
+	for _, rr := range rrs {
+		if err := s.zone.Insert(dns.Copy(rr)); err != nil {
+			log.Errorf("Failed to insert RR %s: %s", rr, err)
+		}
+	}
+	for k, l := range leases {
+		s.leases[k] = l
+	}
+	return s
+}
+
+// expire removes the RRs whose lease expired before now, and returns the number of RRs removed.
+// Every write drops the expired RRs, so a replica does not write back the RRs removed by the
+// leader.
+func (s dynamicState) expire(now time.Time) int {
+	expired := 0
+	for _, el := range s.zone.All() {
+		for _, rr := range el.All() {
+			if s.leases[rr.String()].expired(now) {
+				log.Infof("Removing expired %s", rr.String())
+				delete(s.leases, rr.String())
+				removeRR(s.zone, rr)
+				expired++
+			}
+		}
+	}
+	return expired
+}
+
+// committer coalesces the writes of the dynamic RRs of a zone to the API server. Requests
+// arriving within the commit window, or while a write is in progress, are written together in
+// the next write. A request returns once a write including its changes completed, so an update is
+// durable before it is acknowledged. A window of zero writes as soon as possible, which is the
+// sync mode.
+//
+// Changes are made on a copy of the dynamic RRs, which is served once it is written: a failed
+// write leaves nothing behind, and is not written later by another request.
+type committer struct {
+	d      *DynamicUpdate
+	zone   string
+	window time.Duration
+
+	mu        sync.Mutex
+	queue     []commitRequest
+	scheduled bool
+	// pending holds the changes of the queued requests, and inflight the changes being written.
+	pending, inflight *dynamicState
+	// rejected holds the records of the rejected updates, which are added to the history with
+	// the next write.
+	rejected []rfc1035v1alpha1.ZoneUpdate
+	// generation is incremented when changes are discarded, the states based on them before are
+	// not enqueued.
+	generation uint64
+	// writing serializes the writes of the zone.
+	writing sync.Mutex
+}
+
+// base returns a copy of the latest dynamic RRs of the zone, including the changes not written
+// yet, to make a change on. The caller must hold the lock of dz, the dynamic zone, until the
+// change is enqueued or dropped.
+func (c *committer) base(dz *file.Zone, leases map[string]lease) dynamicState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var s dynamicState
+	switch {
+	case c.pending != nil:
+		s = copyState(c.zone, c.pending.zone, c.pending.leases)
+	case c.inflight != nil:
+		s = copyState(c.zone, c.inflight.zone, c.inflight.leases)
+	default:
+		s = copyState(c.zone, dz, leases)
+	}
+	s.generation = c.generation
+	return s
+}
+
+// enqueue queues the write of state, the dynamic RRs with the changes of record. record is nil
+// for changes not made by an update. The caller must hold the lock of the dynamic zone it held
+// for base. A state based on changes discarded since base is not queued, its request fails
+// with errDiscarded.
+func (c *committer) enqueue(record *rfc1035v1alpha1.ZoneUpdate, state dynamicState) commitRequest {
+	req := commitRequest{record: record, done: make(chan commitResult, 1)}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if state.generation != c.generation {
+		req.done <- commitResult{err: errDiscarded}
+		return req
+	}
+	c.pending = &state
+	c.queue = append(c.queue, req)
+	if !c.scheduled {
+		c.scheduled = true
+		time.AfterFunc(c.window, c.flush)
+	}
+	return req
+}
+
+// reject keeps the record of a rejected update until the next write, keeping at most as many
+// records as the history.
+func (c *committer) reject(record rfc1035v1alpha1.ZoneUpdate) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rejected = append(c.rejected, record)
+	if len(c.rejected) > c.d.history {
+		c.rejected = c.rejected[len(c.rejected)-c.d.history:]
+	}
+}
+
+// flush writes the queued requests in a single write, and serves the written dynamic RRs.
+func (c *committer) flush() {
+	c.writing.Lock()
+	defer c.writing.Unlock()
+	c.mu.Lock()
+	queue, state := c.queue, c.pending
+	c.queue, c.pending, c.inflight = nil, nil, state
+	c.scheduled = false
+	c.mu.Unlock()
+	if len(queue) == 0 {
+		return
+	}
+	c.mu.Lock()
+	rejected := c.rejected
+	c.rejected = nil
+	c.mu.Unlock()
+
+	records := []*rfc1035v1alpha1.ZoneUpdate{}
+	for _, req := range queue {
+		if req.record != nil {
+			records = append(records, req.record)
+		}
+	}
+	if len(queue) > 1 {
+		log.Debugf("Writing %d coalesced commits for %s", len(queue), c.zone)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), writeTimeout)
+	defer cancel()
+	// The serial follows the served one, which is also bumped by the changes of the spec
+	serial := rfc1035v1alpha1.NextSerial(time.Now(), c.d.Zones.serial(c.zone))
+	var zoneObj *rfc1035v1alpha1.Zone
+	signed, err := c.d.signState(ctx, c.zone, *state, serial)
+	if err == nil {
+		zoneObj, err = c.d.write(ctx, c.zone, *state, serial, records, rejected)
+	}
+	if err == nil {
+		err = c.apply(ctx, *state, zoneObj, signed)
+	} else {
+		c.discard()
+		for _, record := range rejected {
+			c.reject(record)
+		}
+	}
+	for _, req := range queue {
+		req.done <- commitResult{zoneObj: zoneObj, err: err}
+	}
+}
+
+// apply serves state, the written dynamic RRs of zoneObj: it replaces the dynamic zone, sets the
+// SOA serial, serves the signed snapshot of the zone, if it is signed, and notifies the
+// secondaries.
+func (c *committer) apply(ctx context.Context, state dynamicState, zoneObj *rfc1035v1alpha1.Zone, signed *signedState) error {
+	dz, leases := c.d.Zones.dynamic(c.zone)
+	if dz == nil {
+		c.discard()
+		return errZoneNotFound
+	}
+	dz.Lock()
+	dz.Tree, dz.Apex = state.zone.Tree, state.zone.Apex
+	if leases != nil {
+		for k := range leases {
+			delete(leases, k)
+		}
+		for k, l := range state.leases {
+			leases[k] = l
+		}
+	}
+	c.mu.Lock()
+	c.inflight = nil
+	c.mu.Unlock()
+	dz.Unlock()
+
+	return c.d.publish(c.zone, zoneObj, signed)
+}
+
+// discard drops the changes of a failed write, and the queued changes made on top of them. The
+// changes made on top of them and not queued yet are dropped by enqueue.
+func (c *committer) discard() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	c.inflight = nil
+	if c.pending == nil {
+		return
+	}
+	for _, req := range c.queue {
+		req.done <- commitResult{err: errDiscarded}
+	}
+	c.queue, c.pending = nil, nil
+}
+
+// write adds records, and the rejected records, to the history of the Zone object of zone and
+// writes the dynamic RRs of state with serial. The Zone object is read again when the write
+// conflicts.
+func (d *DynamicUpdate) write(ctx context.Context, zone string, state dynamicState, serial uint32, records []*rfc1035v1alpha1.ZoneUpdate, rejected []rfc1035v1alpha1.ZoneUpdate) (*rfc1035v1alpha1.Zone, error) {
+	history := append([]rfc1035v1alpha1.ZoneUpdate{}, rejected...)
+	for _, record := range records {
+		record.Serial = serial
+		record.Rcode = dns.RcodeToString[dns.RcodeSuccess]
+		history = append(history, *record)
+	}
+	sort.SliceStable(history, func(i, j int) bool { return history[i].Time.Before(&history[j].Time) })
+	var zoneObj *rfc1035v1alpha1.Zone
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		var err error
+		if zoneObj, err = d.getZone(ctx, zone); err != nil {
+			return err
+		}
+		for _, record := range history {
+			zoneObj.Status.AddHistory(record, d.history)
+		}
+		return d.persist(ctx, zone, state, zoneObj, serial)
+	})
+	return zoneObj, err
+}
@@ -0,0 +1,268 @@
+package dynamicupdate
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coredns/coredns/plugin/file"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/coredns/coredns/request"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	rfc1035v1alpha1 "github.com/cldmnky/ksdns/pkg/zupd/api/v1alpha1"
+)
+
+// newTestUpdate returns a plugin serving example.org, with the objects in its client.
+func newTestUpdate(t *testing.T, window time.Duration, objs ...client.Object) *DynamicUpdate {
+	t.Helper()
+	zone, err := file.Parse(strings.NewReader(exampleOrg), exampleOrgZone, "stdin", 0)
+	require.NoError(t, err)
+	return &DynamicUpdate{
+		Namespaces:   []string{"default"},
+		K8sClient:    fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).Build(),
+		history:      10,
+		commitWindow: window,
+		committers:   &committers{},
+		limiters:     &limiters{},
+		Zones: &Zones{
+			Z:            map[string]*file.Zone{exampleOrgZone: zone},
+			Names:        []string{exampleOrgZone},
+			DynamicZones: map[string]*file.Zone{exampleOrgZone: file.NewZone(exampleOrgZone, "")},
+			Specs:        map[string]rfc1035v1alpha1.ZoneSpec{exampleOrgZone: {Zone: exampleOrg}},
+			leases:       map[string]map[string]lease{exampleOrgZone: {}},
+		},
+	}
+}
+
+func newTestZoneObject() *rfc1035v1alpha1.Zone {
+	return &rfc1035v1alpha1.Zone{
+		ObjectMeta: metav1.ObjectMeta{Name: "example.org", Namespace: "default"},
+		Spec:       rfc1035v1alpha1.ZoneSpec{Zone: exampleOrg},
+	}
+}
+
+// update sends an update inserting and removing rrs to d, and returns the rcode.
+func update(t *testing.T, d *DynamicUpdate, insert, remove []dns.RR) int {
+	t.Helper()
+	m := new(dns.Msg)
+	m.SetUpdate(exampleOrgZone)
+	m.Insert(insert)
+	m.Remove(remove)
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	state := request.Request{W: rec, Req: m}
+	rcode, err := d.serveUpdate(context.Background(), state, exampleOrgZone, d.Zones.DynamicZones[exampleOrgZone])
+	require.NoError(t, err)
+	return rcode
+}
+
+func TestCommitBatch(t *testing.T) {
+	d := newTestUpdate(t, 100*time.Millisecond, newTestZoneObject())
+	dz := d.Zones.DynamicZones[exampleOrgZone]
+	c := d.committers.get(d, exampleOrgZone)
+
+	const updates = 5
+	records := make([]rfc1035v1alpha1.ZoneUpdate, updates)
+	var wg sync.WaitGroup
+	for i := 0; i < updates; i++ {
+		rr, err := dns.NewRR("new" + string(rune('a'+i)) + ".example.org. 60 IN A 10.0.0.1")
+		require.NoError(t, err)
+		dz.Lock()
+		next := c.base(dz, d.Zones.leases[exampleOrgZone])
+		require.NoError(t, next.zone.Insert(rr))
+		req := c.enqueue(&records[i], next)
+		dz.Unlock()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := req.wait()
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	// All updates are written with the same serial, in a single write
+	for _, record := range records {
+		assert.Equal(t, records[0].Serial, record.Serial)
+	}
+	written, err := d.getZone(context.Background(), exampleOrgZone)
+	require.NoError(t, err)
+	assert.Len(t, written.Status.DynamicRRs, updates)
+	assert.Len(t, written.Status.History, updates)
+	assert.Equal(t, records[0].Serial, written.Status.Serial)
+	assert.Len(t, dz.All(), updates)
+}
+
+func TestCommitFailure(t *testing.T) {
+	// Without a Zone object the write fails
+	d := newTestUpdate(t, 0)
+	dz := d.Zones.DynamicZones[exampleOrgZone]
+	a, err := dns.NewRR("a.example.org. 60 IN A 10.0.0.1")
+	require.NoError(t, err)
+	b, err := dns.NewRR("b.example.org. 60 IN A 10.0.0.2")
+	require.NoError(t, err)
+
+	assert.Equal(t, dns.RcodeRefused, update(t, d, []dns.RR{a}, nil))
+	assert.Empty(t, dz.All(), "a failed update is not served")
+	assert.Empty(t, d.Zones.leases[exampleOrgZone])
+
+	// The next update does not write the changes of the failed update
+	require.NoError(t, d.K8sClient.Create(context.Background(), newTestZoneObject()))
+	assert.Equal(t, dns.RcodeSuccess, update(t, d, []dns.RR{b}, nil))
+	written, err := d.getZone(context.Background(), exampleOrgZone)
+	require.NoError(t, err)
+	require.Len(t, written.Status.DynamicRRs, 1)
+	assert.Equal(t, b.String(), written.Status.DynamicRRs[0].RR)
+	assert.Len(t, dz.All(), 1)
+	assert.Contains(t, d.Zones.leases[exampleOrgZone], b.String())
+}
+
+func TestCommitFailureBeforeEnqueue(t *testing.T) {
+	// Without a Zone object the write fails
+	d := newTestUpdate(t, 50*time.Millisecond)
+	dz, leases := d.Zones.dynamic(exampleOrgZone)
+	c := d.committers.get(d, exampleOrgZone)
+	a, err := dns.NewRR("a.example.org. 60 IN A 10.0.0.1")
+	require.NoError(t, err)
+	b, err := dns.NewRR("b.example.org. 60 IN A 10.0.0.2")
+	require.NoError(t, err)
+
+	dz.Lock()
+	first := c.base(dz, leases)
+	require.NoError(t, first.zone.Insert(a))
+	failed := c.enqueue(nil, first)
+	dz.Unlock()
+
+	// An update bases its change on the queued change, whose write fails before it is enqueued
+	dz.Lock()
+	next := c.base(dz, leases)
+	require.NoError(t, next.zone.Insert(b))
+	_, err = failed.wait()
+	require.ErrorIs(t, err, errZoneNotFound)
+	req := c.enqueue(nil, next)
+	dz.Unlock()
+	_, err = req.wait()
+	assert.ErrorIs(t, err, errDiscarded)
+
+	// The changes of the failed write are not written by the next update
+	require.NoError(t, d.K8sClient.Create(context.Background(), newTestZoneObject()))
+	assert.Equal(t, dns.RcodeSuccess, update(t, d, []dns.RR{b}, nil))
+	written, err := d.getZone(context.Background(), exampleOrgZone)
+	require.NoError(t, err)
+	require.Len(t, written.Status.DynamicRRs, 1)
+	assert.Equal(t, b.String(), written.Status.DynamicRRs[0].RR)
+	assert.Empty(t, rrset(dz, exampleOrgZone, "a.example.org.", dns.TypeA))
+}
+
+func TestUpdateNotImplemented(t *testing.T) {
+	d := newTestUpdate(t, 0, newTestZoneObject())
+	dz := d.Zones.DynamicZones[exampleOrgZone]
+	a, err := dns.NewRR("a.example.org. 60 IN A 10.0.0.1")
+	require.NoError(t, err)
+
+	// An insertion followed by an unsupported RRset removal changes nothing
+	m := new(dns.Msg)
+	m.SetUpdate(exampleOrgZone)
+	m.Insert([]dns.RR{a})
+	m.RemoveRRset([]dns.RR{a})
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	rcode, err := d.serveUpdate(context.Background(), request.Request{W: rec, Req: m}, exampleOrgZone, dz)
+	require.NoError(t, err)
+	assert.Equal(t, dns.RcodeNotImplemented, rcode)
+	assert.Empty(t, dz.All())
+	written, err := d.getZone(context.Background(), exampleOrgZone)
+	require.NoError(t, err)
+	assert.Empty(t, written.Status.DynamicRRs)
+}
+
+func TestSerialAfterContentChange(t *testing.T) {
+	// The serial of an earlier dynamic update, in truncated milliseconds
+	zoneObj := newTestZoneObject()
+	zoneObj.Status.Serial = 4000000000
+	d := newTestUpdate(t, 0, zoneObj)
+	d.Client = d.K8sClient
+	ctx := context.Background()
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Name: "example.org", Namespace: "default"}}
+	served := func() uint32 { return d.Zones.serial(exampleOrgZone) }
+	change := func(serial uint32) {
+		t.Helper()
+		z := &rfc1035v1alpha1.Zone{}
+		require.NoError(t, d.K8sClient.Get(ctx, req.NamespacedName, z))
+		z.Spec.Zone = strings.Replace(exampleOrg, "20160727", fmt.Sprint(serial), 1)
+		require.NoError(t, d.K8sClient.Update(ctx, z))
+		_, err := d.Reconcile(ctx, req)
+		require.NoError(t, err)
+	}
+
+	// A content change in seconds follows the wrapped serial
+	now := uint32(time.Now().Unix())
+	change(now)
+	assert.Equal(t, now, served())
+
+	// A dynamic update follows the content change
+	rr, err := dns.NewRR("new.example.org. 60 IN A 10.0.0.1")
+	require.NoError(t, err)
+	require.Equal(t, dns.RcodeSuccess, update(t, d, []dns.RR{rr}, nil))
+	written := &rfc1035v1alpha1.Zone{}
+	require.NoError(t, d.K8sClient.Get(ctx, req.NamespacedName, written))
+	assert.True(t, rfc1035v1alpha1.SerialLess(now, written.Status.Serial))
+	assert.Equal(t, written.Status.Serial, served())
+
+	// A content change with a serial behind the dynamic update still raises the served serial
+	change(now)
+	assert.Equal(t, written.Status.Serial+1, served())
+	require.Equal(t, dns.RcodeSuccess, update(t, d, nil, []dns.RR{rr}))
+	assert.Equal(t, written.Status.Serial+2, served())
+}
+
+func TestUpdateDuringReconcile(t *testing.T) {
+	d := newTestUpdate(t, 0, newTestZoneObject())
+	d.Client = d.K8sClient
+	ctx := context.Background()
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Name: "example.org", Namespace: "default"}}
+
+	// The Zone is reconciled while updates and queries are served, run with -race
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 20; i++ {
+			// The writes of the updates conflict with the changes of the spec
+			err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+				z := &rfc1035v1alpha1.Zone{}
+				if err := d.K8sClient.Get(ctx, req.NamespacedName, z); err != nil {
+					return err
+				}
+				z.Spec.DefaultLease = &metav1.Duration{Duration: time.Duration(i+1) * time.Minute}
+				z.Spec.Limits = &rfc1035v1alpha1.ZoneLimits{Limits: rfc1035v1alpha1.Limits{MaxRecords: 100}}
+				return d.K8sClient.Update(ctx, z)
+			})
+			require.NoError(t, err)
+			_, err = d.Reconcile(ctx, req)
+			require.NoError(t, err)
+		}
+	}()
+	for i := 0; i < 20; i++ {
+		rr, err := dns.NewRR(fmt.Sprintf("r%d.example.org. 60 IN A 10.0.0.1", i))
+		require.NoError(t, err)
+		assert.Equal(t, dns.RcodeSuccess, update(t, d, []dns.RR{rr}, nil))
+		m := new(dns.Msg)
+		m.SetQuestion(rr.Header().Name, dns.TypeA)
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		_, err = d.ServeDNS(ctx, rec, m)
+		require.NoError(t, err)
+	}
+	<-done
+}
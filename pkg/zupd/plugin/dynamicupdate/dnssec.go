@@ -0,0 +1,482 @@
+package dynamicupdate
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coredns/coredns/plugin/file"
+	"github.com/miekg/dns"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	rfc1035v1alpha1 "github.com/cldmnky/ksdns/pkg/zupd/api/v1alpha1"
+)
+
+const (
+	// signatureValidity is the validity of the RRSIGs.
+	signatureValidity = 14 * 24 * time.Hour
+	// resignInterval is the interval at which the zones are signed again, to refresh the RRSIGs
+	// and pick up changes to the keys.
+	resignInterval = signatureValidity / 4
+	// inceptionOffset allows for clock skew between the signer and the validators.
+	inceptionOffset = time.Hour
+)
+
+// Key states, in the NAME.state field of the Secret holding the keys.
+const (
+	keyStateActive    = "active"
+	keyStatePublished = "published"
+)
+
+// signingKey is a DNSSEC key of a zone.
+type signingKey struct {
+	dnskey *dns.DNSKEY
+	signer crypto.Signer
+	// active keys sign the zone, published keys are only added to the DNSKEY RRset.
+	active bool
+}
+
+func (k signingKey) ksk() bool { return k.dnskey.Flags&dns.SEP != 0 }
+
+// signedState is a signed snapshot of a zone, with the keys it was signed with.
+type signedState struct {
+	zone *file.Zone
+	// nsec3 is the NSEC3 chain of the zone, nil if it is signed with NSEC.
+	nsec3 *nsec3Chain
+	keys  []signingKey
+}
+
+// signedZones holds the signed snapshots of the zones, which are served instead of the merged zone.
+type signedZones struct {
+	sync.RWMutex
+	zones map[string]*signedState
+}
+
+func (s *signedZones) get(zone string) *signedState {
+	if s == nil {
+		return nil
+	}
+	s.RLock()
+	defer s.RUnlock()
+	return s.zones[zone]
+}
+
+func (s *signedZones) set(zone string, signed *signedState) {
+	s.Lock()
+	defer s.Unlock()
+	if s.zones == nil {
+		s.zones = make(map[string]*signedState)
+	}
+	if signed == nil {
+		delete(s.zones, zone)
+		return
+	}
+	s.zones[zone] = signed
+}
+
+func (s *signedZones) cachedKeys(zone string) []signingKey {
+	s.RLock()
+	defer s.RUnlock()
+	if signed := s.zones[zone]; signed != nil {
+		return signed.keys
+	}
+	return nil
+}
+
+// snapshot returns the zone to serve: the signed zone and its NSEC3 chain if the zone is signed,
+// otherwise the merged zone.
+func (d DynamicUpdate) snapshot(zone string) (*file.Zone, *nsec3Chain) {
+	if signed := d.signed.get(zone); signed != nil {
+		return signed.zone, signed.nsec3
+	}
+	return d.Merge(zone), nil
+}
+
+// zoneKeys returns the keys of zone. They are read from the Secret of zoneObj if reload is set or
+// they are not read yet.
+func (d *DynamicUpdate) zoneKeys(ctx context.Context, zone string, zoneObj *rfc1035v1alpha1.Zone, reload bool) ([]signingKey, error) {
+	if keys := d.signed.cachedKeys(zone); !reload && len(keys) > 0 {
+		return keys, nil
+	}
+	secret := &corev1.Secret{}
+	if err := d.K8sClient.Get(ctx, client.ObjectKey{Namespace: zoneObj.Namespace, Name: zoneObj.Spec.DNSSEC.SecretName}, secret); err != nil {
+		return nil, fmt.Errorf("getting keys of %s: %w", zone, err)
+	}
+	keys, err := readKeys(secret)
+	if err != nil {
+		return nil, fmt.Errorf("reading keys of %s: %w", zone, err)
+	}
+	return keys, nil
+}
+
+// reloadKeys reads the keys of zoneObj from its Secret, if signing is enabled for zoneObj. It
+// returns no keys if it is not.
+func (d *DynamicUpdate) reloadKeys(ctx context.Context, zone string, zoneObj *rfc1035v1alpha1.Zone) ([]signingKey, error) {
+	if d.signed == nil || zoneObj.Spec.DNSSEC == nil {
+		return nil, nil
+	}
+	keys, err := d.zoneKeys(ctx, zone, zoneObj, true)
+	if err != nil {
+		signFailureCount.WithLabelValues(zone).Inc()
+		return nil, err
+	}
+	return keys, nil
+}
+
+// sign signs the zone merged from static and dz with keys and stores the signed snapshot, if
+// signing is enabled for zoneObj. The keys are read by reloadKeys beforehand, so no lock is held
+// while reading them. On failure the previous snapshot is kept, its signatures stay valid until
+// they are refreshed. The caller must hold a lock on dz.
+func (d *DynamicUpdate) sign(zone string, static staticZone, dz *file.Zone, zoneObj *rfc1035v1alpha1.Zone, keys []signingKey) error {
+	if d.signed == nil {
+		return nil
+	}
+	if zoneObj.Spec.DNSSEC == nil {
+		d.signed.set(zone, nil)
+		return nil
+	}
+	z := mergeWith(zone, static, dz)
+	if z == nil {
+		return fmt.Errorf("zone %s not found", zone)
+	}
+	z.RLock()
+	signed, err := signZone(z, zone, keys, zoneObj.Spec.DNSSEC.NSEC3, time.Now())
+	z.RUnlock()
+	if err != nil {
+		signFailureCount.WithLabelValues(zone).Inc()
+		return err
+	}
+	d.signed.set(zone, signed)
+	log.Debugf("Signed zone %s", zone)
+	return nil
+}
+
+// signState signs the merged zone with the dynamic RRs of state and serial, if signing is enabled
+// for zone. It returns nil if it is not. The changes of state are only written once they are
+// signed, so the signed snapshot served never lags behind the written dynamic RRs.
+func (d *DynamicUpdate) signState(ctx context.Context, zone string, state dynamicState, serial uint32) (*signedState, error) {
+	if d.signed == nil {
+		return nil, nil
+	}
+	static := d.Zones.static(zone)
+	spec := static.spec
+	if spec.DNSSEC == nil {
+		return nil, nil
+	}
+	zoneObj := &rfc1035v1alpha1.Zone{Spec: spec}
+	if len(d.signed.cachedKeys(zone)) == 0 {
+		var err error
+		if zoneObj, err = d.cachedZone(ctx, zone); err != nil {
+			return nil, err
+		}
+	}
+	keys, err := d.zoneKeys(ctx, zone, zoneObj, false)
+	if err != nil {
+		signFailureCount.WithLabelValues(zone).Inc()
+		return nil, err
+	}
+	z := mergeWith(zone, static, state.zone)
+	if z == nil || z.Apex.SOA == nil {
+		return nil, errZoneNotFound
+	}
+	soa := dns.Copy(z.Apex.SOA).(*dns.SOA)
+	soa.Serial = serial
+	z.Apex.SOA = soa
+	signed, err := signZone(z, zone, keys, spec.DNSSEC.NSEC3, time.Now())
+	if err != nil {
+		signFailureCount.WithLabelValues(zone).Inc()
+		return nil, fmt.Errorf("signing %s: %w", zone, err)
+	}
+	return signed, nil
+}
+
+// resign signs zone again, reading its keys.
+func (d *DynamicUpdate) resign(ctx context.Context, zone string) error {
+	zoneObj, err := d.getZone(ctx, zone)
+	if err != nil {
+		return err
+	}
+	keys, err := d.reloadKeys(ctx, zone, zoneObj)
+	if err != nil {
+		return err
+	}
+	static := d.Zones.static(zone)
+	dz, _ := d.Zones.dynamic(zone)
+	if dz == nil {
+		return errZoneNotFound
+	}
+	dz.RLock()
+	defer dz.RUnlock()
+	return d.sign(zone, static, dz, zoneObj, keys)
+}
+
+// resigner signs all zones every resignInterval. It runs on every replica, as each replica serves
+// its own signed snapshots.
+type resigner struct {
+	d *DynamicUpdate
+}
+
+// NeedLeaderElection implements the manager.LeaderElectionRunnable interface.
+func (r resigner) NeedLeaderElection() bool { return false }
+
+// Start implements the manager.Runnable interface.
+func (r resigner) Start(ctx context.Context) error {
+	tick := time.NewTicker(resignInterval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-tick.C:
+			r.d.Zones.RLock()
+			names := append([]string{}, r.d.Zones.Names...)
+			r.d.Zones.RUnlock()
+			for _, zone := range names {
+				if err := r.d.resign(ctx, zone); err != nil {
+					log.Errorf("Failed to sign zone %s: %s", zone, err)
+				}
+			}
+		}
+	}
+}
+
+// readKeys reads the keys of a zone from secret. For each key the Secret holds NAME.key and
+// NAME.private, and optionally NAME.state.
+func readKeys(secret *corev1.Secret) ([]signingKey, error) {
+	keys := []signingKey{}
+	for k, pub := range secret.Data {
+		if !strings.HasSuffix(k, ".key") {
+			continue
+		}
+		name := strings.TrimSuffix(k, ".key")
+		priv, ok := secret.Data[name+".private"]
+		if !ok {
+			return nil, fmt.Errorf("missing %s.private for %s", name, k)
+		}
+		key, err := parseKey(pub, priv, name)
+		if err != nil {
+			return nil, err
+		}
+		switch state := strings.TrimSpace(string(secret.Data[name+".state"])); state {
+		case "", keyStateActive:
+			key.active = true
+		case keyStatePublished:
+		default:
+			return nil, fmt.Errorf("unknown state %q of key %s", state, name)
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no keys in secret %s", secret.Name)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].dnskey.KeyTag() < keys[j].dnskey.KeyTag() })
+	return keys, nil
+}
+
+// parseKey parses a public and a private key in the format written by dnssec-keygen.
+func parseKey(pub, priv []byte, name string) (signingKey, error) {
+	zp := dns.NewZoneParser(bytes.NewReader(pub), "", name+".key")
+	rr, ok := zp.Next()
+	if err := zp.Err(); err != nil {
+		return signingKey{}, err
+	}
+	dnskey, isKey := rr.(*dns.DNSKEY)
+	if !ok || !isKey {
+		return signingKey{}, fmt.Errorf("no DNSKEY in %s.key", name)
+	}
+	pk, err := dnskey.ReadPrivateKey(bytes.NewReader(priv), name+".private")
+	if err != nil {
+		return signingKey{}, err
+	}
+	signer, ok := pk.(crypto.Signer)
+	if !ok {
+		return signingKey{}, fmt.Errorf("unsupported private key in %s.private", name)
+	}
+	return signingKey{dnskey: dnskey, signer: signer}, nil
+}
+
+// signZone returns a signed copy of z: the DNSKEY RRset is added to the apex, an NSEC chain, or an
+// NSEC3 chain with nsec3, is built and every authoritative RRset is signed. The caller must hold
+// a lock on z.
+func signZone(z *file.Zone, origin string, keys []signingKey, nsec3 *rfc1035v1alpha1.NSEC3, now time.Time) (*signedState, error) {
+	if z.Apex.SOA == nil {
+		return nil, fmt.Errorf("zone %s has no SOA", origin)
+	}
+	var ksks, zsks []signingKey
+	for _, k := range keys {
+		if !k.active {
+			continue
+		}
+		if k.ksk() {
+			ksks = append(ksks, k)
+		} else {
+			zsks = append(zsks, k)
+		}
+	}
+	if len(ksks) == 0 && len(zsks) == 0 {
+		return nil, fmt.Errorf("no active keys for %s", origin)
+	}
+	// Without a ZSK the KSK signs the zone, and the other way around
+	if len(zsks) == 0 {
+		zsks = ksks
+	}
+	if len(ksks) == 0 {
+		ksks = zsks
+	}
+
+	signed := file.NewZone(origin, "")
+	signed.Expired = z.Expired
+	insert := func(rr dns.RR) {
+		if err := signed.Insert(rr); err != nil {
+			log.Errorf("Failed to insert RR %s: %s", rr, err)
+		}
+	}
+	soa := dns.Copy(z.Apex.SOA).(*dns.SOA)
+	insert(soa)
+	for _, rr := range z.Apex.NS {
+		insert(dns.Copy(rr))
+	}
+	for _, e := range z.All() {
+		for _, rr := range e.All() {
+			switch rr.Header().Rrtype {
+			case dns.TypeRRSIG, dns.TypeNSEC, dns.TypeNSEC3, dns.TypeNSEC3PARAM, dns.TypeDNSKEY:
+				// Replaced by the signer
+				continue
+			}
+			insert(dns.Copy(rr))
+		}
+	}
+	for _, k := range keys {
+		dnskey := dns.Copy(k.dnskey).(*dns.DNSKEY)
+		dnskey.Hdr.Name = origin
+		dnskey.Hdr.Ttl = soa.Hdr.Ttl
+		insert(dnskey)
+	}
+
+	// The owner names of the NSEC chain, in canonical order, skipping names below a delegation
+	names := []string{}
+	for _, e := range signed.All() {
+		if !occluded(signed, origin, e.Name()) {
+			names = append(names, e.Name())
+		}
+	}
+	if len(names) == 0 || names[0] != origin {
+		names = append([]string{origin}, names...)
+	}
+
+	var chain *nsec3Chain
+	if nsec3 != nil {
+		chain = newNSEC3Chain(signed, origin, names, *nsec3, soa.Minttl)
+	}
+
+	inception, expiration := uint32(now.Add(-inceptionOffset).Unix()), uint32(now.Add(signatureValidity).Unix())
+	sigs := []dns.RR{}
+	for i, name := range names {
+		types := typesAt(signed, origin, name)
+		if chain == nil {
+			nsec := &dns.NSEC{
+				Hdr:        dns.RR_Header{Name: name, Rrtype: dns.TypeNSEC, Class: dns.ClassINET, Ttl: soa.Minttl},
+				NextDomain: names[(i+1)%len(names)],
+				TypeBitMap: append(append([]uint16{}, types...), dns.TypeNSEC, dns.TypeRRSIG),
+			}
+			sort.Slice(nsec.TypeBitMap, func(i, j int) bool { return nsec.TypeBitMap[i] < nsec.TypeBitMap[j] })
+			insert(nsec)
+			types = append(types, dns.TypeNSEC)
+		}
+
+		cut := name != origin && len(rrset(signed, origin, name, dns.TypeNS)) > 0
+		for _, t := range types {
+			if cut && t != dns.TypeDS && t != dns.TypeNSEC {
+				// Only the DS and NSEC RRsets at a delegation are authoritative
+				continue
+			}
+			signers := zsks
+			if t == dns.TypeDNSKEY {
+				signers = ksks
+			}
+			set := rrset(signed, origin, name, t)
+			if len(set) == 0 {
+				continue
+			}
+			for _, k := range signers {
+				sig, err := signRRSet(set, k, origin, inception, expiration)
+				if err != nil {
+					return nil, fmt.Errorf("signing %s/%s: %w", name, dns.TypeToString[t], err)
+				}
+				sigs = append(sigs, sig)
+			}
+		}
+	}
+	for _, sig := range sigs {
+		insert(sig)
+	}
+	if chain != nil {
+		sets := [][]dns.RR{{chain.param}}
+		for _, rr := range chain.records {
+			sets = append(sets, []dns.RR{rr})
+		}
+		for _, set := range sets {
+			for _, k := range zsks {
+				sig, err := signRRSet(set, k, origin, inception, expiration)
+				if err != nil {
+					return nil, fmt.Errorf("signing %s/%s: %w", set[0].Header().Name, dns.TypeToString[set[0].Header().Rrtype], err)
+				}
+				chain.sigs[sig.Hdr.Name] = append(chain.sigs[sig.Hdr.Name], sig)
+			}
+		}
+	}
+	return &signedState{zone: signed, nsec3: chain, keys: keys}, nil
+}
+
+// signRRSet returns the RRSIG of set made with k.
+func signRRSet(set []dns.RR, k signingKey, origin string, inception, expiration uint32) (*dns.RRSIG, error) {
+	h := set[0].Header()
+	sig := &dns.RRSIG{
+		Hdr:        dns.RR_Header{Name: h.Name, Rrtype: dns.TypeRRSIG, Class: dns.ClassINET, Ttl: h.Ttl},
+		Algorithm:  k.dnskey.Algorithm,
+		KeyTag:     k.dnskey.KeyTag(),
+		SignerName: origin,
+		Inception:  inception,
+		Expiration: expiration,
+	}
+	if err := sig.Sign(k.signer, set); err != nil {
+		return nil, err
+	}
+	return sig, nil
+}
+
+// typesAt returns the types of the RRsets owned by name in z, in ascending order.
+func typesAt(z *file.Zone, origin, name string) []uint16 {
+	types := []uint16{}
+	if name == origin {
+		types = append(types, dns.TypeSOA, dns.TypeNS)
+	}
+	if e, ok := z.Search(name); ok && e != nil {
+		for _, t := range e.Types() {
+			if t == dns.TypeRRSIG || t == dns.TypeNSEC {
+				continue
+			}
+			types = append(types, t)
+		}
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	return types
+}
+
+// occluded returns true if name is below a delegation point in z.
+func occluded(z *file.Zone, origin, name string) bool {
+	if name == origin {
+		return false
+	}
+	off, end := dns.NextLabel(name, 0)
+	if end {
+		return false
+	}
+	return delegated(z, origin, name[off:])
+}
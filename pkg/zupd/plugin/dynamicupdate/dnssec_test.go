@@ -0,0 +1,206 @@
+package dynamicupdate
+
+import (
+	"context"
+	"crypto"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coredns/coredns/plugin/file"
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	rfc1035v1alpha1 "github.com/cldmnky/ksdns/pkg/zupd/api/v1alpha1"
+)
+
+func testKey(t *testing.T, flags uint16) (string, string) {
+	k := &dns.DNSKEY{
+		Hdr:       dns.RR_Header{Name: exampleOrgZone, Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: 3600},
+		Flags:     flags,
+		Protocol:  3,
+		Algorithm: dns.ECDSAP256SHA256,
+	}
+	priv, err := k.Generate(256)
+	require.NoError(t, err)
+	return k.String(), k.PrivateKeyString(priv.(crypto.PrivateKey))
+}
+
+func TestSignZone(t *testing.T) {
+	kskPub, kskPriv := testKey(t, 257)
+	zskPub, zskPriv := testKey(t, 256)
+	oldPub, _ := testKey(t, 256)
+	secret := &corev1.Secret{Data: map[string][]byte{
+		"ksk.key":     []byte(kskPub),
+		"ksk.private": []byte(kskPriv),
+		"zsk.key":     []byte(zskPub),
+		"zsk.private": []byte(zskPriv),
+	}}
+	keys, err := readKeys(secret)
+	require.NoError(t, err)
+	require.Len(t, keys, 2)
+
+	// Every key needs its private key
+	secret.Data["old.key"] = []byte(oldPub)
+	_, err = readKeys(secret)
+	assert.Error(t, err)
+	delete(secret.Data, "old.key")
+
+	zone, err := file.Parse(strings.NewReader(exampleOrg+"sub 3600 NS ns1.sub.example.org.\nns1.sub 3600 A 10.0.0.53\n"), exampleOrgZone, "stdin", 0)
+	require.NoError(t, err)
+	s, err := signZone(zone, exampleOrgZone, keys, nil, time.Now())
+	require.NoError(t, err)
+	signed := s.zone
+
+	var ksk, zsk *dns.DNSKEY
+	for _, k := range keys {
+		if k.ksk() {
+			ksk = k.dnskey
+		} else {
+			zsk = k.dnskey
+		}
+	}
+
+	// The DNSKEY RRset is signed by the KSK
+	dnskeys := rrset(signed, exampleOrgZone, exampleOrgZone, dns.TypeDNSKEY)
+	require.Len(t, dnskeys, 2)
+	sigs := covering(rrset(signed, exampleOrgZone, exampleOrgZone, dns.TypeRRSIG), dns.TypeDNSKEY)
+	require.Len(t, sigs, 1)
+	assert.NoError(t, sigs[0].Verify(ksk, dnskeys))
+
+	// The SOA and other RRsets are signed by the ZSK
+	require.Len(t, signed.Apex.SIGSOA, 1)
+	assert.NoError(t, signed.Apex.SIGSOA[0].(*dns.RRSIG).Verify(zsk, []dns.RR{signed.Apex.SOA}))
+	webapp := rrset(signed, exampleOrgZone, "webapp.example.org.", dns.TypeA)
+	sigs = covering(rrset(signed, exampleOrgZone, "webapp.example.org.", dns.TypeRRSIG), dns.TypeA)
+	require.Len(t, sigs, 1)
+	assert.NoError(t, sigs[0].Verify(zsk, webapp))
+
+	// The delegation is not signed, glue is not part of the NSEC chain
+	assert.Empty(t, covering(rrset(signed, exampleOrgZone, "sub.example.org.", dns.TypeRRSIG), dns.TypeNS))
+	assert.Empty(t, rrset(signed, exampleOrgZone, "ns1.sub.example.org.", dns.TypeNSEC))
+
+	// The NSEC chain is closed
+	name, seen := exampleOrgZone, 0
+	for {
+		nsec := rrset(signed, exampleOrgZone, name, dns.TypeNSEC)
+		require.Len(t, nsec, 1, name)
+		name = nsec[0].(*dns.NSEC).NextDomain
+		seen++
+		if name == exampleOrgZone || seen > 100 {
+			break
+		}
+	}
+	assert.Equal(t, exampleOrgZone, name)
+
+	// Published keys are not used to sign
+	for i := range keys {
+		keys[i].active = keys[i].ksk()
+	}
+	s, err = signZone(zone, exampleOrgZone, keys, nil, time.Now())
+	require.NoError(t, err)
+	signed = s.zone
+	require.Len(t, signed.Apex.SIGSOA, 1)
+	assert.NoError(t, signed.Apex.SIGSOA[0].(*dns.RRSIG).Verify(ksk, []dns.RR{signed.Apex.SOA}))
+}
+
+func covering(sigs []dns.RR, t uint16) []*dns.RRSIG {
+	c := []*dns.RRSIG{}
+	for _, rr := range sigs {
+		if sig := rr.(*dns.RRSIG); sig.TypeCovered == t {
+			c = append(c, sig)
+		}
+	}
+	return c
+}
+
+func TestSignUpdate(t *testing.T) {
+	zskPub, zskPriv := testKey(t, 256)
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "example-org-dnssec", Namespace: "default"},
+		Data: map[string][]byte{
+			"zsk.key":     []byte(zskPub),
+			"zsk.private": []byte(zskPriv),
+		},
+	}
+	dnssec := &rfc1035v1alpha1.ZoneDNSSEC{SecretName: secret.Name}
+	zoneObj := newTestZoneObject()
+	zoneObj.Spec.DNSSEC = dnssec
+	a, err := dns.NewRR("a.example.org. 60 IN A 10.0.0.1")
+	require.NoError(t, err)
+
+	// An update that can not be signed is not written
+	d := newTestUpdate(t, 0, zoneObj)
+	d.signed = &signedZones{}
+	d.Zones.Specs[exampleOrgZone] = zoneObj.Spec
+	failures := testutil.ToFloat64(signFailureCount.WithLabelValues(exampleOrgZone))
+	assert.Equal(t, dns.RcodeServerFailure, update(t, d, []dns.RR{a}, nil))
+	assert.Equal(t, failures+1, testutil.ToFloat64(signFailureCount.WithLabelValues(exampleOrgZone)))
+	written, err := d.getZone(context.Background(), exampleOrgZone)
+	require.NoError(t, err)
+	assert.Empty(t, written.Status.DynamicRRs)
+	assert.Empty(t, d.Zones.DynamicZones[exampleOrgZone].All())
+
+	// The signed snapshot is served with the update
+	require.NoError(t, d.K8sClient.Create(context.Background(), secret))
+	assert.Equal(t, dns.RcodeSuccess, update(t, d, []dns.RR{a}, nil))
+	require.NotNil(t, d.signed.get(exampleOrgZone))
+	signed := d.signed.get(exampleOrgZone).zone
+	assert.Len(t, rrset(signed, exampleOrgZone, "a.example.org.", dns.TypeA), 1)
+	assert.Len(t, covering(rrset(signed, exampleOrgZone, "a.example.org.", dns.TypeRRSIG), dns.TypeA), 1)
+	written, err = d.getZone(context.Background(), exampleOrgZone)
+	require.NoError(t, err)
+	assert.Equal(t, written.Status.Serial, signed.Apex.SOA.Serial)
+}
+
+// lockCheckingClient records whether the zones are locked while a Secret is read.
+type lockCheckingClient struct {
+	client.Client
+	zones  *Zones
+	locked bool
+}
+
+func (c *lockCheckingClient) Get(ctx context.Context, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+	if _, ok := obj.(*corev1.Secret); ok {
+		if c.zones.TryLock() {
+			c.zones.Unlock()
+		} else {
+			c.locked = true
+		}
+	}
+	return c.Client.Get(ctx, key, obj, opts...)
+}
+
+func TestReconcileReadsKeysUnlocked(t *testing.T) {
+	zskPub, zskPriv := testKey(t, 256)
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "example-org-dnssec", Namespace: "default"},
+		Data: map[string][]byte{
+			"zsk.key":     []byte(zskPub),
+			"zsk.private": []byte(zskPriv),
+		},
+	}
+	zoneObj := newTestZoneObject()
+	zoneObj.Spec.DNSSEC = &rfc1035v1alpha1.ZoneDNSSEC{SecretName: secret.Name}
+	d := newTestUpdate(t, 0, zoneObj, secret)
+	d.signed = &signedZones{}
+	c := &lockCheckingClient{Client: d.K8sClient, zones: d.Zones}
+	d.K8sClient, d.Client = c, c
+
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Name: "example.org", Namespace: "default"}}
+	_, err := d.Reconcile(context.Background(), req)
+	require.NoError(t, err)
+	assert.NotNil(t, d.signed.get(exampleOrgZone))
+	assert.False(t, c.locked, "the keys are read with the zones locked")
+
+	c.locked = false
+	require.NoError(t, d.resign(context.Background(), exampleOrgZone))
+	assert.False(t, c.locked, "the keys are read with the zones locked")
+}
@@ -3,7 +3,6 @@ package dynamicupdate
 import (
 	"context"
 	"fmt"
-	"strings"
 	"sync"
 	"time"
 
@@ -14,6 +13,7 @@ import (
 	"github.com/coredns/coredns/request"
 	"github.com/miekg/dns"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 
@@ -38,6 +38,25 @@ type (
 		K8sClient client.Client
 		// mgr is the manager used to run the controller.
 		mgr manager.Manager
+		// recorder records audit events on Zone objects.
+		recorder record.EventRecorder
+		// history is the number of updates kept in the status of a zone, 0 disables the history.
+		history int
+		// limits are the default limits of a zone, and keyLimits the default limits of a TSIG key.
+		limits    rfc1035v1alpha1.Limits
+		keyLimits rfc1035v1alpha1.Limits
+		// limiters holds the token buckets of the zones and TSIG keys.
+		limiters *limiters
+		// commitWindow is the time updates are collected before they are written, 0 is sync mode.
+		commitWindow time.Duration
+		// committers coalesces the writes of the dynamic RRs of the zones.
+		committers *committers
+		// signed holds the signed snapshots of the zones with DNSSEC enabled.
+		signed *signedZones
+		// requireTSIG refuses the updates that are not signed with TSIG, with one of
+		// tsigAlgorithms if not empty.
+		requireTSIG    bool
+		tsigAlgorithms map[string]bool
 
 		// Client
 		client.Client
@@ -49,15 +68,101 @@ type (
 		Z            map[string]*file.Zone
 		Names        []string
 		DynamicZones map[string]*file.Zone
+		// Specs holds the spec of the Zone object of each zone.
+		Specs map[string]rfc1035v1alpha1.ZoneSpec
+		// leases holds the leases of the dynamic RRs of each zone, keyed by the RR string.
+		leases map[string]map[string]lease
 		sync.RWMutex
 	}
 )
 
+// dynamic returns the dynamic zone of name and the leases of its RRs. It must not be called
+// with the lock of a dynamic zone held, as the zones are locked before the dynamic zones.
+func (z *Zones) dynamic(name string) (*file.Zone, map[string]lease) {
+	z.RLock()
+	defer z.RUnlock()
+	return z.DynamicZones[name], z.leases[name]
+}
+
+// staticZone is the static zone of a zone with the spec of its Zone object, as read under the lock
+// of the zones. The static zone is replaced, not changed, when the spec changes, so it can be used
+// without the lock of the zones, with its own lock.
+type staticZone struct {
+	zone *file.Zone
+	spec rfc1035v1alpha1.ZoneSpec
+}
+
+// static returns the static zone of name with its spec. It must not be called with the lock of a
+// dynamic zone held, as the zones are locked before the dynamic zones.
+func (z *Zones) static(name string) staticZone {
+	z.RLock()
+	defer z.RUnlock()
+	return staticZone{zone: z.Z[name], spec: z.Specs[name]}
+}
+
+// match returns the zone qname belongs to, or an empty string.
+func (z *Zones) match(qname string) string {
+	z.RLock()
+	defer z.RUnlock()
+	return plugin.Zones(z.Names).Matches(qname)
+}
+
+// serial returns the SOA serial served for zone name, 0 if none.
+func (z *Zones) serial(name string) uint32 {
+	z.RLock()
+	defer z.RUnlock()
+	zone := z.Z[name]
+	if zone == nil {
+		return 0
+	}
+	zone.RLock()
+	defer zone.RUnlock()
+	if zone.Apex.SOA != nil {
+		return zone.Apex.SOA.Serial
+	}
+	return 0
+}
+
+// setSerial sets the SOA serial served for zone name. The SOA of the static zone is replaced, not
+// changed, as the merged zones being served share it. It returns false if the zone has no SOA.
+func (z *Zones) setSerial(name string, serial uint32) bool {
+	z.RLock()
+	defer z.RUnlock()
+	zone := z.Z[name]
+	if zone == nil {
+		return false
+	}
+	zone.Lock()
+	defer zone.Unlock()
+	if zone.Apex.SOA == nil {
+		return false
+	}
+	soa := dns.Copy(zone.Apex.SOA).(*dns.SOA)
+	soa.Serial = serial
+	zone.Apex.SOA = soa
+	return true
+}
+
+// raiseSerial raises the SOA serial of z, parsed from the spec of a Zone, to serials that are
+// greater in serial number arithmetic, e.g. the serial of its dynamic updates.
+func raiseSerial(z *file.Zone, serials ...uint32) {
+	if z.Apex.SOA == nil {
+		return
+	}
+	for _, s := range serials {
+		if s != 0 && rfc1035v1alpha1.SerialLess(z.Apex.SOA.Serial, s) {
+			z.Apex.SOA.Serial = s
+		}
+	}
+}
+
 func (z *Zones) DeleteZone(name string) {
 	z.Lock()
 	defer z.Unlock()
 	delete(z.Z, name)
 	delete(z.DynamicZones, name)
+	delete(z.Specs, name)
+	delete(z.leases, name)
 	// delete from names
 	for i, n := range z.Names {
 		if n == name {
@@ -71,14 +176,15 @@ func (z *Zones) DeleteZone(name string) {
 func (d *DynamicUpdate) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
 	var z *file.Zone
 	state := request.Request{W: w, Req: r}
+	requestCount.WithLabelValues(metrics.WithServer(ctx)).Inc()
 	qname := state.Name()
-	zone := plugin.Zones(d.Zones.Names).Matches(qname)
+	zone := d.Zones.match(qname)
 	if zone == "" {
 		return plugin.NextOrFailure(d.Name(), d.Next, ctx, w, r)
 	}
 
-	dz, ok := d.Zones.DynamicZones[zone]
-	if !ok || dz == nil {
+	dz, _ := d.Zones.dynamic(zone)
+	if dz == nil {
 		return dns.RcodeServerFailure, nil
 	}
 
@@ -94,123 +200,9 @@ func (d *DynamicUpdate) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *d
 
 	// Handle dynamic update
 	if r.Opcode == dns.OpcodeUpdate {
-		var (
-			found     bool = false
-			zoneObj   rfc1035v1alpha1.Zone
-			soaSerial uint32 = uint32(time.Now().UnixMilli())
-		)
-		log.Debugf("Handling dynamic update for %s", zone)
-		dz.Lock()
-
-		for range r.Question {
-			for _, rr := range r.Ns {
-				// Only allow TXT, CNAME, A, AAAA, and SRV records
-				if rr.Header().Rrtype != dns.TypeTXT &&
-					rr.Header().Rrtype != dns.TypeCNAME &&
-					rr.Header().Rrtype != dns.TypeA &&
-					rr.Header().Rrtype != dns.TypeAAAA &&
-					rr.Header().Rrtype != dns.TypeSRV {
-					log.Debugf("Rejecting dynamic update for %s: %s", zone, rr.Header().String())
-					dz.Unlock()
-					return dns.RcodeRefused, nil
-				}
-				// Get the record
-				h := rr.Header()
-				if _, ok := dns.IsDomainName(h.Name); ok {
-					switch updateType(h) {
-					case "insert":
-						log.Debugf("Inserting %s", rr.String())
-						if err := dz.Insert(rr); err != nil {
-							log.Errorf("Error inserting %s: %s", rr.String(), err.Error())
-							dz.Unlock()
-							return dns.RcodeServerFailure, nil
-						}
-						// get the zone from the cluster
-						// update the zone with the new record
-					case "remove":
-						log.Infof("Removing %s", rr.String())
-						dz.Delete(rr)
-					default:
-						log.Infof("Unknown update type for %s", rr.String())
-						dz.Unlock()
-						return dns.RcodeNotImplemented, nil
-					}
-				}
-			}
-		}
-		dz.Unlock()
-		dz.RLock()
-		defer dz.RUnlock()
-		// Get the zone
-		for _, ns := range d.Namespaces {
-			if err := d.K8sClient.Get(context.TODO(), client.ObjectKey{
-				Namespace: ns,
-				Name:      strings.TrimSuffix(zone, "."),
-			}, &zoneObj); err != nil {
-				continue
-			}
-			found = true
-			break
-		}
-		if !found {
-			log.Debugf("Rejecting dynamic update for %s, object not found", zone)
-			return dns.RcodeRefused, nil
-		}
-		// Update the zone
-		zoneObj.Status.DynamicRRs = make([]rfc1035v1alpha1.DynamicRR, 0)
-		for _, el := range dz.All() {
-			for _, rr := range el.All() {
-				zoneObj.Status.DynamicRRs = append(zoneObj.Status.DynamicRRs, rfc1035v1alpha1.DynamicRR{
-					RR: rr.String(),
-				})
-			}
-		}
-		// set serial
-		zoneObj.Status.Serial = soaSerial
-		// Update the zone
-		if err := d.K8sClient.Status().Update(context.TODO(), &zoneObj); err != nil {
-			log.Errorf("Error updating zone object: %s", err.Error())
-			return dns.RcodeServerFailure, nil
-		}
-		z = d.Merge(zone)
-		// Update SOA serial
-		apex, err := z.ApexIfDefined()
-		if err != nil {
-			log.Errorf("Failed to get SOA record: %s", err)
-			return dns.RcodeServerFailure, nil
-		}
-		for _, rr := range apex {
-			// get the Soa record
-			if soa, ok := rr.(*dns.SOA); ok {
-				soa.Serial = soaSerial
-				if err := z.Insert(soa); err != nil {
-					log.Errorf("Failed to update SOA record: %s", err)
-					return dns.RcodeServerFailure, nil
-				}
-				log.Debugf("Updated SOA serial to %d", soa.Serial)
-			}
-		}
-
-		// Notify other servers
-		if d.transfer != nil {
-			log.Infof("Notifying other  qservers of update")
-			if err := d.transfer.Notify(zone); err != nil {
-				log.Errorf("Error notifying other servers: %s", err.Error())
-			}
-		}
-
-		m := new(dns.Msg)
-		m.SetReply(r)
-		m.Authoritative = true
-		if err := w.WriteMsg(m); err != nil {
-			log.Errorf("Error writing response: %s", err.Error())
-			return dns.RcodeServerFailure, nil
-		}
-		// log message
-		log.Debugf("Dynamic update for %s from %s: %s", zone, state.IP(), m.String())
-		return dns.RcodeSuccess, nil
+		return d.serveUpdate(ctx, state, zone, dz)
 	}
-	z = d.Merge(zone)
+	z, nsec3 := d.snapshot(zone)
 	z.RLock()
 	exp := z.Expired
 	z.RUnlock()
@@ -218,11 +210,25 @@ func (d *DynamicUpdate) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *d
 		log.Errorf("Zone %s is expired", zone)
 		return dns.RcodeServerFailure, nil
 	}
-	answer, ns, extra, result := z.Lookup(ctx, state, qname)
 	m := new(dns.Msg)
 	m.SetReply(r)
 	m.Authoritative = true
+	if nsec3 != nil {
+		// The NSEC3 records are not in the signed zone
+		if answer, ok := nsec3.lookup(qname, state.QType(), state.Do()); ok {
+			m.Answer = answer
+			if err := w.WriteMsg(m); err != nil {
+				log.Errorf("Error writing response: %s", err.Error())
+				return dns.RcodeServerFailure, nil
+			}
+			return dns.RcodeSuccess, nil
+		}
+	}
+	answer, ns, extra, result := z.Lookup(ctx, state, qname)
 	m.Answer, m.Ns, m.Extra = answer, ns, extra
+	if nsec3 != nil && state.Do() {
+		m.Ns = append(m.Ns, nsec3.proof(result, qname, m.Answer, m.Ns)...)
+	}
 
 	switch result {
 	case file.Success:
@@ -0,0 +1,139 @@
+package dynamicupdate
+
+import (
+	"context"
+	"time"
+
+	"github.com/coredns/coredns/plugin/file"
+	"github.com/miekg/dns"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	rfc1035v1alpha1 "github.com/cldmnky/ksdns/pkg/zupd/api/v1alpha1"
+)
+
+// sweepInterval is the interval at which expired dynamic RRs are removed.
+const sweepInterval = 30 * time.Second
+
+// lease holds the owner and the expiry of a dynamic RR, a zero expiry never expires.
+type lease struct {
+	owner   string
+	expires time.Time
+}
+
+// newLease returns a lease for owner lasting d, or a lease that never expires if d is zero.
+func newLease(owner string, d time.Duration) lease {
+	l := lease{owner: owner}
+	if d > 0 {
+		l.expires = time.Now().Add(d)
+	}
+	return l
+}
+
+// leaseFromDynamicRR returns the lease stored with rr in the status of a zone.
+func leaseFromDynamicRR(rr rfc1035v1alpha1.DynamicRR) lease {
+	l := lease{owner: rr.Owner}
+	if rr.Expires != nil {
+		l.expires = rr.Expires.Time
+	}
+	return l
+}
+
+// dynamicRR returns rr with the lease, as stored in the status of a zone.
+func (l lease) dynamicRR(rr dns.RR) rfc1035v1alpha1.DynamicRR {
+	drr := rfc1035v1alpha1.DynamicRR{RR: rr.String(), Owner: l.owner}
+	if !l.expires.IsZero() {
+		expires := metav1.NewTime(l.expires)
+		drr.Expires = &expires
+	}
+	return drr
+}
+
+func (l lease) expired(now time.Time) bool {
+	return !l.expires.IsZero() && now.After(l.expires)
+}
+
+// updateLease returns the EDNS0 UPDATE-LEASE option of r, if any.
+func updateLease(r *dns.Msg) *dns.EDNS0_UL {
+	o := r.IsEdns0()
+	if o == nil {
+		return nil
+	}
+	for _, e := range o.Option {
+		if ul, ok := e.(*dns.EDNS0_UL); ok {
+			return ul
+		}
+	}
+	return nil
+}
+
+// leaseDuration returns the lease for RRs added by r to the zone of spec. The lease requested in
+// an UPDATE-LEASE option takes precedence over the default lease of the zone.
+func leaseDuration(spec rfc1035v1alpha1.ZoneSpec, r *dns.Msg) time.Duration {
+	if ul := updateLease(r); ul != nil {
+		return time.Duration(ul.Lease) * time.Second
+	}
+	if spec.DefaultLease != nil {
+		return spec.DefaultLease.Duration
+	}
+	return 0
+}
+
+// pruneLeases removes the leases of RRs no longer in dz. The caller must hold the lock of dz.
+func pruneLeases(dz *file.Zone, leases map[string]lease) {
+	current := map[string]bool{}
+	for _, el := range dz.All() {
+		for _, rr := range el.All() {
+			current[rr.String()] = true
+		}
+	}
+	for k := range leases {
+		if !current[k] {
+			delete(leases, k)
+		}
+	}
+}
+
+// sweep removes expired dynamic RRs until ctx is done. It is run by the manager, so only the
+// leader sweeps; the other replicas drop the expired RRs with their next write.
+func (d *DynamicUpdate) sweep(ctx context.Context) error {
+	tick := time.NewTicker(sweepInterval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case now := <-tick.C:
+			d.Zones.RLock()
+			names := append([]string{}, d.Zones.Names...)
+			d.Zones.RUnlock()
+			for _, zone := range names {
+				d.sweepZone(zone, now)
+			}
+		}
+	}
+}
+
+// sweepZone removes the RRs of zone that expired before now, bumps the serial and persists the
+// remaining dynamic RRs.
+func (d *DynamicUpdate) sweepZone(zone string, now time.Time) {
+	dz, leases := d.Zones.dynamic(zone)
+	if dz == nil {
+		return
+	}
+	c := d.committers.get(d, zone)
+	dz.Lock()
+	next := c.base(dz, leases)
+	expired := next.expire(now)
+	if expired == 0 {
+		dz.Unlock()
+		return
+	}
+	req := c.enqueue(nil, next)
+	dz.Unlock()
+
+	if _, err := req.wait(); err != nil {
+		log.Errorf("Failed to remove expired records from %s: %s", zone, err)
+		return
+	}
+	log.Infof("Removed %d expired records from %s", expired, zone)
+}
@@ -0,0 +1,140 @@
+package dynamicupdate
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/coredns/coredns/plugin/file"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/coredns/coredns/request"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	rfc1035v1alpha1 "github.com/cldmnky/ksdns/pkg/zupd/api/v1alpha1"
+)
+
+func TestLeaseDuration(t *testing.T) {
+	spec := rfc1035v1alpha1.ZoneSpec{DefaultLease: &metav1.Duration{Duration: time.Hour}}
+
+	m := new(dns.Msg)
+	m.SetUpdate(exampleOrgZone)
+	assert.Equal(t, time.Hour, leaseDuration(spec, m))
+	assert.Equal(t, time.Duration(0), leaseDuration(rfc1035v1alpha1.ZoneSpec{}, m))
+
+	m.SetEdns0(dns.DefaultMsgSize, false)
+	m.IsEdns0().Option = append(m.IsEdns0().Option, &dns.EDNS0_UL{Code: dns.EDNS0UL, Lease: 60})
+	assert.Equal(t, time.Minute, leaseDuration(spec, m))
+}
+
+func TestLeaseExpired(t *testing.T) {
+	now := time.Now()
+	assert.False(t, newLease("key.", 0).expired(now.Add(time.Hour)))
+	assert.False(t, newLease("key.", time.Minute).expired(now))
+	assert.True(t, newLease("key.", time.Minute).expired(now.Add(time.Hour)))
+
+	rr, err := dns.NewRR("_acme-challenge.example.org. 60 IN TXT \"token\"")
+	require.NoError(t, err)
+	l := newLease("key.", time.Minute)
+	drr := l.dynamicRR(rr)
+	assert.Equal(t, "key.", drr.Owner)
+	require.NotNil(t, drr.Expires)
+	assert.True(t, leaseFromDynamicRR(drr).expires.Equal(drr.Expires.Time))
+}
+
+func TestRemoveRR(t *testing.T) {
+	dz := file.NewZone(exampleOrgZone, "")
+	a1, err := dns.NewRR("www.example.org. 300 IN A 10.0.0.1")
+	require.NoError(t, err)
+	a2, err := dns.NewRR("www.example.org. 300 IN A 10.0.0.2")
+	require.NoError(t, err)
+	require.NoError(t, dz.Insert(a1))
+	require.NoError(t, dz.Insert(a2))
+
+	leases := map[string]lease{
+		a1.String(): newLease("key.", time.Minute),
+		a2.String(): newLease("key.", time.Minute),
+	}
+	removeRR(dz, a1)
+	pruneLeases(dz, leases)
+
+	assert.Equal(t, []dns.RR{a2}, rrset(dz, exampleOrgZone, "www.example.org.", dns.TypeA))
+	assert.NotContains(t, leases, a1.String())
+	assert.Contains(t, leases, a2.String())
+}
+
+func TestRemoveKeepsOtherOwners(t *testing.T) {
+	d := newTestUpdate(t, 0, newTestZoneObject())
+	serve := func(key string, insert, remove []dns.RR) int {
+		m := new(dns.Msg)
+		m.SetUpdate(exampleOrgZone)
+		m.Insert(insert)
+		m.Remove(remove)
+		m.SetTsig(key, dns.HmacSHA256, 300, time.Now().Unix())
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		rcode, err := d.serveUpdate(context.Background(), request.Request{W: rec, Req: m}, exampleOrgZone, d.Zones.DynamicZones[exampleOrgZone])
+		require.NoError(t, err)
+		return rcode
+	}
+	a, err := dns.NewRR("_acme-challenge.example.org. 60 IN TXT \"a\"")
+	require.NoError(t, err)
+	b, err := dns.NewRR("_acme-challenge.example.org. 60 IN TXT \"b\"")
+	require.NoError(t, err)
+	require.Equal(t, dns.RcodeSuccess, serve("cert-manager-a.", []dns.RR{a}, nil))
+	require.Equal(t, dns.RcodeSuccess, serve("cert-manager-b.", []dns.RR{b}, nil))
+
+	// Removing the TXT of one owner keeps the TXT and the lease of the other
+	require.Equal(t, dns.RcodeSuccess, serve("cert-manager-a.", nil, []dns.RR{a}))
+	dz, leases := d.Zones.dynamic(exampleOrgZone)
+	assert.Equal(t, []dns.RR{b}, rrset(dz, exampleOrgZone, "_acme-challenge.example.org.", dns.TypeTXT))
+	assert.NotContains(t, leases, a.String())
+	require.Contains(t, leases, b.String())
+	assert.Equal(t, "cert-manager-b.", leases[b.String()].owner)
+	written, err := d.getZone(context.Background(), exampleOrgZone)
+	require.NoError(t, err)
+	require.Len(t, written.Status.DynamicRRs, 1)
+	assert.Equal(t, b.String(), written.Status.DynamicRRs[0].RR)
+}
+
+func TestWriteDropsExpired(t *testing.T) {
+	d := newTestUpdate(t, 0, newTestZoneObject())
+	dz, leases := d.Zones.dynamic(exampleOrgZone)
+	old, err := dns.NewRR("old.example.org. 60 IN A 10.0.0.1")
+	require.NoError(t, err)
+	require.NoError(t, dz.Insert(old))
+	leases[old.String()] = lease{owner: "key.", expires: time.Now().Add(-time.Minute)}
+
+	// An update on a replica that does not sweep drops the expired RR
+	a, err := dns.NewRR("a.example.org. 60 IN A 10.0.0.2")
+	require.NoError(t, err)
+	require.Equal(t, dns.RcodeSuccess, update(t, d, []dns.RR{a}, nil))
+	written, err := d.getZone(context.Background(), exampleOrgZone)
+	require.NoError(t, err)
+	require.Len(t, written.Status.DynamicRRs, 1)
+	assert.Equal(t, a.String(), written.Status.DynamicRRs[0].RR)
+	assert.Empty(t, rrset(dz, exampleOrgZone, "old.example.org.", dns.TypeA))
+	assert.NotContains(t, leases, old.String())
+}
+
+func TestSweepZone(t *testing.T) {
+	d := newTestUpdate(t, 0, newTestZoneObject())
+	dz, leases := d.Zones.dynamic(exampleOrgZone)
+	a, err := dns.NewRR("a.example.org. 60 IN A 10.0.0.1")
+	require.NoError(t, err)
+	b, err := dns.NewRR("b.example.org. 60 IN A 10.0.0.2")
+	require.NoError(t, err)
+	require.NoError(t, dz.Insert(a))
+	require.NoError(t, dz.Insert(b))
+	leases[a.String()] = newLease("key.", time.Minute)
+	leases[b.String()] = newLease("key.", 0)
+
+	d.sweepZone(exampleOrgZone, time.Now().Add(time.Hour))
+	written, err := d.getZone(context.Background(), exampleOrgZone)
+	require.NoError(t, err)
+	require.Len(t, written.Status.DynamicRRs, 1)
+	assert.Equal(t, b.String(), written.Status.DynamicRRs[0].RR)
+	assert.Empty(t, rrset(dz, exampleOrgZone, "a.example.org.", dns.TypeA))
+}
@@ -0,0 +1,141 @@
+package dynamicupdate
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/coredns/coredns/plugin/file"
+	"github.com/miekg/dns"
+	"golang.org/x/time/rate"
+
+	rfc1035v1alpha1 "github.com/cldmnky/ksdns/pkg/zupd/api/v1alpha1"
+)
+
+// Names of the limits, as used in the limit label of limitRejectionCount.
+const (
+	limitRate       = "rate"
+	limitMessageRRs = "message_rrs"
+	limitRecords    = "records"
+	limitKeyRate    = "key_rate"
+	limitKeyMsgRRs  = "key_message_rrs"
+	limitKeyRecords = "key_records"
+)
+
+// limiters holds the token buckets of the zones and TSIG keys.
+type limiters struct {
+	sync.Mutex
+	m map[string]*rate.Limiter
+}
+
+// allow returns true if an update may be taken from the bucket for name. The bucket is
+// recreated when the rate or the burst changed.
+func (l *limiters) allow(name string, updatesPerSecond, burst int32) bool {
+	if updatesPerSecond <= 0 {
+		return true
+	}
+	if burst <= 0 {
+		burst = updatesPerSecond
+	}
+	l.Lock()
+	defer l.Unlock()
+	if l.m == nil {
+		l.m = make(map[string]*rate.Limiter)
+	}
+	lim, ok := l.m[name]
+	if !ok || lim.Limit() != rate.Limit(updatesPerSecond) || lim.Burst() != int(burst) {
+		lim = rate.NewLimiter(rate.Limit(updatesPerSecond), int(burst))
+		l.m[name] = lim
+	}
+	return lim.Allow()
+}
+
+// overrideLimits returns base with the non zero limits of l.
+func overrideLimits(base, l rfc1035v1alpha1.Limits) rfc1035v1alpha1.Limits {
+	if l.MaxRecords != 0 {
+		base.MaxRecords = l.MaxRecords
+	}
+	if l.MaxRRsPerMessage != 0 {
+		base.MaxRRsPerMessage = l.MaxRRsPerMessage
+	}
+	if l.UpdatesPerSecond != 0 {
+		base.UpdatesPerSecond = l.UpdatesPerSecond
+	}
+	if l.Burst != 0 {
+		base.Burst = l.Burst
+	}
+	return base
+}
+
+// limitsFor returns the limits for updates to the zone of spec and for updates to the zone signed
+// with key. The limits of the Zone object override the limits from the Corefile.
+func (d *DynamicUpdate) limitsFor(spec rfc1035v1alpha1.ZoneSpec, key string) (zl, kl rfc1035v1alpha1.Limits) {
+	zl, kl = d.limits, d.keyLimits
+	if spec.Limits == nil {
+		return zl, kl
+	}
+	zl = overrideLimits(zl, spec.Limits.Limits)
+	for _, k := range spec.Limits.Keys {
+		if strings.EqualFold(dns.Fqdn(k.Name), key) {
+			kl = overrideLimits(kl, k.Limits)
+		}
+	}
+	return zl, kl
+}
+
+// checkRate checks the size of an update to zone, of spec, signed with key and takes it from the
+// token buckets. It returns the name of the exceeded limit, or an empty string.
+func (d *DynamicUpdate) checkRate(zone string, spec rfc1035v1alpha1.ZoneSpec, key string, updates []dns.RR) string {
+	zl, kl := d.limitsFor(spec, key)
+	if zl.MaxRRsPerMessage > 0 && len(updates) > int(zl.MaxRRsPerMessage) {
+		return limitMessageRRs
+	}
+	if key != "" && kl.MaxRRsPerMessage > 0 && len(updates) > int(kl.MaxRRsPerMessage) {
+		return limitKeyMsgRRs
+	}
+	if !d.limiters.allow(zone, zl.UpdatesPerSecond, zl.Burst) {
+		return limitRate
+	}
+	if key != "" && !d.limiters.allow(zone+"/"+key, kl.UpdatesPerSecond, kl.Burst) {
+		return limitKeyRate
+	}
+	return ""
+}
+
+// checkRecords checks that applying updates to the dynamic zone dz does not exceed the maximum
+// number of records of the zone, of spec, or of the records added with key. It returns the name
+// of the exceeded limit, or an empty string. The caller must hold the lock of dz.
+func (d *DynamicUpdate) checkRecords(zone string, spec rfc1035v1alpha1.ZoneSpec, key string, dz *file.Zone, leases map[string]lease, updates []dns.RR) string {
+	zl, kl := d.limitsFor(spec, key)
+	if zl.MaxRecords <= 0 && (key == "" || kl.MaxRecords <= 0) {
+		return ""
+	}
+	added := 0
+	for _, rr := range updates {
+		if updateType(rr.Header()) != "insert" {
+			continue
+		}
+		if containsAll(rrset(dz, zone, rr.Header().Name, rr.Header().Rrtype), []dns.RR{rr}) {
+			// Replaces an existing record
+			continue
+		}
+		added++
+	}
+	if added == 0 {
+		return ""
+	}
+	if zl.MaxRecords > 0 && zoneLen(dz.All())+added > int(zl.MaxRecords) {
+		return limitRecords
+	}
+	if key != "" && kl.MaxRecords > 0 {
+		owned := 0
+		for _, l := range leases {
+			if strings.EqualFold(l.owner, key) {
+				owned++
+			}
+		}
+		if owned+added > int(kl.MaxRecords) {
+			return limitKeyRecords
+		}
+	}
+	return ""
+}
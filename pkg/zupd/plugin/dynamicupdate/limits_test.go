@@ -0,0 +1,54 @@
+package dynamicupdate
+
+import (
+	"testing"
+	"time"
+
+	"github.com/coredns/coredns/plugin/file"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	rfc1035v1alpha1 "github.com/cldmnky/ksdns/pkg/zupd/api/v1alpha1"
+)
+
+func TestLimits(t *testing.T) {
+	d := DynamicUpdate{
+		limits:    rfc1035v1alpha1.Limits{MaxRecords: 10, MaxRRsPerMessage: 2},
+		keyLimits: rfc1035v1alpha1.Limits{MaxRecords: 1},
+		limiters:  &limiters{},
+	}
+	spec := rfc1035v1alpha1.ZoneSpec{Limits: &rfc1035v1alpha1.ZoneLimits{
+		Limits: rfc1035v1alpha1.Limits{MaxRecords: 2, UpdatesPerSecond: 1},
+		Keys:   []rfc1035v1alpha1.KeyLimits{{Name: "external-dns", Limits: rfc1035v1alpha1.Limits{MaxRecords: 2}}},
+	}}
+
+	zl, kl := d.limitsFor(spec, "external-dns.")
+	assert.Equal(t, rfc1035v1alpha1.Limits{MaxRecords: 2, MaxRRsPerMessage: 2, UpdatesPerSecond: 1}, zl)
+	assert.Equal(t, rfc1035v1alpha1.Limits{MaxRecords: 2}, kl)
+	_, kl = d.limitsFor(spec, "other.")
+	assert.Equal(t, rfc1035v1alpha1.Limits{MaxRecords: 1}, kl)
+
+	rr := func(s string) dns.RR {
+		r, err := dns.NewRR(s)
+		require.NoError(t, err)
+		return r
+	}
+	a1, a2, a3 := rr("a.example.org. 60 IN A 10.0.0.1"), rr("b.example.org. 60 IN A 10.0.0.2"), rr("c.example.org. 60 IN A 10.0.0.3")
+
+	// Message size and rate
+	assert.Equal(t, limitMessageRRs, d.checkRate(exampleOrgZone, spec, "", []dns.RR{a1, a2, a3}))
+	assert.Equal(t, "", d.checkRate(exampleOrgZone, spec, "", []dns.RR{a1}))
+	assert.Equal(t, limitRate, d.checkRate(exampleOrgZone, spec, "", []dns.RR{a1}))
+
+	// Record counts
+	dz := file.NewZone(exampleOrgZone, "")
+	leases := map[string]lease{}
+	assert.Equal(t, "", d.checkRecords(exampleOrgZone, spec, "other.", dz, leases, []dns.RR{a1}))
+	require.NoError(t, dz.Insert(a1))
+	leases[a1.String()] = newLease("other.", time.Minute)
+	assert.Equal(t, "", d.checkRecords(exampleOrgZone, spec, "other.", dz, leases, []dns.RR{a1}))
+	assert.Equal(t, limitKeyRecords, d.checkRecords(exampleOrgZone, spec, "other.", dz, leases, []dns.RR{a2}))
+	assert.Equal(t, "", d.checkRecords(exampleOrgZone, spec, "external-dns.", dz, leases, []dns.RR{a2}))
+	assert.Equal(t, limitRecords, d.checkRecords(exampleOrgZone, spec, "external-dns.", dz, leases, []dns.RR{a2, a3}))
+}
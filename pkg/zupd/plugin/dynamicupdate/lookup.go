@@ -0,0 +1,86 @@
+package dynamicupdate
+
+import (
+	"strings"
+
+	"github.com/coredns/coredns/plugin/file"
+	"github.com/miekg/dns"
+)
+
+// rrset returns the RRs of type t owned by name in z. The SOA and NS records at the
+// apex are not stored in the tree of a zone, so they are looked up in the apex.
+func rrset(z *file.Zone, origin, name string, t uint16) []dns.RR {
+	name = strings.ToLower(dns.Fqdn(name))
+	if name == origin {
+		switch t {
+		case dns.TypeSOA:
+			if z.Apex.SOA == nil {
+				return nil
+			}
+			return []dns.RR{z.Apex.SOA}
+		case dns.TypeNS:
+			return z.Apex.NS
+		}
+	}
+	e, ok := z.Search(name)
+	if !ok || e == nil {
+		return nil
+	}
+	return e.Type(t)
+}
+
+// nameInUse returns true if name owns at least one RR in z.
+func nameInUse(z *file.Zone, origin, name string) bool {
+	name = strings.ToLower(dns.Fqdn(name))
+	if name == origin && z.Apex.SOA != nil {
+		return true
+	}
+	e, ok := z.Search(name)
+	return ok && e != nil && !e.Empty()
+}
+
+// sameRRSet returns true if a and b hold the same RRs, ignoring TTLs and duplicates.
+func sameRRSet(a, b []dns.RR) bool {
+	return containsAll(a, b) && containsAll(b, a)
+}
+
+// containsAll returns true if every RR in b has a duplicate in a.
+func containsAll(a, b []dns.RR) bool {
+	for _, rb := range b {
+		found := false
+		for _, ra := range a {
+			if dns.IsDuplicate(ra, rb) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// removeRR removes the single RR rr from z, the other RRs in its RRset are kept. This differs
+// from z.Delete, which removes the whole RRset. The class of rr is ignored, as an update removes
+// an RR with class NONE.
+func removeRR(z *file.Zone, rr dns.RR) {
+	e, ok := z.Search(strings.ToLower(rr.Header().Name))
+	if !ok || e == nil {
+		return
+	}
+	keep := []dns.RR{}
+	for _, r := range e.Type(rr.Header().Rrtype) {
+		match := dns.Copy(rr)
+		match.Header().Class = r.Header().Class
+		if !dns.IsDuplicate(r, match) {
+			keep = append(keep, r)
+		}
+	}
+	z.Delete(rr)
+	for _, r := range keep {
+		if err := z.Insert(r); err != nil {
+			log.Errorf("Failed to insert RR %s: %s", r, err)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package dynamicupdate
 import (
 	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
 
 	rfc1035v1alpha1 "github.com/cldmnky/ksdns/pkg/zupd/api/v1alpha1"
 	"github.com/spf13/viper"
@@ -58,11 +59,22 @@ func (d *DynamicUpdate) NewManager(cfg *rest.Config) error {
 	}
 	d.Scheme = mgr.GetScheme()
 	d.Client = mgr.GetClient()
+	d.recorder = mgr.GetEventRecorderFor("zupd")
 	if err := d.SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Zone")
 		return err
 	}
 
+	if err := mgr.Add(manager.RunnableFunc(d.sweep)); err != nil {
+		setupLog.Error(err, "unable to set up lease sweeper")
+		return err
+	}
+
+	if err := mgr.Add(resigner{d: d}); err != nil {
+		setupLog.Error(err, "unable to set up zone signer")
+		return err
+	}
+
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
 		setupLog.Error(err, "unable to set up health check")
 		return err
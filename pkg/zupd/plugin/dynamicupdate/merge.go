@@ -1,33 +1,51 @@
 package dynamicupdate
 
 import (
+	"time"
+
 	"github.com/coredns/coredns/plugin/file"
+	"github.com/miekg/dns"
+
+	rfc1035v1alpha1 "github.com/cldmnky/ksdns/pkg/zupd/api/v1alpha1"
 )
 
 // Merge the dynamic zone with the static zone. Return a new zone.
 func (d DynamicUpdate) Merge(origin string) *file.Zone {
-	z, ok := d.Zones.Z[origin]
-	if !ok || z == nil {
+	static := d.Zones.static(origin)
+	dz, _ := d.Zones.dynamic(origin)
+	if dz == nil {
 		return nil
 	}
+	dz.RLock()
+	defer dz.RUnlock()
+	return mergeWith(origin, static, dz)
+}
 
-	dz, ok := d.Zones.DynamicZones[origin]
-	if !ok || dz == nil {
+// mergeWith merges the dynamic RRs of dz with the static zone. The caller must hold a lock on dz.
+func mergeWith(origin string, static staticZone, dz *file.Zone) *file.Zone {
+	start := time.Now()
+	z := static.zone
+	if z == nil {
 		return nil
 	}
-	// Lock the zones
+	// Lock the static zone
 	z.RLock()
 	defer z.RUnlock()
-	dz.RLock()
-	defer dz.RUnlock()
 
 	// Make a copy of the base zone
 	newZone := z.Copy()
-	for _, e := range z.All() {
-		for _, rr := range e.All() {
-			if err := newZone.Insert(rr); err != nil {
-				log.Errorf("Failed to insert RR %s: %s", rr, err)
-			}
+	rrs := []dns.RR{}
+	if static.spec.GetMode() == rfc1035v1alpha1.ZoneModeDynamicOnly {
+		// Only the apex and the glue of the static zone are served
+		rrs = glue(z, origin)
+	} else {
+		for _, e := range z.All() {
+			rrs = append(rrs, e.All()...)
+		}
+	}
+	for _, rr := range rrs {
+		if err := newZone.Insert(rr); err != nil {
+			log.Errorf("Failed to insert RR %s: %s", rr, err)
 		}
 	}
 
@@ -39,5 +57,6 @@ func (d DynamicUpdate) Merge(origin string) *file.Zone {
 			}
 		}
 	}
+	mergeDuration.WithLabelValues(origin).Observe(time.Since(start).Seconds())
 	return newZone
 }
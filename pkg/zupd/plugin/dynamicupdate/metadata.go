@@ -0,0 +1,66 @@
+package dynamicupdate
+
+import (
+	"context"
+	"strings"
+
+	"github.com/coredns/coredns/plugin/metadata"
+	"github.com/coredns/coredns/request"
+	"github.com/miekg/dns"
+)
+
+const (
+	tsigKeyLabel       = "dynamicupdate/tsigkey"
+	tsigAlgorithmLabel = "dynamicupdate/tsigalgorithm"
+)
+
+// Metadata implements the metadata.Provider interface. The tsig plugin strips the TSIG RR from
+// the request before it reaches us, so the name of the key is recorded here, as the metadata
+// plugin runs before tsig.
+func (d *DynamicUpdate) Metadata(ctx context.Context, state request.Request) context.Context {
+	key, algorithm := "", ""
+	if t := state.Req.IsTsig(); t != nil {
+		key, algorithm = t.Hdr.Name, t.Algorithm
+	}
+	metadata.SetValueFunc(ctx, tsigKeyLabel, func() string { return key })
+	metadata.SetValueFunc(ctx, tsigAlgorithmLabel, func() string { return algorithm })
+	return ctx
+}
+
+// tsigKeyName returns the name of the TSIG key r was signed with, or an empty string.
+func tsigKeyName(ctx context.Context, r *dns.Msg) string {
+	if t := r.IsTsig(); t != nil {
+		return t.Hdr.Name
+	}
+	if f := metadata.ValueFunc(ctx, tsigKeyLabel); f != nil {
+		return f()
+	}
+	return ""
+}
+
+// tsigAlgorithm returns the algorithm of the TSIG key r was signed with, or an empty string.
+func tsigAlgorithm(ctx context.Context, r *dns.Msg) string {
+	if t := r.IsTsig(); t != nil {
+		return t.Algorithm
+	}
+	if f := metadata.ValueFunc(ctx, tsigAlgorithmLabel); f != nil {
+		return f()
+	}
+	return ""
+}
+
+// checkTSIG returns true if an update signed with key and algorithm is allowed: any update if
+// TSIG is not required, otherwise a signed update, with one of the required algorithms if any.
+// The signature itself is verified by the tsig plugin.
+func (d *DynamicUpdate) checkTSIG(key, algorithm string) bool {
+	if !d.requireTSIG {
+		return true
+	}
+	if key == "" {
+		return false
+	}
+	if len(d.tsigAlgorithms) == 0 {
+		return true
+	}
+	return d.tsigAlgorithms[strings.ToLower(dns.Fqdn(algorithm))]
+}
@@ -0,0 +1,34 @@
+package dynamicupdate
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckTSIG(t *testing.T) {
+	d := DynamicUpdate{}
+	assert.True(t, d.checkTSIG("", ""))
+
+	d.requireTSIG = true
+	assert.False(t, d.checkTSIG("", ""))
+	assert.True(t, d.checkTSIG("external-dns.", dns.HmacSHA1))
+
+	d.tsigAlgorithms = map[string]bool{dns.HmacSHA256: true, dns.HmacSHA512: true}
+	assert.True(t, d.checkTSIG("external-dns.", dns.HmacSHA256))
+	assert.True(t, d.checkTSIG("external-dns.", "HMAC-SHA512"))
+	assert.False(t, d.checkTSIG("external-dns.", dns.HmacSHA1))
+	assert.False(t, d.checkTSIG("", dns.HmacSHA256))
+}
+
+func TestTSIGAlgorithm(t *testing.T) {
+	m := new(dns.Msg)
+	m.SetUpdate(exampleOrgZone)
+	assert.Equal(t, "", tsigAlgorithm(context.Background(), m))
+	m.SetTsig("external-dns.", dns.HmacSHA512, 300, time.Now().Unix())
+	assert.Equal(t, dns.HmacSHA512, tsigAlgorithm(context.Background(), m))
+	assert.Equal(t, "external-dns.", tsigKeyName(context.Background(), m))
+}
@@ -2,17 +2,138 @@ package dynamicupdate
 
 import (
 	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/file"
+	"github.com/coredns/coredns/plugin/file/tree"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 )
 
-// requestCount exports a prometheus metric that is incremented every time a query is seen by the plugin.
-var requestCount = promauto.NewCounterVec(prometheus.CounterOpts{
-	Namespace: plugin.Namespace,
-	Subsystem: "dynamicupdate",
-	Name:      "request_count_total",
-	Help:      "Counter of requests made.",
-}, []string{"server"})
+var (
+	// requestCount exports a prometheus metric that is incremented every time a query is seen by the plugin.
+	requestCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "dynamicupdate",
+		Name:      "request_count_total",
+		Help:      "Counter of requests made.",
+	}, []string{"server"})
 
-//var once sync.Once
+	// updateCount counts the RRs in the update section of UPDATE messages, by the operation
+	// they requested and the rcode the message was answered with.
+	updateCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "dynamicupdate",
+		Name:      "updates_total",
+		Help:      "Counter of dynamic update operations by zone, operation, rcode and TSIG key.",
+	}, []string{"server", "zone", "operation", "rcode", "key"})
+
+	// prerequisiteFailureCount counts UPDATE messages rejected because a prerequisite was not met.
+	prerequisiteFailureCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "dynamicupdate",
+		Name:      "prerequisite_failures_total",
+		Help:      "Counter of dynamic updates rejected because of failed prerequisites.",
+	}, []string{"server", "zone", "rcode"})
+
+	// limitRejectionCount counts UPDATE messages refused because a limit was exceeded.
+	limitRejectionCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "dynamicupdate",
+		Name:      "limit_rejections_total",
+		Help:      "Counter of dynamic updates refused because a limit was exceeded.",
+	}, []string{"server", "zone", "key", "limit"})
+
+	// apiWriteDuration is the time it takes to persist the dynamic records of a zone in the API server.
+	apiWriteDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "dynamicupdate",
+		Name:      "api_write_duration_seconds",
+		Buckets:   plugin.TimeBuckets,
+		Help:      "Histogram of the time (in seconds) each zone status write to the API server took.",
+	}, []string{"zone"})
+
+	// apiWriteConflictCount counts the zone status writes that failed with a conflict.
+	apiWriteConflictCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "dynamicupdate",
+		Name:      "api_write_conflicts_total",
+		Help:      "Counter of zone status writes to the API server that failed with a conflict.",
+	}, []string{"zone"})
+
+	// recordCount is the number of records in a zone, by where they come from (static or dynamic).
+	recordCount = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "dynamicupdate",
+		Name:      "records",
+		Help:      "Number of records in a zone, by source.",
+	}, []string{"zone", "source"})
+
+	// serialGauge is the current SOA serial of a zone.
+	serialGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "dynamicupdate",
+		Name:      "soa_serial",
+		Help:      "The current SOA serial of a zone.",
+	}, []string{"zone"})
+
+	// mergeDuration is the time it takes to merge the dynamic records of a zone onto the static zone.
+	mergeDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "dynamicupdate",
+		Name:      "merge_duration_seconds",
+		Buckets:   plugin.TimeBuckets,
+		Help:      "Histogram of the time (in seconds) each merge of the static and dynamic zone took.",
+	}, []string{"zone"})
+
+	// signFailureCount counts the failures to sign a zone.
+	signFailureCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "dynamicupdate",
+		Name:      "sign_failures_total",
+		Help:      "Counter of failures to sign a zone.",
+	}, []string{"zone"})
+
+	// transferCount counts the zone transfers served.
+	transferCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "dynamicupdate",
+		Name:      "transfers_total",
+		Help:      "Counter of zone transfers served.",
+	}, []string{"zone"})
+)
+
+// Sources used in the recordCount metric.
+const (
+	sourceStatic  = "static"
+	sourceDynamic = "dynamic"
+)
+
+// setZoneMetrics sets the record count and serial gauges of zone name from the static zone z and
+// the dynamic zone dz.
+func setZoneMetrics(name string, z, dz *file.Zone) {
+	if z != nil {
+		recordCount.WithLabelValues(name, sourceStatic).Set(float64(zoneLen(z.All())))
+		if z.Apex.SOA != nil {
+			serialGauge.WithLabelValues(name).Set(float64(z.Apex.SOA.Serial))
+		}
+	}
+	if dz != nil {
+		recordCount.WithLabelValues(name, sourceDynamic).Set(float64(zoneLen(dz.All())))
+	}
+}
+
+// deleteZoneMetrics removes the gauges of a zone that is no longer served.
+func deleteZoneMetrics(name string) {
+	recordCount.DeleteLabelValues(name, sourceStatic)
+	recordCount.DeleteLabelValues(name, sourceDynamic)
+	serialGauge.DeleteLabelValues(name)
+}
+
+// zoneLen returns the number of RRs in the tree of a zone, apex records are not counted.
+func zoneLen(elems []*tree.Elem) int {
+	n := 0
+	for _, e := range elems {
+		n += len(e.All())
+	}
+	return n
+}
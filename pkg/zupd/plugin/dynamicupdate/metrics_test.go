@@ -0,0 +1,49 @@
+package dynamicupdate
+
+import (
+	"context"
+	"testing"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/coredns/coredns/request"
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpdateMetrics(t *testing.T) {
+	d := newTestUpdate(t, 0, newTestZoneObject())
+	updates := func(operation string, rcode int) float64 {
+		return testutil.ToFloat64(updateCount.WithLabelValues("", exampleOrgZone, operation, dns.RcodeToString[rcode], ""))
+	}
+	failures := func(rcode int) float64 {
+		return testutil.ToFloat64(prerequisiteFailureCount.WithLabelValues("", exampleOrgZone, dns.RcodeToString[rcode]))
+	}
+	a, err := dns.NewRR("metrics.example.org. 60 IN A 10.0.0.1")
+	require.NoError(t, err)
+
+	// An accepted update counts each of its operations
+	inserts, removes := updates("insert", dns.RcodeSuccess), updates("remove", dns.RcodeSuccess)
+	require.Equal(t, dns.RcodeSuccess, update(t, d, []dns.RR{a}, nil))
+	assert.Equal(t, inserts+1, updates("insert", dns.RcodeSuccess))
+	require.Equal(t, dns.RcodeSuccess, update(t, d, nil, []dns.RR{a}))
+	assert.Equal(t, removes+1, updates("remove", dns.RcodeSuccess))
+
+	// A failed prerequisite counts the failure and the operations with the rcode of the answer
+	yxdomain, rejected := failures(dns.RcodeYXDomain), updates("insert", dns.RcodeYXDomain)
+	m := new(dns.Msg)
+	m.SetUpdate(exampleOrgZone)
+	mail, err := dns.NewRR("mail.example.org. 0 IN A 127.0.0.1")
+	require.NoError(t, err)
+	m.NameNotUsed([]dns.RR{mail})
+	m.Insert([]dns.RR{a})
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	rcode, err := d.serveUpdate(context.Background(), request.Request{W: rec, Req: m}, exampleOrgZone, d.Zones.DynamicZones[exampleOrgZone])
+	require.NoError(t, err)
+	assert.Equal(t, dns.RcodeYXDomain, rcode)
+	assert.Equal(t, yxdomain+1, failures(dns.RcodeYXDomain))
+	assert.Equal(t, rejected+1, updates("insert", dns.RcodeYXDomain))
+	assert.Equal(t, inserts+1, updates("insert", dns.RcodeSuccess))
+}
@@ -0,0 +1,248 @@
+package dynamicupdate
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/coredns/coredns/plugin/file"
+	"github.com/miekg/dns"
+
+	rfc1035v1alpha1 "github.com/cldmnky/ksdns/pkg/zupd/api/v1alpha1"
+)
+
+// nsec3Chain is the NSEC3 chain of a signed zone (RFC 5155). A file.Zone can not hold NSEC3 and
+// NSEC3PARAM records, so the chain is kept beside the signed zone and the denial of existence
+// proofs are added to the responses.
+type nsec3Chain struct {
+	origin string
+	param  *dns.NSEC3PARAM
+	// records holds the NSEC3 records in hash order, and hashes their hashes.
+	records []*dns.NSEC3
+	hashes  []string
+	// sigs holds the RRSIGs of the NSEC3PARAM and NSEC3 RRsets, by owner name.
+	sigs map[string][]dns.RR
+}
+
+// newNSEC3Chain returns the NSEC3 chain of names, the authoritative names of signed, with the
+// NSEC3 records of the empty non-terminals between them and origin. The records are not signed.
+func newNSEC3Chain(signed *file.Zone, origin string, names []string, params rfc1035v1alpha1.NSEC3, ttl uint32) *nsec3Chain {
+	c := &nsec3Chain{
+		origin: origin,
+		param: &dns.NSEC3PARAM{
+			Hdr:        dns.RR_Header{Name: origin, Rrtype: dns.TypeNSEC3PARAM, Class: dns.ClassINET},
+			Hash:       dns.SHA1,
+			Iterations: params.Iterations,
+			SaltLength: uint8(len(params.Salt) / 2),
+			Salt:       strings.ToUpper(params.Salt),
+		},
+		sigs: map[string][]dns.RR{},
+	}
+
+	types := map[string][]uint16{}
+	for _, name := range names {
+		t := typesAt(signed, origin, name)
+		if name == origin {
+			t = append(t, dns.TypeNSEC3PARAM)
+		}
+		if name == origin || len(rrset(signed, origin, name, dns.TypeNS)) == 0 || len(rrset(signed, origin, name, dns.TypeDS)) > 0 {
+			// Unsigned delegations have no RRSIG
+			t = append(t, dns.TypeRRSIG)
+		}
+		sort.Slice(t, func(i, j int) bool { return t[i] < t[j] })
+		types[name] = t
+		// Empty non-terminals have an NSEC3 record without types
+		for parent := parentName(name); parent != "" && parent != origin && dns.IsSubDomain(origin, parent); parent = parentName(parent) {
+			if _, ok := types[parent]; !ok {
+				types[parent] = []uint16{}
+			}
+		}
+	}
+
+	byHash := map[string][]uint16{}
+	for name, t := range types {
+		h := c.hash(name)
+		c.hashes = append(c.hashes, h)
+		byHash[h] = t
+	}
+	sort.Strings(c.hashes)
+	for i, h := range c.hashes {
+		c.records = append(c.records, &dns.NSEC3{
+			Hdr:        dns.RR_Header{Name: strings.ToLower(h) + "." + origin, Rrtype: dns.TypeNSEC3, Class: dns.ClassINET, Ttl: ttl},
+			Hash:       dns.SHA1,
+			Iterations: c.param.Iterations,
+			SaltLength: c.param.SaltLength,
+			Salt:       c.param.Salt,
+			HashLength: 20,
+			NextDomain: c.hashes[(i+1)%len(c.hashes)],
+			TypeBitMap: byHash[h],
+		})
+	}
+	return c
+}
+
+// parentName returns the parent of name, or an empty string for the root.
+func parentName(name string) string {
+	off, end := dns.NextLabel(name, 0)
+	if end {
+		return ""
+	}
+	return name[off:]
+}
+
+// hash returns the hash of name, in upper case base32hex.
+func (c *nsec3Chain) hash(name string) string {
+	return dns.HashName(name, c.param.Hash, c.param.Iterations, c.param.Salt)
+}
+
+// match returns the NSEC3 record of name, nil if name does not exist.
+func (c *nsec3Chain) match(name string) *dns.NSEC3 {
+	h := c.hash(name)
+	i := sort.SearchStrings(c.hashes, h)
+	if i < len(c.hashes) && c.hashes[i] == h {
+		return c.records[i]
+	}
+	return nil
+}
+
+// cover returns the NSEC3 record covering name, which does not exist.
+func (c *nsec3Chain) cover(name string) *dns.NSEC3 {
+	h := c.hash(name)
+	i := sort.Search(len(c.hashes), func(i int) bool { return c.hashes[i] > h })
+	if i == 0 {
+		// The last record covers the hashes before the first one
+		i = len(c.hashes)
+	}
+	return c.records[i-1]
+}
+
+// closestEncloser returns the closest encloser of name, the longest existing ancestor of name,
+// and the next closer name, the name one label longer than the closest encloser.
+func (c *nsec3Chain) closestEncloser(name string) (encloser, next string) {
+	next = name
+	for encloser = name; encloser != c.origin && c.match(encloser) == nil; encloser = parentName(encloser) {
+		next = encloser
+	}
+	return encloser, next
+}
+
+// proof returns the NSEC3 records proving the response to a query for qname and qtype, with
+// their RRSIGs. answer holds the answer of the response and ns its authority section.
+func (c *nsec3Chain) proof(result file.Result, qname string, answer, ns []dns.RR) []dns.RR {
+	records := []*dns.NSEC3{}
+	switch result {
+	case file.NameError:
+		// RFC 5155, section 7.2.2
+		encloser, next := c.closestEncloser(qname)
+		records = append(records, c.match(encloser), c.cover(next), c.cover("*."+encloser))
+	case file.NoData:
+		// RFC 5155, section 7.2.3 to 7.2.5
+		if nsec3 := c.match(qname); nsec3 != nil {
+			records = append(records, nsec3)
+			break
+		}
+		encloser, next := c.closestEncloser(qname)
+		records = append(records, c.match(encloser), c.cover(next))
+		if nsec3 := c.match("*." + encloser); nsec3 != nil {
+			records = append(records, nsec3)
+		}
+	case file.Delegation:
+		// RFC 5155, section 7.2.7
+		for _, rr := range ns {
+			if rr.Header().Rrtype == dns.TypeDS {
+				return nil
+			}
+		}
+		for _, rr := range ns {
+			if rr.Header().Rrtype == dns.TypeNS {
+				if nsec3 := c.match(rr.Header().Name); nsec3 != nil {
+					records = append(records, nsec3)
+				}
+				break
+			}
+		}
+	case file.Success:
+		// RFC 5155, section 7.2.6: a wildcard answer proves the next closer name does not exist
+		for _, rr := range answer {
+			sig, ok := rr.(*dns.RRSIG)
+			if !ok || int(sig.Labels) >= dns.CountLabel(sig.Hdr.Name) {
+				continue
+			}
+			labels := dns.Split(sig.Hdr.Name)
+			next := sig.Hdr.Name[labels[len(labels)-int(sig.Labels)-1]:]
+			records = append(records, c.cover(next))
+		}
+	}
+
+	rrs := []dns.RR{}
+	seen := map[string]bool{}
+	for _, nsec3 := range records {
+		if nsec3 == nil || seen[nsec3.Hdr.Name] {
+			continue
+		}
+		seen[nsec3.Hdr.Name] = true
+		rrs = append(rrs, nsec3)
+		rrs = append(rrs, c.sigs[nsec3.Hdr.Name]...)
+	}
+	return rrs
+}
+
+// lookup answers the queries for the NSEC3PARAM RRset and the NSEC3 records, which are not in
+// the signed zone. It returns false for other queries.
+func (c *nsec3Chain) lookup(qname string, qtype uint16, do bool) ([]dns.RR, bool) {
+	var rrs []dns.RR
+	switch {
+	case qtype == dns.TypeNSEC3PARAM && strings.EqualFold(qname, c.origin):
+		rrs = []dns.RR{c.param}
+	case qtype == dns.TypeNSEC3:
+		for _, nsec3 := range c.records {
+			if strings.EqualFold(nsec3.Hdr.Name, qname) {
+				rrs = []dns.RR{nsec3}
+			}
+		}
+		if rrs == nil {
+			return nil, false
+		}
+	default:
+		return nil, false
+	}
+	if do {
+		rrs = append(rrs, c.sigs[rrs[0].Header().Name]...)
+	}
+	return rrs, true
+}
+
+// all returns the NSEC3PARAM and NSEC3 records with their RRSIGs, for a zone transfer.
+func (c *nsec3Chain) all() []dns.RR {
+	rrs := []dns.RR{c.param}
+	rrs = append(rrs, c.sigs[c.origin]...)
+	for _, nsec3 := range c.records {
+		rrs = append(rrs, nsec3)
+		rrs = append(rrs, c.sigs[nsec3.Hdr.Name]...)
+	}
+	return rrs
+}
+
+// transferChain adds the records of c to the transfer of the signed zone in ch, before the
+// closing SOA. A transfer of a single message, an IXFR answered with the SOA, is unchanged.
+func transferChain(ch <-chan []dns.RR, c *nsec3Chain) <-chan []dns.RR {
+	out := make(chan []dns.RR)
+	go func() {
+		defer close(out)
+		var last []dns.RR
+		n := 0
+		for rrs := range ch {
+			if last != nil {
+				out <- last
+			}
+			last = rrs
+			n++
+		}
+		if n > 1 {
+			out <- c.all()
+		}
+		if last != nil {
+			out <- last
+		}
+	}()
+	return out
+}
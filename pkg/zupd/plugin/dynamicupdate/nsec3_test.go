@@ -0,0 +1,186 @@
+package dynamicupdate
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coredns/coredns/plugin/file"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+
+	rfc1035v1alpha1 "github.com/cldmnky/ksdns/pkg/zupd/api/v1alpha1"
+)
+
+// signNSEC3 returns example.org, with a delegation, an empty non-terminal and a wildcard, signed
+// with NSEC3, and its ZSK.
+func signNSEC3(t *testing.T) (*signedState, *dns.DNSKEY) {
+	zskPub, zskPriv := testKey(t, 256)
+	keys, err := readKeys(&corev1.Secret{Data: map[string][]byte{
+		"zsk.key":     []byte(zskPub),
+		"zsk.private": []byte(zskPriv),
+	}})
+	require.NoError(t, err)
+	zone, err := file.Parse(strings.NewReader(exampleOrg+`sub 3600 NS ns1.sub.example.org.
+ns1.sub 3600 A 10.0.0.53
+a.b 3600 A 10.0.0.1
+*.wild 3600 A 10.0.0.2
+`), exampleOrgZone, "stdin", 0)
+	require.NoError(t, err)
+	signed, err := signZone(zone, exampleOrgZone, keys, &rfc1035v1alpha1.NSEC3{Salt: "aabb"}, time.Now())
+	require.NoError(t, err)
+	require.NotNil(t, signed.nsec3)
+	return signed, keys[0].dnskey
+}
+
+func TestNSEC3Chain(t *testing.T) {
+	signed, zsk := signNSEC3(t)
+	chain := signed.nsec3
+
+	// No NSEC records are built
+	assert.Empty(t, rrset(signed.zone, exampleOrgZone, exampleOrgZone, dns.TypeNSEC))
+
+	// The chain holds the authoritative names and the empty non-terminals, not the glue
+	for _, name := range []string{"example.org.", "mail.example.org.", "sub.example.org.", "a.b.example.org.", "b.example.org.", "*.wild.example.org.", "wild.example.org."} {
+		assert.NotNil(t, chain.match(name), name)
+	}
+	assert.Nil(t, chain.match("ns1.sub.example.org."))
+	assert.Empty(t, chain.match("b.example.org.").TypeBitMap)
+	assert.Equal(t, []uint16{dns.TypeNS}, chain.match("sub.example.org.").TypeBitMap)
+	assert.Contains(t, chain.match(exampleOrgZone).TypeBitMap, dns.TypeNSEC3PARAM)
+
+	// The chain is closed and every record is signed
+	first, next, seen := chain.records[0], chain.records[0], 0
+	for {
+		sigs := chain.sigs[next.Hdr.Name]
+		require.Len(t, sigs, 1)
+		assert.NoError(t, sigs[0].(*dns.RRSIG).Verify(zsk, []dns.RR{next}))
+		i := sort.SearchStrings(chain.hashes, next.NextDomain)
+		require.Less(t, i, len(chain.hashes))
+		require.Equal(t, next.NextDomain, chain.hashes[i])
+		next = chain.records[i]
+		seen++
+		if next == first || seen > len(chain.records) {
+			break
+		}
+	}
+	assert.Equal(t, len(chain.records), seen)
+	require.Len(t, chain.sigs[exampleOrgZone], 1)
+	assert.NoError(t, chain.sigs[exampleOrgZone][0].(*dns.RRSIG).Verify(zsk, []dns.RR{chain.param}))
+}
+
+func TestNSEC3Proof(t *testing.T) {
+	signed, _ := signNSEC3(t)
+	chain := signed.nsec3
+	nsec3s := func(rrs []dns.RR) []*dns.NSEC3 {
+		n := []*dns.NSEC3{}
+		for _, rr := range rrs {
+			if nsec3, ok := rr.(*dns.NSEC3); ok {
+				n = append(n, nsec3)
+			}
+		}
+		return n
+	}
+	matches := func(rrs []*dns.NSEC3, name string) bool {
+		for _, rr := range rrs {
+			if rr.Match(name) {
+				return true
+			}
+		}
+		return false
+	}
+	covers := func(rrs []*dns.NSEC3, name string) bool {
+		for _, rr := range rrs {
+			if rr.Cover(name) {
+				return true
+			}
+		}
+		return false
+	}
+
+	// Name error: the closest encloser, the next closer name and the wildcard
+	proof := nsec3s(chain.proof(file.NameError, "x.y.example.org.", nil, nil))
+	assert.True(t, matches(proof, "example.org."))
+	assert.True(t, covers(proof, "y.example.org."))
+	assert.True(t, covers(proof, "*.example.org."))
+	proof = nsec3s(chain.proof(file.NameError, "x.b.example.org.", nil, nil))
+	assert.True(t, matches(proof, "b.example.org."))
+	assert.True(t, covers(proof, "x.b.example.org."))
+
+	// No data: the name itself, also for empty non-terminals
+	proof = nsec3s(chain.proof(file.NoData, "mail.example.org.", nil, nil))
+	require.Len(t, proof, 1)
+	assert.True(t, matches(proof, "mail.example.org."))
+	proof = nsec3s(chain.proof(file.NoData, "b.example.org.", nil, nil))
+	assert.True(t, matches(proof, "b.example.org."))
+
+	// Wildcard no data: the closest encloser, the next closer name and the wildcard
+	proof = nsec3s(chain.proof(file.NoData, "x.wild.example.org.", nil, nil))
+	assert.True(t, matches(proof, "wild.example.org."))
+	assert.True(t, covers(proof, "x.wild.example.org."))
+	assert.True(t, matches(proof, "*.wild.example.org."))
+
+	// Unsigned delegation: the delegation has no DS
+	ns, err := dns.NewRR("sub.example.org. 3600 IN NS ns1.sub.example.org.")
+	require.NoError(t, err)
+	proof = nsec3s(chain.proof(file.Delegation, "www.sub.example.org.", nil, []dns.RR{ns}))
+	require.Len(t, proof, 1)
+	assert.True(t, matches(proof, "sub.example.org."))
+
+	// Wildcard answer: the next closer name
+	sig := &dns.RRSIG{Hdr: dns.RR_Header{Name: "x.wild.example.org.", Rrtype: dns.TypeRRSIG}, TypeCovered: dns.TypeA, Labels: 3}
+	proof = nsec3s(chain.proof(file.Success, "x.wild.example.org.", []dns.RR{sig}, nil))
+	require.Len(t, proof, 1)
+	assert.True(t, covers(proof, "x.wild.example.org."))
+}
+
+func TestNSEC3Serve(t *testing.T) {
+	signed, _ := signNSEC3(t)
+	d := newTestUpdate(t, 0)
+	d.signed = &signedZones{}
+	d.signed.set(exampleOrgZone, signed)
+	query := func(name string, qtype uint16) *dns.Msg {
+		m := new(dns.Msg)
+		m.SetQuestion(name, qtype)
+		m.SetEdns0(4096, true)
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		_, err := d.ServeDNS(context.Background(), rec, m)
+		require.NoError(t, err)
+		return rec.Msg
+	}
+
+	m := query("nx.example.org.", dns.TypeA)
+	assert.Equal(t, dns.RcodeNameError, m.Rcode)
+	assert.NotEmpty(t, rrsOfType(m.Ns, dns.TypeNSEC3))
+
+	m = query(exampleOrgZone, dns.TypeNSEC3PARAM)
+	require.Len(t, rrsOfType(m.Answer, dns.TypeNSEC3PARAM), 1)
+	assert.Len(t, rrsOfType(m.Answer, dns.TypeRRSIG), 1)
+
+	// The chain is transferred before the closing SOA
+	ch, err := d.Transfer(exampleOrgZone, 0)
+	require.NoError(t, err)
+	rrs := []dns.RR{}
+	for batch := range ch {
+		rrs = append(rrs, batch...)
+	}
+	assert.Len(t, rrsOfType(rrs, dns.TypeNSEC3), len(signed.nsec3.records))
+	assert.Len(t, rrsOfType(rrs, dns.TypeNSEC3PARAM), 1)
+	assert.Equal(t, dns.TypeSOA, rrs[len(rrs)-1].Header().Rrtype)
+}
+
+func rrsOfType(rrs []dns.RR, t uint16) []dns.RR {
+	of := []dns.RR{}
+	for _, rr := range rrs {
+		if rr.Header().Rrtype == t {
+			of = append(of, rr)
+		}
+	}
+	return of
+}
@@ -0,0 +1,107 @@
+package dynamicupdate
+
+import (
+	"strings"
+
+	"github.com/coredns/coredns/plugin/file"
+	"github.com/miekg/dns"
+
+	rfc1035v1alpha1 "github.com/cldmnky/ksdns/pkg/zupd/api/v1alpha1"
+)
+
+// updatableTypes are the types that may be added or removed by a dynamic update.
+var updatableTypes = map[uint16]bool{
+	dns.TypeTXT:   true,
+	dns.TypeCNAME: true,
+	dns.TypeA:     true,
+	dns.TypeAAAA:  true,
+	dns.TypeSRV:   true,
+}
+
+// checkUpdate checks the update section of an update against the static zone z before any of
+// it is applied, so an update is either applied as a whole or not at all. It returns
+// dns.RcodeSuccess if the update may be applied, otherwise the rcode to answer with.
+func checkUpdate(z *file.Zone, origin string, mode rfc1035v1alpha1.ZoneMode, updates []dns.RR) int {
+	for _, rr := range updates {
+		h := rr.Header()
+		if !updatableTypes[h.Rrtype] {
+			return dns.RcodeRefused
+		}
+		if mode != rfc1035v1alpha1.ZoneModeProtectStatic || z == nil {
+			continue
+		}
+		if nameInUse(z, origin, h.Name) {
+			return dns.RcodeRefused
+		}
+	}
+	return dns.RcodeSuccess
+}
+
+// glue returns the address records in z for the name servers of the apex.
+func glue(z *file.Zone, origin string) []dns.RR {
+	rrs := []dns.RR{}
+	for _, rr := range z.Apex.NS {
+		ns := rr.(*dns.NS).Ns
+		if !dns.IsSubDomain(origin, ns) {
+			continue
+		}
+		rrs = append(rrs, rrset(z, origin, ns, dns.TypeA)...)
+		rrs = append(rrs, rrset(z, origin, ns, dns.TypeAAAA)...)
+	}
+	return rrs
+}
+
+// checkZone checks that every name in the update section is inside the zone and not at or below
+// a delegation point in view, as required by RFC 2136, section 3.4.1.3.
+func checkZone(view *file.Zone, origin string, updates []dns.RR) int {
+	for _, rr := range updates {
+		name := rr.Header().Name
+		if !dns.IsSubDomain(origin, name) || delegated(view, origin, name) {
+			return dns.RcodeNotZone
+		}
+	}
+	return dns.RcodeSuccess
+}
+
+// delegated returns true if name is at or below a delegation point in z.
+func delegated(z *file.Zone, origin, name string) bool {
+	if z == nil {
+		return false
+	}
+	name = strings.ToLower(dns.Fqdn(name))
+	for off, end := 0, false; !end && name != origin; off, end = dns.NextLabel(name, off) {
+		n := name[off:]
+		if n == origin {
+			break
+		}
+		if len(rrset(z, origin, n, dns.TypeNS)) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// conflicts returns true if adding rr to view violates CNAME exclusivity: a CNAME can not be
+// added to a name that holds other data, and no other data can be added to a name that holds a
+// CNAME. Such RRs are silently ignored, as described in RFC 2136, section 3.4.2.2.
+func conflicts(view *file.Zone, origin string, rr dns.RR) bool {
+	h := rr.Header()
+	name := strings.ToLower(h.Name)
+	if h.Rrtype != dns.TypeCNAME {
+		return len(rrset(view, origin, name, dns.TypeCNAME)) > 0
+	}
+	if name == origin {
+		// The apex always holds the SOA and NS records
+		return true
+	}
+	e, ok := view.Search(name)
+	if !ok || e == nil {
+		return false
+	}
+	for _, t := range e.Types() {
+		if t != dns.TypeCNAME && t != dns.TypeRRSIG && t != dns.TypeNSEC {
+			return true
+		}
+	}
+	return false
+}
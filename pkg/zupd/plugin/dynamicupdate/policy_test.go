@@ -0,0 +1,108 @@
+package dynamicupdate
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/coredns/coredns/plugin/file"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	rfc1035v1alpha1 "github.com/cldmnky/ksdns/pkg/zupd/api/v1alpha1"
+)
+
+func TestCheckUpdate(t *testing.T) {
+	zone, err := file.Parse(strings.NewReader(exampleOrg), exampleOrgZone, "stdin", 0)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name     string
+		mode     rfc1035v1alpha1.ZoneMode
+		rr       string
+		expected int
+	}{
+		{"new name", rfc1035v1alpha1.ZoneModeProtectStatic, "new.example.org. 300 IN A 10.0.0.1", dns.RcodeSuccess},
+		{"static name", rfc1035v1alpha1.ZoneModeProtectStatic, "mail.example.org. 300 IN A 10.0.0.1", dns.RcodeRefused},
+		{"static apex", rfc1035v1alpha1.ZoneModeProtectStatic, "example.org. 300 IN TXT \"foo\"", dns.RcodeRefused},
+		{"static name in overlay", rfc1035v1alpha1.ZoneModeOverlay, "mail.example.org. 300 IN A 10.0.0.1", dns.RcodeSuccess},
+		{"static name in dynamic-only", rfc1035v1alpha1.ZoneModeDynamicOnly, "mail.example.org. 300 IN A 10.0.0.1", dns.RcodeSuccess},
+		{"type not allowed", rfc1035v1alpha1.ZoneModeOverlay, "new.example.org. 300 IN MX 10 mail.example.org.", dns.RcodeRefused},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rr, err := dns.NewRR(tc.rr)
+			require.NoError(t, err)
+			assert.Equal(t, dns.RcodeToString[tc.expected], dns.RcodeToString[checkUpdate(zone, exampleOrgZone, tc.mode, []dns.RR{rr})])
+		})
+	}
+}
+
+func TestMergeDynamicOnly(t *testing.T) {
+	zone, err := file.Parse(strings.NewReader(exampleOrg), exampleOrgZone, "stdin", 0)
+	require.NoError(t, err)
+	d := DynamicUpdate{
+		Zones: &Zones{
+			Z:            map[string]*file.Zone{exampleOrgZone: zone},
+			DynamicZones: map[string]*file.Zone{exampleOrgZone: file.NewZone(exampleOrgZone, "")},
+			Specs: map[string]rfc1035v1alpha1.ZoneSpec{
+				exampleOrgZone: {Mode: rfc1035v1alpha1.ZoneModeDynamicOnly},
+			},
+		},
+	}
+	merged := d.Merge(exampleOrgZone)
+	require.NotNil(t, merged)
+	assert.NotNil(t, merged.Apex.SOA)
+	assert.NotEmpty(t, merged.Apex.NS)
+	assert.Empty(t, rrset(merged, exampleOrgZone, "mail.example.org.", dns.TypeA))
+	for _, rr := range glue(zone, exampleOrgZone) {
+		assert.NotEmpty(t, rrset(merged, exampleOrgZone, rr.Header().Name, rr.Header().Rrtype))
+	}
+}
+
+func TestCheckZone(t *testing.T) {
+	zone, err := file.Parse(strings.NewReader(exampleOrg+"sub 3600 NS ns1.sub.example.org.\n"), exampleOrgZone, "stdin", 0)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		rr       string
+		expected int
+	}{
+		{"new.example.org. 300 IN A 10.0.0.1", dns.RcodeSuccess},
+		{"example.org. 300 IN TXT \"foo\"", dns.RcodeSuccess},
+		{"www.example.com. 300 IN A 10.0.0.1", dns.RcodeNotZone},
+		{"sub.example.org. 300 IN A 10.0.0.1", dns.RcodeNotZone},
+		{"www.sub.example.org. 300 IN A 10.0.0.1", dns.RcodeNotZone},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.rr, func(t *testing.T) {
+			rr, err := dns.NewRR(tc.rr)
+			require.NoError(t, err)
+			assert.Equal(t, dns.RcodeToString[tc.expected], dns.RcodeToString[checkZone(zone, exampleOrgZone, []dns.RR{rr})])
+		})
+	}
+}
+
+func TestConflicts(t *testing.T) {
+	zone, err := file.Parse(strings.NewReader(exampleOrg), exampleOrgZone, "stdin", 0)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		rr       string
+		expected bool
+	}{
+		{"new.example.org. 300 IN CNAME example.org.", false},
+		{"new.example.org. 300 IN A 10.0.0.1", false},
+		{"mail.example.org. 300 IN CNAME example.org.", true},
+		{"example.org. 300 IN CNAME example.net.", true},
+		{"www.example.org. 300 IN A 10.0.0.1", true},
+		{"www.example.org. 300 IN CNAME mail.example.org.", false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.rr, func(t *testing.T) {
+			rr, err := dns.NewRR(tc.rr)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, conflicts(zone, exampleOrgZone, rr))
+		})
+	}
+}
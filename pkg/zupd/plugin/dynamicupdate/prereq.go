@@ -0,0 +1,70 @@
+package dynamicupdate
+
+import (
+	"strings"
+
+	"github.com/coredns/coredns/plugin/file"
+	"github.com/miekg/dns"
+)
+
+type rrsetKey struct {
+	name  string
+	rtype uint16
+}
+
+// checkPrerequisites checks the prerequisite section of an update against z, as described
+// in RFC 2136, section 3.2. It returns dns.RcodeSuccess if all prerequisites are met,
+// otherwise the rcode the update should be answered with.
+func checkPrerequisites(z *file.Zone, origin string, prereqs []dns.RR) int {
+	valueDependent := map[rrsetKey][]dns.RR{}
+	for _, rr := range prereqs {
+		h := rr.Header()
+		if h.Ttl != 0 {
+			return dns.RcodeFormatError
+		}
+		if !dns.IsSubDomain(origin, h.Name) {
+			return dns.RcodeNotZone
+		}
+		switch h.Class {
+		case dns.ClassANY:
+			if h.Rdlength != 0 {
+				return dns.RcodeFormatError
+			}
+			if h.Rrtype == dns.TypeANY {
+				// Name is in use
+				if !nameInUse(z, origin, h.Name) {
+					return dns.RcodeNameError
+				}
+			} else if len(rrset(z, origin, h.Name, h.Rrtype)) == 0 {
+				// RRset exists (value independent)
+				return dns.RcodeNXRrset
+			}
+		case dns.ClassNONE:
+			if h.Rdlength != 0 {
+				return dns.RcodeFormatError
+			}
+			if h.Rrtype == dns.TypeANY {
+				// Name is not in use
+				if nameInUse(z, origin, h.Name) {
+					return dns.RcodeYXDomain
+				}
+			} else if len(rrset(z, origin, h.Name, h.Rrtype)) != 0 {
+				// RRset does not exist
+				return dns.RcodeYXRrset
+			}
+		case dns.ClassINET:
+			// RRset exists (value dependent), these are checked once all RRs are collected.
+			k := rrsetKey{name: strings.ToLower(h.Name), rtype: h.Rrtype}
+			valueDependent[k] = append(valueDependent[k], rr)
+		default:
+			return dns.RcodeFormatError
+		}
+	}
+
+	for k, rrs := range valueDependent {
+		if !sameRRSet(rrset(z, origin, k.name, k.rtype), rrs) {
+			return dns.RcodeNXRrset
+		}
+	}
+	return dns.RcodeSuccess
+}
@@ -0,0 +1,150 @@
+package dynamicupdate
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/coredns/coredns/plugin/file"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckPrerequisites(t *testing.T) {
+	zone, err := file.Parse(strings.NewReader(exampleOrg), exampleOrgZone, "stdin", 0)
+	require.NoError(t, err)
+
+	mustRR := func(s string) dns.RR {
+		rr, err := dns.NewRR(s)
+		require.NoError(t, err)
+		return rr
+	}
+
+	testCases := []struct {
+		name     string
+		prereqs  func() []dns.RR
+		expected int
+	}{
+		{
+			name:     "no prerequisites",
+			prereqs:  func() []dns.RR { return nil },
+			expected: dns.RcodeSuccess,
+		},
+		{
+			name: "name in use",
+			prereqs: func() []dns.RR {
+				m := new(dns.Msg)
+				m.SetUpdate(exampleOrgZone)
+				m.NameUsed([]dns.RR{mustRR("mail.example.org. 0 IN A 127.0.0.1")})
+				return m.Answer
+			},
+			expected: dns.RcodeSuccess,
+		},
+		{
+			name: "name in use at the apex",
+			prereqs: func() []dns.RR {
+				m := new(dns.Msg)
+				m.SetUpdate(exampleOrgZone)
+				m.NameUsed([]dns.RR{mustRR("example.org. 0 IN A 127.0.0.1")})
+				return m.Answer
+			},
+			expected: dns.RcodeSuccess,
+		},
+		{
+			name: "name not in use",
+			prereqs: func() []dns.RR {
+				m := new(dns.Msg)
+				m.SetUpdate(exampleOrgZone)
+				m.NameUsed([]dns.RR{mustRR("nope.example.org. 0 IN A 127.0.0.1")})
+				return m.Answer
+			},
+			expected: dns.RcodeNameError,
+		},
+		{
+			name: "name must not be in use",
+			prereqs: func() []dns.RR {
+				m := new(dns.Msg)
+				m.SetUpdate(exampleOrgZone)
+				m.NameNotUsed([]dns.RR{mustRR("mail.example.org. 0 IN A 127.0.0.1")})
+				return m.Answer
+			},
+			expected: dns.RcodeYXDomain,
+		},
+		{
+			name: "rrset exists",
+			prereqs: func() []dns.RR {
+				m := new(dns.Msg)
+				m.SetUpdate(exampleOrgZone)
+				m.RRsetUsed([]dns.RR{mustRR("webapp.example.org. 0 IN A 127.0.0.1")})
+				return m.Answer
+			},
+			expected: dns.RcodeSuccess,
+		},
+		{
+			name: "rrset does not exist",
+			prereqs: func() []dns.RR {
+				m := new(dns.Msg)
+				m.SetUpdate(exampleOrgZone)
+				m.RRsetUsed([]dns.RR{mustRR("webapp.example.org. 0 IN TXT \"foo\"")})
+				return m.Answer
+			},
+			expected: dns.RcodeNXRrset,
+		},
+		{
+			name: "rrset must not exist",
+			prereqs: func() []dns.RR {
+				m := new(dns.Msg)
+				m.SetUpdate(exampleOrgZone)
+				m.RRsetNotUsed([]dns.RR{mustRR("example.org. 0 IN NS ns1.example.org.")})
+				return m.Answer
+			},
+			expected: dns.RcodeYXRrset,
+		},
+		{
+			name: "rrset exists with values",
+			prereqs: func() []dns.RR {
+				m := new(dns.Msg)
+				m.SetUpdate(exampleOrgZone)
+				m.Used([]dns.RR{
+					mustRR("webapp.example.org. 0 IN A 216.146.46.10"),
+					mustRR("webapp.example.org. 0 IN A 216.146.46.11"),
+				})
+				return m.Answer
+			},
+			expected: dns.RcodeSuccess,
+		},
+		{
+			name: "rrset exists with other values",
+			prereqs: func() []dns.RR {
+				m := new(dns.Msg)
+				m.SetUpdate(exampleOrgZone)
+				m.Used([]dns.RR{mustRR("webapp.example.org. 0 IN A 216.146.46.10")})
+				return m.Answer
+			},
+			expected: dns.RcodeNXRrset,
+		},
+		{
+			name: "name outside of the zone",
+			prereqs: func() []dns.RR {
+				m := new(dns.Msg)
+				m.SetUpdate(exampleOrgZone)
+				m.NameUsed([]dns.RR{mustRR("www.example.com. 0 IN A 127.0.0.1")})
+				return m.Answer
+			},
+			expected: dns.RcodeNotZone,
+		},
+		{
+			name: "non zero ttl",
+			prereqs: func() []dns.RR {
+				return []dns.RR{mustRR("webapp.example.org. 60 IN A 216.146.46.10")}
+			},
+			expected: dns.RcodeFormatError,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, dns.RcodeToString[tc.expected], dns.RcodeToString[checkPrerequisites(zone, exampleOrgZone, tc.prereqs())])
+		})
+	}
+}
@@ -7,7 +7,9 @@ import (
 )
 
 func (d DynamicUpdate) Reload(zoneName string, t *transfer.Transfer) error {
+	d.Zones.RLock()
 	zone, ok := d.Zones.Z[zoneName]
+	d.Zones.RUnlock()
 	if !ok || zone == nil {
 		return fmt.Errorf("zone %q not found", zoneName)
 	}
@@ -3,7 +3,9 @@ package dynamicupdate
 import (
 	"context"
 	"fmt"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/coredns/caddy"
 	"github.com/coredns/coredns/core/dnsserver"
@@ -29,7 +31,7 @@ func init() {
 }
 
 func setup(c *caddy.Controller) error {
-	d := DynamicUpdate{}
+	d := DynamicUpdate{limiters: &limiters{}, committers: &committers{}, signed: &signedZones{}}
 
 	client, err := client.New(Cfg, client.Options{
 		Scheme: scheme,
@@ -55,6 +57,9 @@ func setup(c *caddy.Controller) error {
 		m := dnsserver.GetConfig(c).Handler("prometheus")
 		if m != nil {
 			d.metrics = m.(*metrics.Metrics)
+			for _, n := range zones.Names {
+				d.metrics.AddZone(n)
+			}
 		} else {
 			return plugin.Error("prometheus plugin is required", fmt.Errorf("must be enabled in Corefile"))
 		}
@@ -64,6 +69,20 @@ func setup(c *caddy.Controller) error {
 		} else {
 			return plugin.Error("transfer plugin is required", fmt.Errorf("must be enabled in Corefile"))
 		}
+		// The TSIG key of an update is only known through the metadata plugin
+		if d.requireTSIG && dnsserver.GetConfig(c).Handler("metadata") == nil {
+			return plugin.Error("metadata plugin is required by require_tsig", fmt.Errorf("must be enabled in Corefile"))
+		}
+		return nil
+	})
+
+	c.OnStartup(func() error {
+		// sign the zones with DNSSEC enabled
+		for _, n := range zones.Names {
+			if err := d.resign(ctx, n); err != nil {
+				log.Errorf("Failed to sign zone %s: %s", n, err)
+			}
+		}
 		return nil
 	})
 
@@ -130,6 +149,8 @@ func setup(c *caddy.Controller) error {
 func (d *DynamicUpdate) initialize(c *caddy.Controller) (Zones, error) {
 	z := make(map[string]*file.Zone)
 	dz := make(map[string]*file.Zone)
+	specs := make(map[string]rfc1035v1alpha1.ZoneSpec)
+	leases := make(map[string]map[string]lease)
 	names := []string{}
 	d.Namespaces = []string{}
 
@@ -147,6 +168,93 @@ func (d *DynamicUpdate) initialize(c *caddy.Controller) (Zones, error) {
 			d.Namespaces = append(d.Namespaces, c.Val())
 		}
 		log.Debugf("Namespaces: %v", d.Namespaces)
+
+		for c.NextBlock() {
+			switch c.Val() {
+			case "history":
+				if !c.NextArg() {
+					return Zones{}, c.ArgErr()
+				}
+				n, err := strconv.Atoi(c.Val())
+				if err != nil || n < 0 {
+					return Zones{}, c.Errf("invalid history size '%s'", c.Val())
+				}
+				d.history = n
+			case "commit":
+				args := c.RemainingArgs()
+				if len(args) == 0 || len(args) > 2 {
+					return Zones{}, c.ArgErr()
+				}
+				switch args[0] {
+				case "sync":
+					if len(args) != 1 {
+						return Zones{}, c.ArgErr()
+					}
+					d.commitWindow = 0
+				case "batch":
+					d.commitWindow = defaultCommitWindow
+					if len(args) == 2 {
+						w, err := time.ParseDuration(args[1])
+						if err != nil || w <= 0 {
+							return Zones{}, c.Errf("invalid commit window '%s'", args[1])
+						}
+						d.commitWindow = w
+					}
+				default:
+					return Zones{}, c.Errf("unknown commit mode '%s'", args[0])
+				}
+			case "max_records", "key_max_records", "max_message_rrs", "key_max_message_rrs":
+				l := &d.limits
+				if strings.HasPrefix(c.Val(), "key_") {
+					l = &d.keyLimits
+				}
+				option := strings.TrimPrefix(c.Val(), "key_")
+				args := c.RemainingArgs()
+				if len(args) != 1 {
+					return Zones{}, c.ArgErr()
+				}
+				n, err := parseLimit(c, args[0])
+				if err != nil {
+					return Zones{}, err
+				}
+				if option == "max_records" {
+					l.MaxRecords = n
+				} else {
+					l.MaxRRsPerMessage = n
+				}
+			case "rate", "key_rate":
+				l := &d.limits
+				if c.Val() == "key_rate" {
+					l = &d.keyLimits
+				}
+				args := c.RemainingArgs()
+				if len(args) == 0 || len(args) > 2 {
+					return Zones{}, c.ArgErr()
+				}
+				n, err := parseLimit(c, args[0])
+				if err != nil {
+					return Zones{}, err
+				}
+				l.UpdatesPerSecond, l.Burst = n, n
+				if len(args) == 2 {
+					if l.Burst, err = parseLimit(c, args[1]); err != nil {
+						return Zones{}, err
+					}
+				}
+			case "require_tsig":
+				d.requireTSIG = true
+				d.tsigAlgorithms = map[string]bool{}
+				for _, a := range c.RemainingArgs() {
+					algorithm := strings.ToLower(dns.Fqdn(a))
+					if _, ok := tsigAlgorithms[algorithm]; !ok {
+						return Zones{}, c.Errf("unknown TSIG algorithm '%s'", a)
+					}
+					d.tsigAlgorithms[algorithm] = true
+				}
+			default:
+				return Zones{}, c.Errf("unknown property '%s'", c.Val())
+			}
+		}
 	}
 
 	for _, n := range d.Namespaces {
@@ -159,13 +267,15 @@ func (d *DynamicUpdate) initialize(c *caddy.Controller) (Zones, error) {
 		}
 		for _, zone := range zones.Items {
 			if _, ok := z[dns.Fqdn(zone.Name)]; !ok {
-				parsedZone, err := file.Parse(strings.NewReader(zone.Spec.Zone), dns.Fqdn(zone.Name), "stdin", 0)
+				parsedZone, err := file.Parse(strings.NewReader(zone.Spec.ZoneFile()), dns.Fqdn(zone.Name), "stdin", 0)
 				if err != nil {
 					log.Errorf("Failed to parse zone %s: %v", zone.Name, err)
 					continue
 				}
 				z[dns.Fqdn(zone.Name)] = parsedZone
 				dz[dns.Fqdn(zone.Name)] = file.NewZone(dns.Fqdn(zone.Name), "")
+				specs[dns.Fqdn(zone.Name)] = zone.Spec
+				leases[dns.Fqdn(zone.Name)] = make(map[string]lease)
 				names = append(names, dns.Fqdn(zone.Name))
 			}
 		}
@@ -179,9 +289,30 @@ func (d *DynamicUpdate) initialize(c *caddy.Controller) (Zones, error) {
 						continue
 					}
 					dz[dns.Fqdn(zone.Name)].Insert(newRR)
+					leases[dns.Fqdn(zone.Name)][newRR.String()] = leaseFromDynamicRR(rr)
 				}
+				raiseSerial(z[dns.Fqdn(zone.Name)], zone.Status.Serial)
+				setZoneMetrics(dns.Fqdn(zone.Name), z[dns.Fqdn(zone.Name)], dz[dns.Fqdn(zone.Name)])
 			}
 		}
 	}
-	return Zones{Z: z, Names: names, DynamicZones: dz}, nil
+	return Zones{Z: z, Names: names, DynamicZones: dz, Specs: specs, leases: leases}, nil
+}
+
+// tsigAlgorithms are the TSIG algorithms that may be required by require_tsig.
+var tsigAlgorithms = map[string]struct{}{
+	dns.HmacSHA1:   {},
+	dns.HmacSHA224: {},
+	dns.HmacSHA256: {},
+	dns.HmacSHA384: {},
+	dns.HmacSHA512: {},
+}
+
+// parseLimit parses the value of a limit in the Corefile.
+func parseLimit(c *caddy.Controller, s string) (int32, error) {
+	n, err := strconv.ParseInt(s, 10, 32)
+	if err != nil || n < 0 {
+		return 0, c.Errf("invalid limit '%s'", s)
+	}
+	return int32(n), nil
 }
@@ -0,0 +1,264 @@
+package dynamicupdate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/file"
+	"github.com/coredns/coredns/plugin/metrics"
+	"github.com/coredns/coredns/request"
+	"github.com/miekg/dns"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	rfc1035v1alpha1 "github.com/cldmnky/ksdns/pkg/zupd/api/v1alpha1"
+)
+
+// serveUpdate handles a RFC 2136 dynamic update for zone.
+func (d *DynamicUpdate) serveUpdate(ctx context.Context, state request.Request, zone string, dz *file.Zone) (rcode int, err error) {
+	r, w := state.Req, state.W
+	server, key := metrics.WithServer(ctx), tsigKeyName(ctx, state.Req)
+	var (
+		zoneObj *rfc1035v1alpha1.Zone
+		record  = newZoneUpdate(state, key)
+	)
+	defer func() {
+		for _, rr := range r.Ns {
+			updateCount.WithLabelValues(server, zone, updateType(rr.Header()), dns.RcodeToString[rcode], key).Inc()
+		}
+		record.Rcode = dns.RcodeToString[rcode]
+		d.audit(ctx, zone, zoneObj, record)
+	}()
+
+	log.Debugf("Handling dynamic update for %s", zone)
+	// The static zone and the spec are read once, before locking the dynamic zone
+	static := d.Zones.static(zone)
+	if !d.checkTSIG(key, tsigAlgorithm(ctx, r)) {
+		log.Debugf("Refusing dynamic update for %s: not signed with a required TSIG key", zone)
+		return respond(w, r, dns.RcodeRefused)
+	}
+	if limit := d.checkRate(zone, static.spec, key, r.Ns); limit != "" {
+		log.Debugf("Refusing dynamic update for %s: %s limit exceeded", zone, limit)
+		limitRejectionCount.WithLabelValues(server, zone, key, limit).Inc()
+		return respond(w, r, dns.RcodeRefused)
+	}
+	// Only insertions and removals of RRs are supported
+	for _, rr := range r.Ns {
+		if t := updateType(rr.Header()); t != "insert" && t != "remove" {
+			log.Infof("Unknown update type for %s", rr.String())
+			return respond(w, r, dns.RcodeNotImplemented)
+		}
+	}
+	lease := leaseDuration(static.spec, r)
+	c := d.committers.get(d, zone)
+	_, leases := d.Zones.dynamic(zone)
+	dz.Lock()
+	// The update is made on a copy of the dynamic RRs, which is served once it is written
+	next := c.base(dz, leases)
+	next.expire(time.Now())
+
+	// Check the prerequisites against the merged zone
+	view := mergeWith(zone, static, next.zone)
+	if rcode := checkPrerequisites(view, zone, r.Answer); rcode != dns.RcodeSuccess {
+		dz.Unlock()
+		log.Debugf("Rejecting dynamic update for %s: prerequisites not met (%s)", zone, dns.RcodeToString[rcode])
+		prerequisiteFailureCount.WithLabelValues(server, zone, dns.RcodeToString[rcode]).Inc()
+		return respond(w, r, rcode)
+	}
+
+	// Check the update before applying anything
+	rcode = checkZone(view, zone, r.Ns)
+	if rcode == dns.RcodeSuccess {
+		rcode = static.checkUpdate(zone, r.Ns)
+	}
+	if rcode != dns.RcodeSuccess {
+		dz.Unlock()
+		log.Debugf("Rejecting dynamic update for %s: %s", zone, dns.RcodeToString[rcode])
+		return respond(w, r, rcode)
+	}
+	if limit := d.checkRecords(zone, static.spec, key, next.zone, next.leases, r.Ns); limit != "" {
+		dz.Unlock()
+		log.Debugf("Refusing dynamic update for %s: %s limit exceeded", zone, limit)
+		limitRejectionCount.WithLabelValues(server, zone, key, limit).Inc()
+		return respond(w, r, dns.RcodeRefused)
+	}
+
+	for range r.Question {
+		for _, rr := range r.Ns {
+			// Get the record
+			h := rr.Header()
+			if _, ok := dns.IsDomainName(h.Name); ok {
+				switch updateType(h) {
+				case "insert":
+					if conflicts(view, zone, rr) {
+						log.Debugf("Ignoring %s, it conflicts with a CNAME", rr.String())
+						continue
+					}
+					log.Debugf("Inserting %s", rr.String())
+					if h.Rrtype == dns.TypeCNAME {
+						// A CNAME replaces the existing CNAME
+						next.zone.Delete(rr)
+						view.Delete(rr)
+					}
+					// A duplicate RR replaces the existing RR
+					removeRR(next.zone, rr)
+					removeRR(view, rr)
+					view.Insert(dns.Copy(rr))
+					if err := next.zone.Insert(dns.Copy(rr)); err != nil {
+						log.Errorf("Error inserting %s: %s", rr.String(), err.Error())
+						dz.Unlock()
+						return dns.RcodeServerFailure, nil
+					}
+					next.leases[rr.String()] = newLease(key, lease)
+				case "remove":
+					log.Infof("Removing %s", rr.String())
+					removeRR(next.zone, rr)
+					removeRR(view, rr)
+				}
+			}
+		}
+	}
+	pruneLeases(next.zone, next.leases)
+	req := c.enqueue(&record, next)
+	dz.Unlock()
+
+	// Persist the zone, the update is acknowledged once it is written
+	zoneObj, err = req.wait()
+	if errors.Is(err, errZoneNotFound) {
+		log.Debugf("Rejecting dynamic update for %s, object not found", zone)
+		return dns.RcodeRefused, nil
+	}
+	if err != nil {
+		log.Errorf("Error persisting dynamic update for %s: %s", zone, err)
+		return dns.RcodeServerFailure, nil
+	}
+
+	m := new(dns.Msg)
+	m.SetReply(r)
+	m.Authoritative = true
+	if updateLease(r) != nil {
+		// Tell the client which lease was granted
+		o := new(dns.OPT)
+		o.Hdr.Name = "."
+		o.Hdr.Rrtype = dns.TypeOPT
+		o.SetUDPSize(r.IsEdns0().UDPSize())
+		o.Option = append(o.Option, &dns.EDNS0_UL{Code: dns.EDNS0UL, Lease: uint32(lease.Seconds())})
+		m.Extra = append(m.Extra, o)
+	}
+	if err := w.WriteMsg(m); err != nil {
+		log.Errorf("Error writing response: %s", err.Error())
+		return dns.RcodeServerFailure, nil
+	}
+	// log message
+	log.Debugf("Dynamic update for %s from %s: %s", zone, state.IP(), m.String())
+	return dns.RcodeSuccess, nil
+}
+
+// checkUpdate checks the update section of an update for zone against the static zone, using
+// the mode of the zone.
+func (s staticZone) checkUpdate(zone string, updates []dns.RR) int {
+	if s.zone != nil {
+		s.zone.RLock()
+		defer s.zone.RUnlock()
+	}
+	return checkUpdate(s.zone, zone, s.spec.GetMode(), updates)
+}
+
+// persist writes the dynamic RRs of state to the status of zoneObj, with serial.
+func (d *DynamicUpdate) persist(ctx context.Context, zone string, state dynamicState, zoneObj *rfc1035v1alpha1.Zone, serial uint32) error {
+	zoneObj.Status.DynamicRRs = make([]rfc1035v1alpha1.DynamicRR, 0)
+	for _, el := range state.zone.All() {
+		for _, rr := range el.All() {
+			zoneObj.Status.DynamicRRs = append(zoneObj.Status.DynamicRRs, state.leases[rr.String()].dynamicRR(rr))
+		}
+	}
+	// set serial
+	zoneObj.Status.Serial = serial
+	// Update the zone
+	start := time.Now()
+	err := d.K8sClient.Status().Update(ctx, zoneObj)
+	apiWriteDuration.WithLabelValues(zone).Observe(time.Since(start).Seconds())
+	if err != nil {
+		if apierrors.IsConflict(err) {
+			apiWriteConflictCount.WithLabelValues(zone).Inc()
+		}
+		return fmt.Errorf("updating zone object: %w", err)
+	}
+	recordCount.WithLabelValues(zone, sourceDynamic).Set(float64(len(zoneObj.Status.DynamicRRs)))
+	return nil
+}
+
+// publish sets the SOA serial of zone to the serial written to zoneObj, serves signed, the signed
+// snapshot of the zone if it is signed, and notifies the secondaries. It must not be called with
+// the lock of a dynamic zone held.
+func (d *DynamicUpdate) publish(zone string, zoneObj *rfc1035v1alpha1.Zone, signed *signedState) error {
+	serial := zoneObj.Status.Serial
+	if !d.Zones.setSerial(zone, serial) {
+		return errZoneNotFound
+	}
+	serialGauge.WithLabelValues(zone).Set(float64(serial))
+	log.Debugf("Updated SOA serial to %d", serial)
+	if signed != nil {
+		d.signed.set(zone, signed)
+		log.Debugf("Signed zone %s", zone)
+	}
+
+	// Notify other servers
+	if d.transfer != nil {
+		log.Infof("Notifying other  qservers of update")
+		if err := d.transfer.Notify(zone); err != nil {
+			log.Errorf("Error notifying other servers: %s", err.Error())
+		}
+	}
+	return nil
+}
+
+// errZoneNotFound is returned when no Zone object exists for a zone.
+var errZoneNotFound = errors.New("zone object not found")
+
+// getZone returns the Zone object for zone from the namespaces handled by the plugin.
+func (d *DynamicUpdate) getZone(ctx context.Context, zone string) (*rfc1035v1alpha1.Zone, error) {
+	return findZone(ctx, d.K8sClient, d.Namespaces, zone)
+}
+
+// cachedZone is getZone reading from the cache of the manager, if it is running.
+func (d *DynamicUpdate) cachedZone(ctx context.Context, zone string) (*rfc1035v1alpha1.Zone, error) {
+	if d.Client == nil {
+		return d.getZone(ctx, zone)
+	}
+	return findZone(ctx, d.Client, d.Namespaces, zone)
+}
+
+// findZone returns the Zone object for zone from namespaces, read with c.
+func findZone(ctx context.Context, c client.Reader, namespaces []string, zone string) (*rfc1035v1alpha1.Zone, error) {
+	zoneObj := &rfc1035v1alpha1.Zone{}
+	for _, ns := range namespaces {
+		if err := c.Get(ctx, client.ObjectKey{
+			Namespace: ns,
+			Name:      strings.TrimSuffix(zone, "."),
+		}, zoneObj); err != nil {
+			continue
+		}
+		return zoneObj, nil
+	}
+	return nil, fmt.Errorf("%w: %s", errZoneNotFound, zone)
+}
+
+// respond answers r with rcode, unless rcode is one the server writes by itself.
+func respond(w dns.ResponseWriter, r *dns.Msg, rcode int) (int, error) {
+	if !plugin.ClientWrite(rcode) {
+		return rcode, nil
+	}
+	m := new(dns.Msg)
+	m.SetRcode(r, rcode)
+	m.Authoritative = true
+	if err := w.WriteMsg(m); err != nil {
+		log.Errorf("Error writing response: %s", err.Error())
+		return dns.RcodeServerFailure, nil
+	}
+	return rcode, nil
+}
@@ -7,9 +7,14 @@ import (
 
 // Transfer implements the transfer.Transfer interface.
 func (d DynamicUpdate) Transfer(zone string, serial uint32) (<-chan []dns.RR, error) {
-	z := d.Merge(zone)
+	z, nsec3 := d.snapshot(zone)
 	if z == nil {
 		return nil, transfer.ErrNotAuthoritative
 	}
-	return z.Transfer(serial)
+	transferCount.WithLabelValues(zone).Inc()
+	ch, err := z.Transfer(serial)
+	if err != nil || nsec3 == nil {
+		return ch, err
+	}
+	return transferChain(ch, nsec3), nil
 }
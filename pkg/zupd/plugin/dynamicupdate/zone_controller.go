@@ -55,46 +55,89 @@ func (r *DynamicUpdate) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.R
 	// Handle deletion
 	if isDeleting(zone) {
 		log.Debugf("Zone %s/%s is being deleted", req.Namespace, req.Name)
-		if _, ok := r.Zones.Z[dns.Fqdn(zone.Name)]; ok {
+		if r.Zones.static(dns.Fqdn(zone.Name)).zone != nil {
 			log.Debugf("Zone %s/%s is being deleted", req.Namespace, req.Name)
 			r.Zones.DeleteZone(dns.Fqdn(zone.Name))
+			if r.signed != nil {
+				r.signed.set(dns.Fqdn(zone.Name), nil)
+			}
+			deleteZoneMetrics(dns.Fqdn(zone.Name))
+			if r.metrics != nil {
+				r.metrics.RemoveZone(dns.Fqdn(zone.Name))
+			}
 			return ctrl.Result{}, nil
 		}
 	}
 
+	// The keys are read before taking the lock, which is held while the zone is swapped and signed
+	keys, keysErr := r.reloadKeys(ctx, dns.Fqdn(zone.Name), zone)
+
+	r.Zones.Lock()
+	defer r.Zones.Unlock()
 	if r.Zones.DynamicZones == nil {
 		r.Zones.DynamicZones = make(map[string]*file.Zone)
 	}
 	if r.Zones.Z == nil {
 		r.Zones.Z = make(map[string]*file.Zone)
 	}
-	r.Zones.Lock()
-	defer r.Zones.Unlock()
+	if r.Zones.Specs == nil {
+		r.Zones.Specs = make(map[string]rfc1035v1alpha1.ZoneSpec)
+	}
+	if r.Zones.leases == nil {
+		r.Zones.leases = make(map[string]map[string]lease)
+	}
 	if _, ok := r.Zones.Z[dns.Fqdn(zone.Name)]; !ok {
 		// Create a new zone
 		log.Debugf("Creating new zone %s", zone.Name)
-		parsedZone, err := file.Parse(strings.NewReader(zone.Spec.Zone), dns.Fqdn(zone.Name), "stdin", 0)
+		parsedZone, err := file.Parse(strings.NewReader(zone.Spec.ZoneFile()), dns.Fqdn(zone.Name), "stdin", 0)
 		if err != nil {
 			log.Errorf("Failed to parse zone %s: %v", zone.Name, err)
 			return ctrl.Result{}, err
 		}
+		raiseSerial(parsedZone, zone.Status.Serial)
 		r.Zones.Z[dns.Fqdn(zone.Name)] = parsedZone
 		r.Zones.DynamicZones[dns.Fqdn(zone.Name)] = file.NewZone(dns.Fqdn(zone.Name), "")
+		r.Zones.Specs[dns.Fqdn(zone.Name)] = zone.Spec
+		r.Zones.leases[dns.Fqdn(zone.Name)] = make(map[string]lease)
 		r.Zones.Names = append(r.Zones.Names, dns.Fqdn(zone.Name))
-		r.transfer.Notify(dns.Fqdn(zone.Name))
+		if r.metrics != nil {
+			r.metrics.AddZone(dns.Fqdn(zone.Name))
+		}
+		setZoneMetrics(dns.Fqdn(zone.Name), parsedZone, r.Zones.DynamicZones[dns.Fqdn(zone.Name)])
 
 	} else {
 		// Update the zone if it has changed, compare old and new object
 		log.Debugf("Zone %s has changed", zone.Name)
-		parsedZone, err := file.Parse(strings.NewReader(zone.Spec.Zone), dns.Fqdn(zone.Name), "stdin", 0)
+		parsedZone, err := file.Parse(strings.NewReader(zone.Spec.ZoneFile()), dns.Fqdn(zone.Name), "stdin", 0)
 		if err != nil {
 			log.Errorf("Failed to parse zone %s: %v", zone.Name, err)
 			return ctrl.Result{}, err
 		}
+		// The serial never goes back, the dynamic updates may have raised it past the spec
+		raiseSerial(parsedZone, zone.Status.Serial)
+		if old := r.Zones.Z[dns.Fqdn(zone.Name)]; old != nil && old.Apex.SOA != nil {
+			raiseSerial(parsedZone, old.Apex.SOA.Serial+1)
+		}
 		r.Zones.Z[dns.Fqdn(zone.Name)] = parsedZone
-		r.transfer.Notify(dns.Fqdn(zone.Name))
+		r.Zones.Specs[dns.Fqdn(zone.Name)] = zone.Spec
+		setZoneMetrics(dns.Fqdn(zone.Name), parsedZone, nil)
+
+	}
 
+	err = keysErr
+	if err == nil {
+		dz := r.Zones.DynamicZones[dns.Fqdn(zone.Name)]
+		dz.RLock()
+		static := staticZone{zone: r.Zones.Z[dns.Fqdn(zone.Name)], spec: r.Zones.Specs[dns.Fqdn(zone.Name)]}
+		err = r.sign(dns.Fqdn(zone.Name), static, dz, zone, keys)
+		dz.RUnlock()
+	}
+	if err != nil {
+		// Retry, the previous signed snapshot is served meanwhile
+		log.Errorf("Failed to sign zone %s: %v", zone.Name, err)
+		return ctrl.Result{}, err
 	}
+	r.transfer.Notify(dns.Fqdn(zone.Name))
 
 	return ctrl.Result{}, nil
 }
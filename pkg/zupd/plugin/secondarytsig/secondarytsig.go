@@ -0,0 +1,63 @@
+// Package secondarytsig implements a secondary plugin that signs its zone transfer requests with
+// TSIG.
+package secondarytsig
+
+import (
+	"context"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/file"
+	"github.com/coredns/coredns/request"
+	"github.com/miekg/dns"
+)
+
+// SecondaryTSIG retrieves zones (via AXFR) from a primary server, like the secondary plugin,
+// signing the transfer requests with a TSIG key of the tsig plugin.
+type SecondaryTSIG struct {
+	file.File
+	algorithm string
+	// notifiers are the IPs accepted as the source of notifies besides the primaries, e.g. the
+	// pods behind the service of the primary.
+	notifiers []string
+}
+
+// ServeDNS implements the plugin.Handler interface. The notifies of the primaries are handled here
+// to transfer the zone with TSIG, anything else is served by the file plugin.
+func (s SecondaryTSIG) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+	if r.Opcode != dns.OpcodeNotify {
+		return s.File.ServeDNS(ctx, w, r)
+	}
+	state := request.Request{W: w, Req: r}
+	zone := plugin.Zones(s.Names).Matches(state.Name())
+	if zone == "" {
+		return plugin.NextOrFailure(s.Name(), s.Next, ctx, w, r)
+	}
+	z, ok := s.Z[zone]
+	if !ok || z == nil {
+		return dns.RcodeServerFailure, nil
+	}
+	if !isNotify(z, state, s.notifiers) {
+		log.Infof("Dropping notify from %s for %s", state.IP(), zone)
+		return dns.RcodeSuccess, nil
+	}
+
+	m := new(dns.Msg)
+	m.SetReply(r)
+	m.Authoritative = true
+	w.WriteMsg(m)
+
+	log.Infof("Notify from %s for %s: checking transfer", state.IP(), zone)
+	ok, err := shouldTransfer(z, zone)
+	if ok {
+		transferIn(z, zone, s.algorithm)
+	} else {
+		log.Infof("Notify from %s for %s: no SOA serial increase seen", state.IP(), zone)
+	}
+	if err != nil {
+		log.Warningf("Notify from %s for %s: failed primary check: %s", state.IP(), zone, err)
+	}
+	return dns.RcodeSuccess, nil
+}
+
+// Name implements the plugin.Handler interface.
+func (s SecondaryTSIG) Name() string { return "secondarytsig" }
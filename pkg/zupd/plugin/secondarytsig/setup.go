@@ -0,0 +1,178 @@
+package secondarytsig
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/core/dnsserver"
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/file"
+	clog "github.com/coredns/coredns/plugin/pkg/log"
+	"github.com/coredns/coredns/plugin/pkg/parse"
+	"github.com/coredns/coredns/plugin/pkg/upstream"
+	"github.com/miekg/dns"
+)
+
+var log = clog.NewWithPlugin("secondarytsig")
+
+func init() { plugin.Register("secondarytsig", setup) }
+
+func setup(c *caddy.Controller) error {
+	zones, opts, err := secondaryParse(c)
+	if err != nil {
+		return plugin.Error("secondarytsig", err)
+	}
+
+	// The secrets are set by the tsig plugin, which is set up before
+	secrets, err := tsigSecrets(dnsserver.GetConfig(c).TsigSecret, opts.key)
+	if err != nil {
+		return plugin.Error("secondarytsig", err)
+	}
+
+	// Add startup functions to retrieve the zone and keep it up to date.
+	for i := range zones.Names {
+		n := zones.Names[i]
+		z := zones.Z[n]
+		z.TsigSecrets = secrets
+		if len(z.TransferFrom) > 0 {
+			c.OnStartup(func() error {
+				z.StartupOnce.Do(func() {
+					go func() {
+						dur := time.Millisecond * 250
+						step := time.Duration(2)
+						max := time.Second * 10
+						for {
+							err := transferIn(z, n, opts.algorithm)
+							if err == nil {
+								break
+							}
+							log.Warningf("All '%s' masters failed to transfer, retrying in %s: %s", n, dur.String(), err)
+							time.Sleep(dur)
+							dur = step * dur
+							if dur > max {
+								dur = max
+							}
+						}
+						update(z, n, opts.algorithm)
+					}()
+				})
+				return nil
+			})
+		}
+	}
+
+	dnsserver.GetConfig(c).AddPlugin(func(next plugin.Handler) plugin.Handler {
+		return SecondaryTSIG{File: file.File{Next: next, Zones: zones}, algorithm: opts.algorithm, notifiers: opts.notifiers}
+	})
+
+	return nil
+}
+
+// tsigSecrets returns the secret of key out of secrets, as the only secret used to sign the
+// transfer requests. If key is empty, secrets must hold a single key.
+func tsigSecrets(secrets map[string]string, key string) (map[string]string, error) {
+	if key == "" {
+		if len(secrets) != 1 {
+			names := make([]string, 0, len(secrets))
+			for k := range secrets {
+				names = append(names, k)
+			}
+			sort.Strings(names)
+			return nil, fmt.Errorf("a single TSIG key is required without a key property, found %v", names)
+		}
+		return secrets, nil
+	}
+	key = plugin.Name(key).Normalize()
+	secret, ok := secrets[key]
+	if !ok {
+		return nil, fmt.Errorf("TSIG key %q is not defined in the tsig plugin", key)
+	}
+	return map[string]string{key: secret}, nil
+}
+
+// options are the properties of the plugin besides the transfers.
+type options struct {
+	// key is the name of the TSIG key signing the transfers
+	key string
+	// algorithm is the algorithm of the TSIG key
+	algorithm string
+	// notifiers are the IPs accepted as the source of notifies besides the primaries
+	notifiers []string
+}
+
+// algorithms are the supported TSIG algorithms.
+var algorithms = map[string]bool{
+	dns.HmacSHA1:   true,
+	dns.HmacSHA224: true,
+	dns.HmacSHA256: true,
+	dns.HmacSHA384: true,
+	dns.HmacSHA512: true,
+}
+
+func secondaryParse(c *caddy.Controller) (file.Zones, options, error) {
+	z := make(map[string]*file.Zone)
+	names := []string{}
+	opts := options{algorithm: dns.HmacSHA256}
+	for c.Next() {
+		if c.Val() == "secondarytsig" {
+			// secondarytsig [origin]
+			origins := plugin.OriginsFromArgsOrServerBlock(c.RemainingArgs(), c.ServerBlockKeys)
+			for i := range origins {
+				z[origins[i]] = file.NewZone(origins[i], "stdin")
+				names = append(names, origins[i])
+			}
+
+			for c.NextBlock() {
+				var f []string
+
+				switch c.Val() {
+				case "transfer":
+					var err error
+					f, err = parse.TransferIn(c)
+					if err != nil {
+						return file.Zones{}, options{}, err
+					}
+				case "key":
+					if !c.NextArg() {
+						return file.Zones{}, options{}, c.ArgErr()
+					}
+					opts.key = c.Val()
+				case "algorithm":
+					if !c.NextArg() {
+						return file.Zones{}, options{}, c.ArgErr()
+					}
+					opts.algorithm = strings.ToLower(dns.Fqdn(c.Val()))
+					if !algorithms[opts.algorithm] {
+						return file.Zones{}, options{}, c.Errf("unknown TSIG algorithm '%s'", c.Val())
+					}
+				case "notify":
+					args := c.RemainingArgs()
+					if len(args) == 0 {
+						return file.Zones{}, options{}, c.ArgErr()
+					}
+					for _, a := range args {
+						ip := net.ParseIP(a)
+						if ip == nil {
+							return file.Zones{}, options{}, c.Errf("invalid notify address '%s'", a)
+						}
+						opts.notifiers = append(opts.notifiers, ip.String())
+					}
+				default:
+					return file.Zones{}, options{}, c.Errf("unknown property '%s'", c.Val())
+				}
+
+				for _, origin := range origins {
+					if f != nil {
+						z[origin].TransferFrom = append(z[origin].TransferFrom, f...)
+					}
+					z[origin].Upstream = upstream.New()
+				}
+			}
+		}
+	}
+	return file.Zones{Z: z, Names: names}, opts, nil
+}
@@ -0,0 +1,127 @@
+package secondarytsig
+
+import (
+	"testing"
+
+	"github.com/coredns/caddy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSecondaryParse(t *testing.T) {
+	testCases := []struct {
+		name         string
+		input        string
+		shouldErr    bool
+		transferFrom []string
+		key          string
+		algorithm    string
+		notifiers    []string
+		zones        []string
+	}{
+		{
+			name: "Transfer",
+			input: `secondarytsig example.org {
+				transfer from 10.0.0.1:1053 10.0.0.2
+			}`,
+			transferFrom: []string{"10.0.0.1:1053", "10.0.0.2:53"},
+			algorithm:    "hmac-sha256.",
+			zones:        []string{"example.org."},
+		},
+		{
+			name: "Key",
+			input: `secondarytsig example.org example.net {
+				transfer from 10.0.0.1:1053
+				key ksdns.tsigKey
+				algorithm HMAC-SHA512
+			}`,
+			transferFrom: []string{"10.0.0.1:1053"},
+			key:          "ksdns.tsigKey",
+			algorithm:    "hmac-sha512.",
+			zones:        []string{"example.org.", "example.net."},
+		},
+		{
+			name: "Notify",
+			input: `secondarytsig example.org {
+				transfer from 10.0.0.1:1053
+				notify 10.0.1.1 fd00::1
+			}`,
+			transferFrom: []string{"10.0.0.1:1053"},
+			algorithm:    "hmac-sha256.",
+			notifiers:    []string{"10.0.1.1", "fd00::1"},
+			zones:        []string{"example.org."},
+		},
+		{
+			name: "Notify without address",
+			input: `secondarytsig example.org {
+				notify
+			}`,
+			shouldErr: true,
+		},
+		{
+			name: "Invalid notify address",
+			input: `secondarytsig example.org {
+				notify 10.0.1.1:1053
+			}`,
+			shouldErr: true,
+		},
+		{
+			name: "Key without name",
+			input: `secondarytsig example.org {
+				key
+			}`,
+			shouldErr: true,
+		},
+		{
+			name: "Unknown algorithm",
+			input: `secondarytsig example.org {
+				algorithm hmac-md5
+			}`,
+			shouldErr: true,
+		},
+		{
+			name: "Unknown property",
+			input: `secondarytsig example.org {
+				foo bar
+			}`,
+			shouldErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := caddy.NewTestController("dns", tc.input)
+			zones, opts, err := secondaryParse(c)
+			if tc.shouldErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.key, opts.key)
+			assert.Equal(t, tc.algorithm, opts.algorithm)
+			assert.Equal(t, tc.notifiers, opts.notifiers)
+			assert.Equal(t, tc.zones, zones.Names)
+			for _, name := range tc.zones {
+				assert.Equal(t, tc.transferFrom, zones.Z[name].TransferFrom)
+			}
+		})
+	}
+}
+
+func TestTSIGSecrets(t *testing.T) {
+	secrets := map[string]string{"ksdns.tsigkey.": "c2VjcmV0", "other.": "b3RoZXI="}
+
+	_, err := tsigSecrets(secrets, "")
+	assert.Error(t, err)
+
+	s, err := tsigSecrets(secrets, "ksdns.tsigKey")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"ksdns.tsigkey.": "c2VjcmV0"}, s)
+
+	_, err = tsigSecrets(secrets, "missing")
+	assert.Error(t, err)
+
+	s, err = tsigSecrets(map[string]string{"ksdns.tsigkey.": "c2VjcmV0"}, "")
+	require.NoError(t, err)
+	assert.Len(t, s, 1)
+}
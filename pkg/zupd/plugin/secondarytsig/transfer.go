@@ -0,0 +1,241 @@
+package secondarytsig
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"time"
+
+	"github.com/coredns/coredns/plugin/file"
+	"github.com/coredns/coredns/request"
+	"github.com/miekg/dns"
+)
+
+// The transfers of the file plugin sign the requests with hmac-md5, which is no longer supported
+// by miekg/dns, so the zones are transferred here with the algorithm of the plugin.
+
+// transferIn retrieves the zone origin of z from its primaries, signing the request with the TSIG
+// key of z and algorithm.
+func transferIn(z *file.Zone, origin, algorithm string) error {
+	if len(z.TransferFrom) == 0 {
+		return fmt.Errorf("no primaries to transfer %s from", origin)
+	}
+	m := new(dns.Msg)
+	m.SetAxfr(origin)
+	sign(m, z.TsigSecrets, algorithm)
+
+	z1 := z.CopyWithoutApex()
+	var (
+		Err error
+		tr  string
+	)
+
+Transfer:
+	for _, tr = range z.TransferFrom {
+		t := new(dns.Transfer)
+		t.TsigSecret = z.TsigSecrets
+		c, err := t.In(m, tr)
+		if err != nil {
+			log.Errorf("Failed to setup transfer `%s' with `%q': %v", origin, tr, err)
+			Err = err
+			continue Transfer
+		}
+		for env := range c {
+			if env.Error != nil {
+				log.Errorf("Failed to transfer `%s' from %q: %v", origin, tr, env.Error)
+				Err = env.Error
+				continue Transfer
+			}
+			for _, rr := range env.RR {
+				if err := z1.Insert(rr); err != nil {
+					log.Errorf("Failed to parse transfer `%s' from: %q: %v", origin, tr, err)
+					Err = err
+					continue Transfer
+				}
+			}
+		}
+		Err = nil
+		break
+	}
+	if Err != nil {
+		return Err
+	}
+
+	z.Lock()
+	z.Tree = z1.Tree
+	z.Apex = z1.Apex
+	z.Expired = false
+	z.Unlock()
+	log.Infof("Transferred: %s from %s", origin, tr)
+	return nil
+}
+
+// sign signs m with the key of secrets, which holds a single key, and algorithm.
+func sign(m *dns.Msg, secrets map[string]string, algorithm string) {
+	for k := range secrets {
+		m.SetTsig(k, algorithm, 300, time.Now().Unix())
+		return
+	}
+}
+
+// shouldTransfer returns true if the SOA serial of the primaries of z is higher than the one of z.
+func shouldTransfer(z *file.Zone, origin string) (bool, error) {
+	c := new(dns.Client)
+	c.Net = "tcp" // do this query over TCP to minimize spoofing
+	m := new(dns.Msg)
+	m.SetQuestion(origin, dns.TypeSOA)
+
+	var Err error
+	serial := -1
+
+Transfer:
+	for _, tr := range z.TransferFrom {
+		Err = nil
+		ret, _, err := c.Exchange(m, tr)
+		if err != nil || ret.Rcode != dns.RcodeSuccess {
+			Err = err
+			continue
+		}
+		for _, a := range ret.Answer {
+			if a.Header().Rrtype == dns.TypeSOA {
+				serial = int(a.(*dns.SOA).Serial)
+				break Transfer
+			}
+		}
+	}
+	if serial == -1 {
+		return false, Err
+	}
+	z.RLock()
+	soa := z.Apex.SOA
+	z.RUnlock()
+	if soa == nil {
+		return true, Err
+	}
+	return less(soa.Serial, uint32(serial)), Err
+}
+
+// less returns true of a is smaller than b when taking RFC 1982 serial arithmetic into account.
+func less(a, b uint32) bool {
+	if a < b {
+		return (b - a) <= file.MaxSerialIncrement
+	}
+	return (a - b) > file.MaxSerialIncrement
+}
+
+// update keeps z up to date according to its SOA, as file.Zone.Update. It runs for the life time
+// of the server.
+func update(z *file.Zone, origin, algorithm string) {
+	// If we don't have a SOA, we don't have a zone, wait for it to appear.
+	for soa(z) == nil {
+		time.Sleep(1 * time.Second)
+	}
+	retryActive := false
+
+Restart:
+	apex := soa(z)
+	refreshTicker := time.NewTicker(time.Second * time.Duration(apex.Refresh))
+	retryTicker := time.NewTicker(time.Second * time.Duration(apex.Retry))
+	expireTicker := time.NewTicker(time.Second * time.Duration(apex.Expire))
+
+	for {
+		select {
+		case <-expireTicker.C:
+			if !retryActive {
+				break
+			}
+			z.Lock()
+			z.Expired = true
+			z.Unlock()
+
+		case <-retryTicker.C:
+			if !retryActive {
+				break
+			}
+
+			time.Sleep(jitter(2000)) // 2s randomize
+
+			ok, err := shouldTransfer(z, origin)
+			if err != nil {
+				log.Warningf("Failed retry check %s", err)
+				continue
+			}
+
+			if ok {
+				if err := transferIn(z, origin, algorithm); err != nil {
+					// transfer failed, leave retryActive true
+					break
+				}
+			}
+
+			// no errors, stop timers and restart
+			retryActive = false
+			refreshTicker.Stop()
+			retryTicker.Stop()
+			expireTicker.Stop()
+			goto Restart
+
+		case <-refreshTicker.C:
+
+			time.Sleep(jitter(5000)) // 5s randomize
+
+			ok, err := shouldTransfer(z, origin)
+			if err != nil {
+				log.Warningf("Failed refresh check %s", err)
+				retryActive = true
+				continue
+			}
+
+			if ok {
+				if err := transferIn(z, origin, algorithm); err != nil {
+					// transfer failed
+					retryActive = true
+					break
+				}
+			}
+
+			// no errors, stop timers and restart
+			retryActive = false
+			refreshTicker.Stop()
+			retryTicker.Stop()
+			expireTicker.Stop()
+			goto Restart
+		}
+	}
+}
+
+// soa returns the SOA record of z, nil until the zone is transferred.
+func soa(z *file.Zone) *dns.SOA {
+	z.RLock()
+	defer z.RUnlock()
+	return z.Apex.SOA
+}
+
+// isNotify returns true if the notify of state comes from one of the primaries of z or one of
+// notifiers.
+func isNotify(z *file.Zone, state request.Request, notifiers []string) bool {
+	remote := state.IP()
+	if ip := net.ParseIP(remote); ip != nil {
+		for _, n := range notifiers {
+			if ip.Equal(net.ParseIP(n)) {
+				return true
+			}
+		}
+	}
+	for _, f := range z.TransferFrom {
+		from, _, err := net.SplitHostPort(f)
+		if err != nil {
+			continue
+		}
+		if from == remote {
+			return true
+		}
+	}
+	return false
+}
+
+// jitter returns a random duration between [0,n) * time.Millisecond
+func jitter(n int) time.Duration {
+	r := rand.Intn(n)
+	return time.Duration(r) * time.Millisecond
+}
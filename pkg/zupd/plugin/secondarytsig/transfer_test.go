@@ -0,0 +1,107 @@
+package secondarytsig
+
+import (
+	"net"
+	"testing"
+
+	"github.com/coredns/coredns/plugin/file"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/coredns/coredns/request"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testKey    = "ksdns.tsigkey."
+	testSecret = "c2VjcmV0c2VjcmV0c2VjcmV0c2VjcmV0c2VjcmV0cw=="
+)
+
+// serveAXFR serves the AXFR of example.org. over TCP, refusing the requests not signed with
+// testKey and algorithm. It returns the address of the server.
+func serveAXFR(t *testing.T, algorithm string) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	soa, err := dns.NewRR("example.org. 3600 IN SOA ns1.example.org. admin.example.org. 42 7200 3600 1209600 3600")
+	require.NoError(t, err)
+	a, err := dns.NewRR("www.example.org. 3600 IN A 10.0.0.1")
+	require.NoError(t, err)
+
+	server := &dns.Server{
+		Listener:   l,
+		TsigSecret: map[string]string{testKey: testSecret},
+		Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+			m := new(dns.Msg)
+			m.SetReply(r)
+			sig := r.IsTsig()
+			if sig == nil || w.TsigStatus() != nil || sig.Algorithm != algorithm {
+				m.Rcode = dns.RcodeRefused
+				w.WriteMsg(m)
+				return
+			}
+			ch := make(chan *dns.Envelope, 1)
+			tr := new(dns.Transfer)
+			go func() {
+				ch <- &dns.Envelope{RR: []dns.RR{soa, a, soa}}
+				close(ch)
+			}()
+			tr.Out(w, r, ch)
+			w.Close()
+		}),
+	}
+	started := make(chan struct{})
+	server.NotifyStartedFunc = func() { close(started) }
+	go server.ActivateAndServe()
+	<-started
+	t.Cleanup(func() { server.Shutdown() })
+	return l.Addr().String()
+}
+
+func TestTransferIn(t *testing.T) {
+	for _, algorithm := range []string{dns.HmacSHA256, dns.HmacSHA512} {
+		t.Run(algorithm, func(t *testing.T) {
+			addr := serveAXFR(t, algorithm)
+
+			z := file.NewZone("example.org.", "stdin")
+			z.TransferFrom = []string{addr}
+			z.TsigSecrets = map[string]string{testKey: testSecret}
+			require.NoError(t, transferIn(z, "example.org.", algorithm))
+			require.NotNil(t, z.Apex.SOA)
+			assert.Equal(t, uint32(42), z.Apex.SOA.Serial)
+
+			// Signed with another algorithm
+			z = file.NewZone("example.org.", "stdin")
+			z.TransferFrom = []string{addr}
+			z.TsigSecrets = map[string]string{testKey: testSecret}
+			assert.Error(t, transferIn(z, "example.org.", dns.HmacSHA1))
+			assert.Nil(t, z.Apex.SOA)
+
+			// Not signed
+			z.TsigSecrets = nil
+			assert.Error(t, transferIn(z, "example.org.", algorithm))
+		})
+	}
+}
+
+func TestIsNotify(t *testing.T) {
+	z := file.NewZone("example.org.", "stdin")
+	z.TransferFrom = []string{"10.96.0.20:1053"}
+	notify := func(ip string) request.Request {
+		m := new(dns.Msg)
+		m.SetNotify("example.org.")
+		return request.Request{W: &test.ResponseWriter{RemoteIP: ip}, Req: m}
+	}
+	assert.True(t, isNotify(z, notify("10.96.0.20"), nil), "primary")
+	assert.False(t, isNotify(z, notify("10.0.1.1"), nil), "unknown source")
+	assert.True(t, isNotify(z, notify("10.0.1.1"), []string{"10.0.1.1"}), "pod of the primary")
+	assert.True(t, isNotify(z, notify("fd00::1"), []string{"fd00:0::1"}), "pod of the primary, IPv6")
+	assert.False(t, isNotify(z, notify("10.0.1.2"), []string{"10.0.1.1"}), "other pod")
+}
+
+func TestLess(t *testing.T) {
+	assert.True(t, less(1, 2))
+	assert.False(t, less(2, 1))
+	assert.True(t, less(4294967295, 1))
+}
@@ -58,6 +58,7 @@ var Directives = []string{
 	"dynamicupdate",
 	"auto",
 	"secondary",
+	"secondarytsig",
 	"etcd",
 	"loop",
 	"forward",